@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
 	"github.com/joho/godotenv"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantisgithub"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/drifter"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/httpclient"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/notification"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/planstore"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/retrybudget"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/secrets"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/statelister"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/terraform"
 
 	// Empty import allows pinning to version atlantis uses
@@ -23,21 +32,126 @@ import (
 )
 
 type config struct {
-	Repo                   string        `env:"REPO,required"`
+	Repo                   string        `env:"REPO"`
+	ManifestPath           string        `env:"MANIFEST_PATH"`
 	AtlantisHostname       string        `env:"ATLANTIS_HOST,required"`
 	AtlantisToken          string        `env:"ATLANTIS_TOKEN,required"`
+	AtlantisHeaders        []string      `env:"ATLANTIS_HEADERS"`
+	AtlantisMaxResponse    int64         `env:"ATLANTIS_MAX_RESPONSE_BYTES"`
 	DirectoryAllowlist     []string      `env:"DIRECTORY_ALLOWLIST"`
+	AllowlistMatchMode     string        `env:"DIRECTORY_ALLOWLIST_MATCH_MODE,default=contains"`
+	WorkspaceAuditAllow    []string      `env:"WORKSPACE_AUDIT_ALLOWLIST"`
+	WorkspaceAuditDeny     []string      `env:"WORKSPACE_AUDIT_DENYLIST"`
 	SlackWebhookURL        string        `env:"SLACK_WEBHOOK_URL"`
+	SlackWebhookURLSecret  string        `env:"SLACK_WEBHOOK_URL_SECRET"`
+	SlackWebhookRetries    int           `env:"SLACK_WEBHOOK_RETRIES"`
+	DeadLetterFile         string        `env:"DEAD_LETTER_FILE"`
+	SlackRunLifecycle      bool          `env:"SLACK_RUN_LIFECYCLE"`
+	SlackUseBlocks         bool          `env:"SLACK_USE_BLOCKS"`
+	SlackRouteWebhooks     []string      `env:"SLACK_ROUTE_WEBHOOKS"`
+	SlackEmoji             []string      `env:"SLACK_EMOJI"`
+	RootModuleHeuristics   bool          `env:"ROOT_MODULE_HEURISTICS"`
+	RootModuleExcludes     []string      `env:"ROOT_MODULE_EXCLUDE_SEGMENTS"`
+	MaxNotifications       int           `env:"MAX_NOTIFICATIONS_PER_RUN"`
 	AtlantisRepoConfigPath string        `env:"ATLANTIS_REPO_CONFIG_PATH,default=.atlantis/atlantis.yml"`
+	OverlayPath            string        `env:"OVERLAY_PATH"`
 	SkipWorkspaceCheck     bool          `env:"SKIP_WORKSPACE_CHECK,default=true"`
-	ParallelRuns           int           `env:"PARALLEL_RUNS,default=1"`
+	PlanExtraWorkspaces    bool          `env:"PLAN_EXTRA_WORKSPACES"`
+	Phases                 []string      `env:"PHASES"`
+	ParallelRuns           string        `env:"PARALLEL_RUNS,default=1"`
 	DynamodbTable          string        `env:"DYNAMODB_TABLE"`
+	PersistRunReports      bool          `env:"PERSIST_RUN_REPORTS"`
+	DynamodbReportIndex    string        `env:"DYNAMODB_REPORT_INDEX"`
 	CacheValidDuration     time.Duration `env:"CACHE_VALID_DURATION,default=24h"`
+	TemporaryErrorCache    time.Duration `env:"TEMPORARY_ERROR_CACHE_DURATION"`
 	WorkflowOwner          string        `env:"WORKFLOW_OWNER"`
 	WorkflowRepo           string        `env:"WORKFLOW_REPO"`
 	WorkflowId             string        `env:"WORKFLOW_ID"`
 	WorkflowRef            string        `env:"WORKFLOW_REF"`
 	AutoGenerateConfig     bool          `env:"AUTO_GENERATE_ATLANTIS_CONFIG,default=true"`
+	CachedResultsWarning   float64       `env:"CACHED_RESULTS_WARNING_THRESHOLD,default=0"`
+	PrioritizeStale        bool          `env:"PRIORITIZE_STALE"`
+	PreInitCommand         string        `env:"PRE_INIT_COMMAND"`
+	CheckUnmanagedDirs     bool          `env:"CHECK_UNMANAGED_DIRECTORIES,default=false"`
+	CABundleFile           string        `env:"CA_BUNDLE_FILE"`
+	GitProxyURL            string        `env:"GIT_PROXY_URL"`
+	GitProxyUsername       string        `env:"GIT_PROXY_USERNAME"`
+	GitProxyPassword       string        `env:"GIT_PROXY_PASSWORD"`
+	MaxConcurrentInits     int           `env:"MAX_CONCURRENT_INITS,default=0"`
+	InitRetries            int           `env:"TERRAFORM_INIT_RETRIES,default=0"`
+	InitBackoff            time.Duration `env:"TERRAFORM_INIT_BACKOFF,default=5s"`
+	RetryBudget            int           `env:"RETRY_BUDGET,default=100"`
+	IsolatedTerraformHome  bool          `env:"ISOLATED_TERRAFORM_HOME"`
+	TerraformVersion       string        `env:"TERRAFORM_REQUIRED_VERSION"`
+	MaintenanceWindows     []string      `env:"MAINTENANCE_WINDOWS"`
+	CheckGeneratedConfig   bool          `env:"CHECK_GENERATED_ATLANTIS_CONFIG,default=false"`
+	SkipInitIfInitialized  bool          `env:"SKIP_INIT_IF_INITIALIZED,default=false"`
+	CompareRefs            []string      `env:"COMPARE_REFS"`
+	PlanRef                string        `env:"PLAN_REF"`
+	Branch                 string        `env:"BRANCH"`
+	CheckPendingApplies    bool          `env:"CHECK_PENDING_APPLIES"`
+	GithubAPIURL           string        `env:"GITHUB_API_URL"`
+	PlanRepo               string        `env:"PLAN_REPO"`
+	DriftGracePeriod       time.Duration `env:"DRIFT_GRACE_PERIOD"`
+	NotifyOnlyChangedDrift bool          `env:"NOTIFY_ONLY_CHANGED_DRIFT"`
+	NotifyOnClean          bool          `env:"NOTIFY_ON_CLEAN,default=true"`
+	ReNotifyInterval       time.Duration `env:"RENOTIFY_INTERVAL"`
+	PlanCommentArgs        []string      `env:"PLAN_COMMENT_ARGS"`
+	BatchPlanSummaries     bool          `env:"BATCH_PLAN_SUMMARIES"`
+	PlanByProjectName      bool          `env:"PLAN_BY_PROJECT_NAME"`
+	RunID                  string        `env:"GITHUB_RUN_ID"`
+	ResumeFromCache        bool          `env:"RESUME_FROM_CACHE"`
+	MetricsFile            string        `env:"METRICS_FILE"`
+	JUnitReportPath        string        `env:"JUNIT_REPORT_PATH"`
+	PostRunCommand         string        `env:"POST_RUN_COMMAND"`
+	FailOnPostRunError     bool          `env:"FAIL_ON_POST_RUN_COMMAND_ERROR"`
+	ReportDriftedOnly      bool          `env:"REPORT_DRIFTED_ONLY"`
+	FailOnRefMismatch      bool          `env:"FAIL_ON_REF_MISMATCH"`
+	FailOnNoProjects       bool          `env:"FAIL_ON_NO_PROJECTS"`
+	DuplicateProjects      string        `env:"DUPLICATE_PROJECT_POLICY"`
+	MaxCliffnoteLines      int           `env:"MAX_CLIFFNOTE_LINES"`
+	PlanStoreURL           string        `env:"PLAN_STORE_URL"`
+	LockedPlanBehavior     string        `env:"LOCKED_PLAN_BEHAVIOR"`
+	RunLockTTL             time.Duration `env:"RUN_LOCK_TTL"`
+	RunLockBehavior        string        `env:"RUN_LOCK_BEHAVIOR"`
+	ValidateConfig         bool          `env:"VALIDATE_ATLANTIS_CONFIG"`
+	OrderNotifications     bool          `env:"ORDER_NOTIFICATIONS"`
+	SamplePercent          float32       `env:"SAMPLE_PERCENT"`
+	BootstrapMode          bool          `env:"BOOTSTRAP_MODE"`
+	GithubAppID            int64         `env:"GITHUB_APP_ID"`
+	GithubAppInstallID     int64         `env:"GITHUB_APP_INSTALLATION_ID"`
+	GithubAppPrivateKey    string        `env:"GITHUB_APP_PRIVATE_KEY_SECRET"`
+	AMQPURL                string        `env:"AMQP_URL"`
+	AMQPExchange           string        `env:"AMQP_EXCHANGE"`
+	AMQPRoutingKeyPrefix   string        `env:"AMQP_ROUTING_KEY_PREFIX,default=drift."`
+	PagerDutyRoutingKey    string        `env:"PAGERDUTY_ROUTING_KEY"`
+	PagerDutySeverity      string        `env:"PAGERDUTY_SEVERITY"`
+	SMTPAddr               string        `env:"SMTP_ADDR"`
+	SMTPUsername           string        `env:"SMTP_USERNAME"`
+	SMTPPassword           string        `env:"SMTP_PASSWORD"`
+	SMTPImplicitTLS        bool          `env:"SMTP_IMPLICIT_TLS"`
+	SMTPRequireTLS         bool          `env:"SMTP_REQUIRE_TLS,default=true"`
+	EmailFrom              string        `env:"EMAIL_FROM"`
+	EmailTo                []string      `env:"EMAIL_TO"`
+	EmailDigest            bool          `env:"EMAIL_DIGEST"`
+	GithubIssues           bool          `env:"GITHUB_ISSUES"`
+	GithubIssuesRepo       string        `env:"GITHUB_ISSUES_REPO"`
+	GithubIssuesLabel      string        `env:"GITHUB_ISSUES_LABEL"`
+	GithubCheckRun         bool          `env:"GITHUB_CHECK_RUN"`
+	GithubCheckRunName     string        `env:"GITHUB_CHECK_RUN_NAME"`
+	PrintGeneratedConfig   bool          `env:"PRINT_GENERATED_CONFIG"`
+	IgnoreResourceTypes    []string      `env:"IGNORE_RESOURCE_TYPES"`
+	OutputsOnlyDrift       string        `env:"OUTPUTS_ONLY_DRIFT_POLICY"`
+	ConcurrentNotify       bool          `env:"CONCURRENT_NOTIFICATIONS"`
+	MaxNotifyBackends      int           `env:"MAX_CONCURRENT_NOTIFICATION_BACKENDS"`
+	OrphanedStateS3Bucket  string        `env:"ORPHANED_STATE_S3_BUCKET"`
+	OrphanedStateS3Prefix  string        `env:"ORPHANED_STATE_S3_PREFIX"`
+	OrphanedStateCommand   string        `env:"ORPHANED_STATE_COMMAND"`
+	StateKeyPattern        string        `env:"STATE_KEY_PATTERN"`
+	WorkspaceKeyPrefix     string        `env:"WORKSPACE_KEY_PREFIX"`
+	SecretPatterns         []string      `env:"SECRET_PATTERNS"`
+	RedactTFVarValues      bool          `env:"REDACT_TF_VAR_VALUES"`
+	TableOutput            bool          `env:"TABLE_OUTPUT"`
 }
 
 func loadEnvIfExists() error {
@@ -77,6 +191,9 @@ func (z *zapGogitLogger) Info(_ context.Context, msg string, strings map[string]
 var _ gogit.Logger = (*zapGogitLogger)(nil)
 
 func main() {
+	generateOnly := flag.Bool("generate-only", false, "print the generated atlantis config to stdout and exit without checking for drift")
+	testNotifications := flag.Bool("test-notifications", false, "send a test message through every notification backend and exit without checking for drift")
+	flag.Parse()
 	ctx := context.Background()
 	zapCfg := zap.NewProductionConfig()
 	zapCfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
@@ -91,64 +208,522 @@ func main() {
 	if err := envdecode.Decode(&cfg); err != nil {
 		logger.Panic("failed to decode config", zap.Error(err))
 	}
+	allowlistMatchMode, err := drifter.ParseAllowlistMatchMode(cfg.AllowlistMatchMode)
+	if err != nil {
+		logger.Panic("invalid directory allowlist match mode", zap.Error(err))
+	}
+	lockedPlanBehavior, err := drifter.ParseLockedPlanBehavior(cfg.LockedPlanBehavior)
+	if err != nil {
+		logger.Panic("invalid locked plan behavior", zap.Error(err))
+	}
+	outputsOnlyDriftPolicy, err := drifter.ParseOutputsOnlyDriftPolicy(cfg.OutputsOnlyDrift)
+	if err != nil {
+		logger.Panic("invalid outputs only drift policy", zap.Error(err))
+	}
+	phases, err := drifter.ParsePhases(cfg.Phases)
+	if err != nil {
+		logger.Panic("invalid phases", zap.Error(err))
+	}
+	duplicateProjectPolicy, err := drifter.ParseDuplicateProjectPolicy(cfg.DuplicateProjects)
+	if err != nil {
+		logger.Panic("invalid duplicate project policy", zap.Error(err))
+	}
+	parallelRuns, err := drifter.ParseParallelRuns(cfg.ParallelRuns)
+	if err != nil {
+		logger.Panic("invalid parallel runs", zap.Error(err))
+	}
+	logger.Info("Parallel runs", zap.Int("parallel-runs", parallelRuns), zap.Bool("auto", cfg.ParallelRuns == drifter.ParallelRunsAuto))
+	runLockBehavior, err := drifter.ParseRunLockBehavior(cfg.RunLockBehavior)
+	if err != nil {
+		logger.Panic("invalid run lock behavior", zap.Error(err))
+	}
+	if cfg.CachedResultsWarning < 0 || cfg.CachedResultsWarning > 1 {
+		logger.Panic("cached results warning threshold must be a fraction between 0 and 1", zap.Float64("threshold", cfg.CachedResultsWarning))
+	}
+	maintenanceWindows, err := drifter.ParseMaintenanceWindows(cfg.MaintenanceWindows)
+	if err != nil {
+		logger.Panic("invalid maintenance windows", zap.Error(err))
+	}
+	atlantisHeaders, err := atlantis.ParseHeaders(cfg.AtlantisHeaders)
+	if err != nil {
+		logger.Panic("invalid atlantis headers", zap.Error(err))
+	}
+	httpClient, err := httpclient.NewWithCAFile(cfg.CABundleFile)
+	if err != nil {
+		logger.Panic("failed to create http client", zap.Error(err))
+	}
+	if err := atlantisgithub.ConfigureGitProxy(cfg.GitProxyURL, cfg.GitProxyUsername, cfg.GitProxyPassword); err != nil {
+		logger.Panic("failed to configure git proxy", zap.Error(err))
+	}
 	cloner := &gogit.Cloner{
 		Logger: &zapGogitLogger{logger},
 	}
+	retryBudget := retrybudget.New(cfg.RetryBudget)
 	notif := &notification.Multi{
 		Notifications: []notification.Notification{
 			&notification.Zap{Logger: logger.With(zap.String("notification", "true"))},
 		},
 	}
-	if slackClient := notification.NewSlackWebhook(cfg.SlackWebhookURL, http.DefaultClient); slackClient != nil {
+	deadLetter := notification.NewDeadLetter(cfg.DeadLetterFile)
+	var deadLetterSenders []notification.DeadLetterSender
+	slackWebhookURL := cfg.SlackWebhookURL
+	if cfg.SlackWebhookURLSecret != "" {
+		if slackWebhookURL != "" {
+			logger.Panic("set SLACK_WEBHOOK_URL or SLACK_WEBHOOK_URL_SECRET, not both")
+		}
+		if slackWebhookURL, err = secrets.DefaultSchemes().Resolve(ctx, cfg.SlackWebhookURLSecret); err != nil {
+			logger.Panic("failed to resolve slack webhook url", zap.Error(err))
+		}
+	}
+	slackEmoji := make(map[string]string, len(cfg.SlackEmoji))
+	for _, e := range cfg.SlackEmoji {
+		name, emoji, ok := strings.Cut(e, "=")
+		if !ok {
+			logger.Panic("invalid slack emoji, expected icon=emoji", zap.String("emoji", e))
+		}
+		if _, exists := notification.DefaultSlackEmoji[name]; !exists {
+			logger.Panic("unknown slack icon", zap.String("icon", name))
+		}
+		slackEmoji[name] = emoji
+	}
+	if wh := notification.NewSlackWebhook(slackWebhookURL, httpClient); wh != nil {
 		logger.Info("setting up slack webhook notification")
-		notif.Notifications = append(notif.Notifications, slackClient)
+		wh.Emoji = slackEmoji
+		wh.Retries = cfg.SlackWebhookRetries
+		wh.RetryDelay = time.Second
+		wh.RetryBudget = retryBudget
+		wh.DeadLetter = deadLetter
+		wh.RunLifecycle = cfg.SlackRunLifecycle
+		wh.UseBlocks = cfg.SlackUseBlocks
+		deadLetterSenders = append(deadLetterSenders, wh)
+		notif.Notifications = append(notif.Notifications, wh)
 	}
-	var existingConfig *gogithub.NewGQLClientConfig
-	if os.Getenv("GITHUB_TOKEN") != "" {
-		existingConfig = &gogithub.NewGQLClientConfig{Token: os.Getenv("GITHUB_TOKEN")}
+	routedNotifications := make(map[string]notification.Notification, len(cfg.SlackRouteWebhooks))
+	for _, route := range cfg.SlackRouteWebhooks {
+		name, ref, ok := strings.Cut(route, "=")
+		if !ok || name == "" {
+			logger.Panic("invalid slack route webhook, expected name=secret", zap.String("route", route))
+		}
+		wh, err := notification.NewSlackWebhookFromSecret(ctx, secrets.DefaultSchemes(), ref, httpClient)
+		if err != nil {
+			logger.Panic("failed to set up routed slack webhook", zap.String("name", name), zap.Error(err))
+		}
+		if wh == nil {
+			logger.Panic("routed slack webhook has no secret", zap.String("name", name))
+		}
+		wh.Retries = cfg.SlackWebhookRetries
+		wh.RetryDelay = time.Second
+		wh.RetryBudget = retryBudget
+		wh.Emoji = slackEmoji
+		wh.UseBlocks = cfg.SlackUseBlocks
+		routedNotifications[name] = wh
+	}
+	var ghClient gogithub.GitHub
+	if cfg.GithubAppID != 0 {
+		logger.Info("setting up github app client")
+		privateKey, err := secrets.DefaultSchemes().Resolve(ctx, cfg.GithubAppPrivateKey)
+		if err != nil {
+			logger.Panic("failed to resolve github app private key", zap.Error(err))
+		}
+		ghClient, err = atlantisgithub.NewAppClient(ctx, logger, httpClient.Transport, cfg.GithubAppID, cfg.GithubAppInstallID, privateKey)
+		if err != nil {
+			logger.Panic("failed to create github client", zap.Error(err))
+		}
+	} else {
+		var existingConfig *gogithub.NewGQLClientConfig
+		if os.Getenv("GITHUB_TOKEN") != "" {
+			existingConfig = &gogithub.NewGQLClientConfig{Token: os.Getenv("GITHUB_TOKEN")}
+		}
+		ghClient, err = gogithub.NewGQLClient(ctx, logger, existingConfig)
+		if err != nil {
+			logger.Panic("failed to create github client", zap.Error(err))
+		}
 	}
-	ghClient, err := gogithub.NewGQLClient(ctx, logger, existingConfig)
+	amqpClient, err := notification.NewAMQPNotification(cfg.AMQPURL, cfg.AMQPExchange, cfg.AMQPRoutingKeyPrefix)
 	if err != nil {
-		logger.Panic("failed to create github client", zap.Error(err))
+		logger.Panic("failed to set up amqp notification", zap.Error(err))
+	}
+	if amqpClient != nil {
+		logger.Info("setting up amqp notification")
+		amqpClient.RetryBudget = retryBudget
+		amqpClient.DeadLetter = deadLetter
+		deadLetterSenders = append(deadLetterSenders, amqpClient)
+		notif.Notifications = append(notif.Notifications, amqpClient)
+		defer func() {
+			if err := amqpClient.Close(); err != nil {
+				logger.Warn("failed to close amqp connection", zap.Error(err))
+			}
+		}()
+	}
+	pagerDuty, err := notification.NewPagerDuty(cfg.PagerDutyRoutingKey, cfg.PagerDutySeverity, httpClient)
+	if err != nil {
+		logger.Panic("failed to set up pagerduty notification", zap.Error(err))
+	}
+	if pagerDuty != nil {
+		logger.Info("setting up pagerduty notification")
+		if cfg.ManifestPath == "" {
+			pagerDuty.Repo = cfg.Repo
+		}
+		notif.Notifications = append(notif.Notifications, pagerDuty)
+	}
+	email, err := notification.NewEmail(cfg.SMTPAddr, cfg.EmailFrom, cfg.EmailTo)
+	if err != nil {
+		logger.Panic("failed to set up email notification", zap.Error(err))
+	}
+	if email != nil {
+		logger.Info("setting up email notification", zap.Bool("digest", cfg.EmailDigest))
+		email.Username = cfg.SMTPUsername
+		email.Password = cfg.SMTPPassword
+		email.ImplicitTLS = cfg.SMTPImplicitTLS
+		email.RequireTLS = cfg.SMTPRequireTLS
+		email.Digest = cfg.EmailDigest
+		if transport, ok := httpClient.Transport.(*http.Transport); ok {
+			email.TLSConfig = transport.TLSClientConfig
+		}
+		notif.Notifications = append(notif.Notifications, email)
+	}
+	var githubIssues *notification.GitHubIssues
+	if cfg.GithubIssues {
+		logger.Info("setting up github issues notification")
+		githubIssues = &notification.GitHubIssues{
+			GitHub:     ghClient,
+			HTTPClient: httpClient,
+			BaseURL:    cfg.GithubAPIURL,
+			Repo:       cfg.GithubIssuesRepo,
+			Label:      cfg.GithubIssuesLabel,
+		}
+		notif.Notifications = append(notif.Notifications, githubIssues)
+	}
+	if cfg.GithubCheckRun {
+		logger.Info("setting up github check run notification")
+		notif.Notifications = append(notif.Notifications, &notification.CheckRun{
+			GitHub:     ghClient,
+			HTTPClient: httpClient,
+			BaseURL:    cfg.GithubAPIURL,
+			Name:       cfg.GithubCheckRunName,
+		})
 	}
 	if workflowClient := notification.NewWorkflow(ghClient, cfg.WorkflowOwner, cfg.WorkflowRepo, cfg.WorkflowId, cfg.WorkflowRef); workflowClient != nil {
 		logger.Info("setting up workflow notification")
 		notif.Notifications = append(notif.Notifications, workflowClient)
 	}
+	var table *notification.Table
+	if cfg.TableOutput {
+		table = &notification.Table{Output: os.Stdout}
+		notif.Notifications = append(notif.Notifications, table)
+	}
+	if err := deadLetter.Replay(ctx, deadLetterSenders...); err != nil {
+		logger.Warn("failed to replay dead letter file", zap.Error(err))
+	}
+	if cfg.ConcurrentNotify {
+		notif.MaxConcurrentBackends = cfg.MaxNotifyBackends
+		if notif.MaxConcurrentBackends <= 0 {
+			notif.MaxConcurrentBackends = len(notif.Notifications)
+		}
+	}
 	tf := terraform.Client{
-		Logger: logger.With(zap.String("terraform", "true")),
+		Logger:                logger.With(zap.String("terraform", "true")),
+		MaxConcurrentInits:    cfg.MaxConcurrentInits,
+		InitRetries:           cfg.InitRetries,
+		InitBackoff:           cfg.InitBackoff,
+		RetryBudget:           retryBudget,
+		SkipInitIfInitialized: cfg.SkipInitIfInitialized,
+		IsolatedHome:          cfg.IsolatedTerraformHome,
+		RequiredVersion:       cfg.TerraformVersion,
+	}
+	// Only runs that check for drift call terraform, so --generate-only and --test-notifications skip this
+	verifyTerraformVersion := func() {
+		if err := tf.VerifyVersion(ctx); err != nil {
+			logger.Panic("terraform version check failed", zap.Error(err))
+		}
+	}
+	defer func() {
+		if err := tf.Cleanup(); err != nil {
+			logger.Warn("failed to clean up terraform home", zap.Error(err))
+		}
+	}()
+	if cfg.PreInitCommand != "" {
+		logger.Info("setting up terraform pre-init hook")
+		tf.PreInitHook = terraform.ShellHook(cfg.PreInitCommand)
 	}
 
 	var cache processedcache.ProcessedCache = processedcache.Noop{}
 	if cfg.DynamodbTable != "" {
 		logger.Info("setting up dynamodb result cache")
-		cache, err = processedcache.NewDynamoDB(ctx, cfg.DynamodbTable)
+		dynamoCache, err := processedcache.NewDynamoDB(ctx, cfg.DynamodbTable)
 		if err != nil {
 			logger.Panic("failed to create dynamodb result cache", zap.Error(err))
 		}
+		dynamoCache.ReportIndex = cfg.DynamodbReportIndex
+		cache = dynamoCache
+	}
+	if _, ok := cache.(processedcache.ReportCache); cfg.PersistRunReports && !ok {
+		logger.Panic("persisting run reports needs a result cache that can keep them, like DYNAMODB_TABLE")
+	}
+
+	var planStore planstore.Store
+	if cfg.PlanStoreURL != "" {
+		logger.Info("setting up plan store")
+		planStore = &planstore.HTTPPut{
+			BaseURL:    cfg.PlanStoreURL,
+			HTTPClient: httpClient,
+		}
 	}
 
-	d := drifter.Drifter{
-		DirectoryAllowlist:  cfg.DirectoryAllowlist,
-		Logger:              logger.With(zap.String("drifter", "true")),
-		Repo:                cfg.Repo,
-		AtlantisRepoYmlPath: cfg.AtlantisRepoConfigPath,
-		AtlantisClient: &atlantis.Client{
-			AtlantisHostname: cfg.AtlantisHostname,
-			Token:            cfg.AtlantisToken,
-			HTTPClient:       http.DefaultClient,
-		},
-		ParallelRuns:       cfg.ParallelRuns,
-		ResultCache:        cache,
-		Cloner:             cloner,
-		GithubClient:       ghClient,
-		CacheValidDuration: cfg.CacheValidDuration,
-		Terraform:          &tf,
-		Notification:       notif,
-		SkipWorkspaceCheck: cfg.SkipWorkspaceCheck,
-		AutoGenerateConfig: cfg.AutoGenerateConfig,
-	}
-	if err := d.Drift(ctx); err != nil {
-		logger.Panic("failed to drift", zap.Error(err))
+	var stateLister statelister.Lister
+	var s3StateLister *statelister.S3
+	if cfg.OrphanedStateS3Bucket != "" {
+		logger.Info("setting up s3 orphaned state check")
+		s3StateLister, err = statelister.NewS3(ctx, cfg.OrphanedStateS3Bucket, cfg.OrphanedStateS3Prefix)
+		if err != nil {
+			logger.Panic("failed to create s3 state lister", zap.Error(err))
+		}
+		stateLister = s3StateLister
+	} else if cfg.OrphanedStateCommand != "" {
+		logger.Info("setting up orphaned state check")
+		stateLister = &statelister.Command{Command: cfg.OrphanedStateCommand}
+	}
+	var stateKeyPattern *regexp.Regexp
+	if cfg.StateKeyPattern != "" {
+		stateKeyPattern, err = regexp.Compile(cfg.StateKeyPattern)
+		if err != nil {
+			logger.Panic("failed to parse state key pattern", zap.Error(err))
+		}
+	}
+	secretPatterns := make([]*regexp.Regexp, 0, len(cfg.SecretPatterns))
+	for _, p := range cfg.SecretPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			logger.Panic("failed to parse secret pattern", zap.Error(err))
+		}
+		secretPatterns = append(secretPatterns, re)
+	}
+
+	rootModuleRules := drifter.RootModuleRules{
+		Extended:            cfg.RootModuleHeuristics,
+		ExcludePathSegments: cfg.RootModuleExcludes,
+	}
+	if cfg.RootModuleHeuristics && len(cfg.RootModuleExcludes) == 0 {
+		rootModuleRules.ExcludePathSegments = drifter.DefaultRootModuleExcludes
+	}
+	newDrifter := func(r drifter.ManifestRepo) (*drifter.Drifter, error) {
+		repo := r.Repo
+		repoStateLister := stateLister
+		if s3StateLister != nil && r.StatePrefix != "" {
+			l := *s3StateLister
+			l.Prefix = r.StatePrefix
+			repoStateLister = &l
+		}
+		repoCache := cache
+		if r.CacheTable != "" {
+			dynamoCache, err := processedcache.NewDynamoDB(ctx, r.CacheTable)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create dynamodb result cache: %w", err)
+			}
+			dynamoCache.ReportIndex = cfg.DynamodbReportIndex
+			repoCache = dynamoCache
+		}
+		d := &drifter.Drifter{
+			DirectoryAllowlist:  cfg.DirectoryAllowlist,
+			AllowlistMatchMode:  allowlistMatchMode,
+			Logger:              logger.With(zap.String("drifter", "true")),
+			Repo:                repo,
+			AtlantisRepoYmlPath: cfg.AtlantisRepoConfigPath,
+			AtlantisClient: &atlantis.Client{
+				AtlantisHostname: cfg.AtlantisHostname,
+				Token:            cfg.AtlantisToken,
+				HTTPClient:       httpClient,
+				Headers:          atlantisHeaders,
+				MaxResponseBytes: cfg.AtlantisMaxResponse,
+			},
+			ParallelRuns:       parallelRuns,
+			ResultCache:        repoCache,
+			Cloner:             cloner,
+			GithubClient:       ghClient,
+			CacheValidDuration: cfg.CacheValidDuration,
+			Terraform:          &tf,
+			Notification:       notif,
+			SkipWorkspaceCheck: cfg.SkipWorkspaceCheck,
+			AutoGenerateConfig: cfg.AutoGenerateConfig,
+
+			CachedResultsWarningThreshold: cfg.CachedResultsWarning,
+			PrioritizeStale:               cfg.PrioritizeStale,
+			CheckUnmanagedDirectories:     cfg.CheckUnmanagedDirs,
+			MaintenanceWindows:            maintenanceWindows,
+			CheckGeneratedConfig:          cfg.CheckGeneratedConfig,
+			CompareRefs:                   cfg.CompareRefs,
+			Ref:                           cfg.PlanRef,
+			Branch:                        cfg.Branch,
+			PlanRepo:                      cfg.PlanRepo,
+			DriftGracePeriod:              cfg.DriftGracePeriod,
+			NotifyOnlyChangedDrift:        cfg.NotifyOnlyChangedDrift,
+			SkipCleanSummary:              !cfg.NotifyOnClean,
+			ReNotifyInterval:              cfg.ReNotifyInterval,
+			TemporaryErrorCacheDuration:   cfg.TemporaryErrorCache,
+			CommentArgs:                   cfg.PlanCommentArgs,
+			BatchPlanSummaries:            cfg.BatchPlanSummaries,
+			PlanByProjectName:             cfg.PlanByProjectName,
+			OverlayPath:                   cfg.OverlayPath,
+			CheckExtraWorkspacesForDrift:  cfg.PlanExtraWorkspaces,
+			PersistReport:                 cfg.PersistRunReports,
+			Phases:                        phases,
+			FailOnPostRunHookError:        cfg.FailOnPostRunError,
+			ReportDriftedOnly:             cfg.ReportDriftedOnly,
+			RunID:                         cfg.RunID,
+			ResumeFromCache:               cfg.ResumeFromCache,
+			FailOnRefMismatch:             cfg.FailOnRefMismatch,
+			FailOnNoProjects:              cfg.FailOnNoProjects,
+			DuplicateProjectPolicy:        duplicateProjectPolicy,
+			MaxCliffnoteLines:             cfg.MaxCliffnoteLines,
+			PlanStore:                     planStore,
+			LockedPlanBehavior:            lockedPlanBehavior,
+			RunLockTTL:                    cfg.RunLockTTL,
+			RunLockBehavior:               runLockBehavior,
+			ValidateConfigBeforePlan:      cfg.ValidateConfig,
+			OrderNotifications:            cfg.OrderNotifications,
+			SamplePercent:                 cfg.SamplePercent,
+			BootstrapMode:                 cfg.BootstrapMode,
+			IgnoreResourceTypes:           cfg.IgnoreResourceTypes,
+			OutputsOnlyDriftPolicy:        outputsOnlyDriftPolicy,
+			RoutedNotifications:           routedNotifications,
+			MaxNotificationsPerRun:        cfg.MaxNotifications,
+			RootModuleRules:               rootModuleRules,
+			WorkspaceAuditAllowlist:       cfg.WorkspaceAuditAllow,
+			WorkspaceAuditDenylist:        cfg.WorkspaceAuditDeny,
+			StateLister:                   repoStateLister,
+			StateKeyPattern:               stateKeyPattern,
+			StatePrefix:                   cfg.OrphanedStateS3Prefix,
+			WorkspaceKeyPrefix:            cfg.WorkspaceKeyPrefix,
+			SecretPatterns:                secretPatterns,
+			RedactTFVarValues:             cfg.RedactTFVarValues,
+		}
+		if cfg.CheckPendingApplies {
+			// Pull requests are opened against the repository atlantis tracks
+			pullRequestRepo := repo
+			if r.PlanRepo != "" {
+				pullRequestRepo = r.PlanRepo
+			} else if cfg.PlanRepo != "" {
+				pullRequestRepo = cfg.PlanRepo
+			}
+			// Only pull requests into the planned ref are pending applies, which like Drifter.Ref is the branch if the
+			// ref is not set. Empty is the default branch.
+			base, branch := cfg.PlanRef, cfg.Branch
+			if r.Ref != "" {
+				base = r.Ref
+			}
+			if r.Branch != "" {
+				branch = r.Branch
+			}
+			if base == "" {
+				base = branch
+			}
+			d.PendingApplies = &atlantisgithub.OpenPullRequests{
+				GitHub:     ghClient,
+				HTTPClient: httpClient,
+				BaseURL:    cfg.GithubAPIURL,
+				Repo:       pullRequestRepo,
+				Base:       base,
+			}
+		}
+		if table != nil || githubIssues != nil {
+			d.OnResult = func(ctx context.Context, result drifter.DriftResult) {
+				if result.Ref != "" || result.Locked || result.NeverPlanned || result.Err != nil {
+					return
+				}
+				if table != nil && !result.Drift {
+					table.Clean(result.Dir, result.Workspace)
+				}
+				if githubIssues == nil {
+					return
+				}
+				// Issues follow every result, including drift whose notification was held back
+				var err error
+				if result.Drift {
+					err = githubIssues.Drifted(ctx, result.Dir, result.Workspace, result.Cliffnote)
+				} else {
+					err = githubIssues.Clean(ctx, result.Dir, result.Workspace)
+				}
+				if err != nil {
+					logger.Warn("failed to update drift issue", zap.String("dir", result.Dir), zap.String("workspace", result.Workspace), zap.Error(err))
+				}
+			}
+		}
+		if cfg.PostRunCommand != "" {
+			d.PostRunHook = drifter.CommandHook(cfg.PostRunCommand)
+		}
+		if cfg.PrintGeneratedConfig {
+			d.GeneratedConfigOutput = os.Stdout
+		}
+		return d, nil
+	}
+	if *testNotifications {
+		// Routed backends cover the notify targets of a manifest, so one drifter tests every backend
+		d, err := newDrifter(drifter.ManifestRepo{Repo: cfg.Repo})
+		if err != nil {
+			logger.Panic("failed to set up drifter", zap.Error(err))
+		}
+		if err := d.TestNotifications(ctx); err != nil {
+			logger.Panic("notification test failed", zap.Error(err))
+		}
+		return
+	}
+	if cfg.ManifestPath != "" {
+		manifest, err := drifter.LoadManifest(cfg.ManifestPath)
+		if err != nil {
+			logger.Panic("failed to load manifest", zap.Error(err))
+		}
+		if *generateOnly || cfg.MetricsFile != "" || cfg.JUnitReportPath != "" {
+			logger.Panic("a manifest cannot be used with --generate-only, METRICS_FILE or JUNIT_REPORT_PATH, which describe a single repository")
+		}
+		if len(manifest.Repos) > 1 {
+			for _, r := range manifest.Repos {
+				if cfg.PlanRepo != "" && r.PlanRepo == "" {
+					logger.Panic("every repo of a manifest needs its own plan_repo when PLAN_REPO is set", zap.String("repo", r.Repo))
+				}
+				if stateLister != nil && r.StatePrefix == "" {
+					logger.Panic("every repo of a manifest needs its own state_prefix when checking for orphaned state", zap.String("repo", r.Repo))
+				}
+			}
+		}
+		verifyTerraformVersion()
+		driftErr := drifter.RunManifest(ctx, manifest, newDrifter)
+		if denied := retryBudget.Denied(); denied > 0 {
+			logger.Warn("retry budget exhausted, some failures were not retried", zap.Int("budget", cfg.RetryBudget), zap.Int("denied-retries", denied))
+		}
+		if driftErr != nil {
+			logger.Panic("failed to drift", zap.Error(driftErr))
+		}
+		return
+	}
+	if cfg.Repo == "" {
+		logger.Panic("REPO or MANIFEST_PATH must be set")
+	}
+	d, err := newDrifter(drifter.ManifestRepo{Repo: cfg.Repo})
+	if err != nil {
+		logger.Panic("failed to set up drifter", zap.Error(err))
+	}
+	if *generateOnly {
+		if err := d.GenerateConfig(ctx, os.Stdout); err != nil {
+			logger.Panic("failed to generate atlantis config", zap.Error(err))
+		}
+		return
+	}
+	verifyTerraformVersion()
+	driftErr := d.Drift(ctx)
+	if denied := retryBudget.Denied(); denied > 0 {
+		logger.Warn("retry budget exhausted, some failures were not retried", zap.Int("budget", cfg.RetryBudget), zap.Int("denied-retries", denied))
+	}
+	if cfg.MetricsFile != "" {
+		if err := d.WriteOpenMetricsFile(cfg.MetricsFile); err != nil {
+			logger.Error("failed to write metrics file", zap.Error(err))
+		}
+	}
+	if cfg.JUnitReportPath != "" {
+		if err := d.WriteJUnitFile(cfg.JUnitReportPath); err != nil {
+			logger.Error("failed to write junit report", zap.Error(err))
+		}
+	}
+	if driftErr != nil {
+		logger.Panic("failed to drift", zap.Error(driftErr))
 	}
 }
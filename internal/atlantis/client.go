@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,12 +13,33 @@ import (
 
 	"github.com/runatlantis/atlantis/server/controllers"
 	"github.com/runatlantis/atlantis/server/events/command"
+	"github.com/runatlantis/atlantis/server/events/models"
 )
 
 type Client struct {
 	AtlantisHostname string
 	Token            string
 	HTTPClient       *http.Client
+	// Headers are set on every request, for proxies in front of Atlantis that need their own credentials. They cannot
+	// replace the Atlantis token header.
+	Headers map[string]string
+	// MaxResponseBytes caps how much of a plan response is read, so a pathologically large plan cannot exhaust memory.
+	// A plan cut short is judged from what was read and marked Truncated. Zero reads everything.
+	MaxResponseBytes int64
+}
+
+// ParseHeaders parses name=value entries for Client.Headers. Errors name a malformed entry by its position only, since
+// it may hold a credential.
+func ParseHeaders(entries []string) (map[string]string, error) {
+	ret := make(map[string]string, len(entries))
+	for i, e := range entries {
+		name, value, ok := strings.Cut(e, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("atlantis header %d is not name=value", i+1)
+		}
+		ret[name] = value
+	}
+	return ret, nil
 }
 
 type PlanSummaryRequest struct {
@@ -26,6 +48,12 @@ type PlanSummaryRequest struct {
 	Type      string
 	Dir       string
 	Workspace string
+	// ProjectName, if set, is sent instead of Dir and Workspace so Atlantis resolves the project itself, for configs
+	// where projects are keyed by name
+	ProjectName string
+	// CommentArgs are the flags a plan comment would carry, like "-p project". Only the project, dir and workspace
+	// flags can be expressed through the Atlantis API.
+	CommentArgs []string
 }
 
 type PlanResult struct {
@@ -35,11 +63,18 @@ type PlanResult struct {
 type PlanSummary struct {
 	HasLock bool
 	Summary string
+	// Error is set if the plan output contains terraform errors, in which case Summary can't be trusted
+	Error string
+	// Output is the full terraform plan output
+	Output string
+	// Truncated is set if the response was larger than MaxResponseBytes, in which case Summary and Output come from
+	// the part that was read
+	Truncated bool
 }
 
 func (p *PlanResult) HasChanges() bool {
 	for _, summary := range p.Summaries {
-		if summary.HasLock {
+		if summary.HasLock || summary.Error != "" {
 			continue
 		}
 		if !strings.Contains(summary.Summary, "No changes. ") {
@@ -49,34 +84,100 @@ func (p *PlanResult) HasChanges() bool {
 	return false
 }
 
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b[@-Z\\-_]`)
+
+// NormalizePlanText strips ANSI escape sequences, like terraform colors, and normalizes line endings to \n
+func NormalizePlanText(s string) string {
+	s = ansiEscapeRe.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
 func (p *PlanResult) GetPlanResultSummary() string {
 	cliffnoteRe := regexp.MustCompile(`Plan:.*`)
 	extChangesRe := regexp.MustCompile(`Note: Objects have changed outside.*`)
 	outputChangesRe := regexp.MustCompile(`.*Changes to Outputs.*`)
 	var summaryBuilder strings.Builder
 	for _, summary := range p.Summaries {
+		text := NormalizePlanText(summary.Summary)
 		// Check to see if any changes were potentially made outside of TF
-		if extChangesRe.MatchString(summary.Summary) {
+		if extChangesRe.MatchString(text) {
 			summaryBuilder.WriteString("Note: Objects have changed outside of Terraform.\n")
 		}
-		if outputChangesRe.MatchString(summary.Summary) {
+		if outputChangesRe.MatchString(text) {
 			summaryBuilder.WriteString("Note: Contains output changes.\n")
 		}
 
 		// Check to see if we can capture the Plan minutia like:
 		// Plan: 1 to add, 0 to change, 0 to destroy.
-		res := cliffnoteRe.FindAllStringSubmatch(summary.Summary, 1)
+		res := cliffnoteRe.FindAllStringSubmatch(text, 1)
 		for r := range res {
 			summaryBuilder.WriteString(res[r][0] + "\n")
 		}
 	}
 
+	for _, summary := range p.Summaries {
+		if summary.Truncated {
+			summaryBuilder.WriteString("Note: Plan output was truncated.\n")
+			break
+		}
+	}
+
 	if len(summaryBuilder.String()) == 0 {
 		return "Note: Drift detected but no notes parsed."
 	}
 	return strings.TrimSuffix(summaryBuilder.String(), "\n")
 }
 
+// GetPlanOutput returns the full terraform output of every plan
+func (p *PlanResult) GetPlanOutput() string {
+	outputs := make([]string, 0, len(p.Summaries))
+	for _, summary := range p.Summaries {
+		if summary.Output != "" {
+			outputs = append(outputs, summary.Output)
+		}
+	}
+	return strings.Join(outputs, "\n")
+}
+
+// HasErrors returns true if any plan output contained terraform errors
+func (p *PlanResult) HasErrors() bool {
+	for _, summary := range p.Summaries {
+		if summary.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPlanErrors returns the terraform errors found in every plan output
+func (p *PlanResult) GetPlanErrors() string {
+	var errs []string
+	for _, summary := range p.Summaries {
+		if summary.Error != "" {
+			errs = append(errs, summary.Error)
+		}
+	}
+	return strings.Join(errs, "\n")
+}
+
+var planErrorRe = regexp.MustCompile(`(?m)^[│\s]*Error: .*$`)
+
+// findPlanErrors returns the terraform error lines in a plan output, or an empty string if there are none
+func findPlanErrors(terraformOutput string) string {
+	matches := planErrorRe.FindAllString(NormalizePlanText(terraformOutput), -1)
+	for i := range matches {
+		matches[i] = strings.TrimSpace(strings.TrimLeft(matches[i], "│ \t"))
+	}
+	return strings.Join(matches, "\n")
+}
+
+// IsNeverPlanned returns true if atlantis returned no project results at all, which happens for projects that are
+// configured but have never been planned
+func (p *PlanResult) IsNeverPlanned() bool {
+	return len(p.Summaries) == 0
+}
+
 func (p *PlanResult) IsLocked() bool {
 	for _, summary := range p.Summaries {
 		if !summary.HasLock {
@@ -103,6 +204,37 @@ func (p *possiblyTemporaryError) Temporary() bool {
 	return true
 }
 
+// applyCommentArgs applies plan comment flags to an API request. A project flag replaces the dir/workspace path, since
+// Atlantis resolves a named project itself.
+func applyCommentArgs(body *controllers.APIRequest, args []string) error {
+	for i := 0; i < len(args); i++ {
+		flag, value, hasValue := strings.Cut(args[i], "=")
+		if !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("flag %s has no value", flag)
+			}
+			i++
+			value = args[i]
+		}
+		switch flag {
+		case "-p", "--project":
+			body.Projects = append(body.Projects, value)
+			body.Paths = nil
+		case "-d", "--dir":
+			for j := range body.Paths {
+				body.Paths[j].Directory = value
+			}
+		case "-w", "--workspace":
+			for j := range body.Paths {
+				body.Paths[j].Workspace = value
+			}
+		default:
+			return fmt.Errorf("flag %s is not supported by the Atlantis plan API", flag)
+		}
+	}
+	return nil
+}
+
 func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*PlanResult, error) {
 	planBody := controllers.APIRequest{
 		Repository: req.Repo,
@@ -118,6 +250,120 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 			},
 		},
 	}
+	if req.ProjectName != "" {
+		planBody.Projects = []string{req.ProjectName}
+		planBody.Paths = nil
+	}
+	if err := applyCommentArgs(&planBody, req.CommentArgs); err != nil {
+		return nil, fmt.Errorf("invalid comment args %q: %w", req.CommentArgs, err)
+	}
+	bodyResult, err := c.plan(ctx, planBody)
+	var truncated *truncatedResponseError
+	if errors.As(err, &truncated) {
+		return &PlanResult{Summaries: []PlanSummary{truncatedPlanSummary(truncated.body)}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret PlanResult
+	for _, result := range bodyResult.ProjectResults {
+		summary, err := toPlanSummary(result)
+		if err != nil {
+			return nil, err
+		}
+		ret.Summaries = append(ret.Summaries, summary)
+	}
+	return &ret, nil
+}
+
+// BatchPlanSummaryRequest plans several workspaces of one directory in a single request
+type BatchPlanSummaryRequest struct {
+	Repo       string
+	Ref        string
+	Type       string
+	Dir        string
+	Workspaces []string
+}
+
+// BatchPlanSummary plans every workspace of req in one Atlantis request and returns the results keyed by workspace.
+// Workspaces without project results were never planned. It fails if Atlantis returns a result for a workspace that
+// was not requested, as older Atlantis versions do not say which workspace a result is for.
+func (c *Client) BatchPlanSummary(ctx context.Context, req *BatchPlanSummaryRequest) (map[string]*PlanResult, error) {
+	planBody := controllers.APIRequest{
+		Repository: req.Repo,
+		Ref:        req.Ref,
+		Type:       req.Type,
+	}
+	ret := make(map[string]*PlanResult, len(req.Workspaces))
+	for _, workspace := range req.Workspaces {
+		planBody.Paths = append(planBody.Paths, struct {
+			Directory string
+			Workspace string
+		}{
+			Directory: req.Dir,
+			Workspace: workspace,
+		})
+		ret[workspace] = &PlanResult{}
+	}
+	bodyResult, err := c.plan(ctx, planBody)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range bodyResult.ProjectResults {
+		pr, exists := ret[result.Workspace]
+		if !exists {
+			return nil, fmt.Errorf("plan result for unrequested workspace %q in %s", result.Workspace, req.Dir)
+		}
+		summary, err := toPlanSummary(result)
+		if err != nil {
+			return nil, err
+		}
+		pr.Summaries = append(pr.Summaries, summary)
+	}
+	return ret, nil
+}
+
+// truncatedResponseError is returned by plan for a successful response larger than MaxResponseBytes, with the part
+// that was read
+type truncatedResponseError struct {
+	destination string
+	limit       int64
+	body        string
+}
+
+func (e *truncatedResponseError) Error() string {
+	return fmt.Sprintf("plan response from %s is larger than %d bytes", e.destination, e.limit)
+}
+
+// jsonEscapeReplacer undoes the JSON escapes that matter to plan output parsing, for responses too large to decode
+var jsonEscapeReplacer = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\u001b`, "\x1b", `\\`, `\`)
+
+// truncatedPlanSummary judges a plan from the start of a response too large to decode. Terraform prints the summary
+// line last, so when it was cut off the resource change lines decide if the plan has changes.
+func truncatedPlanSummary(body string) PlanSummary {
+	if _, output, found := strings.Cut(body, `"TerraformOutput":"`); found {
+		body = output
+	}
+	text := jsonEscapeReplacer.Replace(body)
+	ret := PlanSummary{Truncated: true, Output: text}
+	if strings.Contains(text, "This project is currently locked ") {
+		ret.HasLock = true
+		return ret
+	}
+	ret.Error = findPlanErrors(text)
+	ret.Summary = (&models.PlanSuccess{TerraformOutput: text}).Summary()
+	if ret.Summary == "" {
+		if len(ParseResourceChanges(text)) > 0 {
+			ret.Summary = "Plan output was truncated before its summary"
+		} else if ret.Error == "" {
+			ret.Error = "plan output was truncated before any changes or summary"
+		}
+	}
+	return ret
+}
+
+// plan sends a plan request to the Atlantis API
+func (c *Client) plan(ctx context.Context, planBody controllers.APIRequest) (*command.Result, error) {
 	planBodyJSON, err := json.Marshal(planBody)
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling plan body: %w", err)
@@ -127,6 +373,9 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	if err != nil {
 		return nil, fmt.Errorf("error parsing destination: %w", err)
 	}
+	for name, value := range c.Headers {
+		httpReq.Header.Set(name, value)
+	}
 	httpReq.Header.Set("X-Atlantis-Token", c.Token)
 	httpReq = httpReq.WithContext(ctx)
 
@@ -134,13 +383,24 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	if err != nil {
 		return nil, fmt.Errorf("error making plan request to %s: %w", destination, err)
 	}
+	var body io.Reader = resp.Body
+	if c.MaxResponseBytes > 0 {
+		body = io.LimitReader(resp.Body, c.MaxResponseBytes+1)
+	}
 	var fullBody bytes.Buffer
-	if _, err := io.Copy(&fullBody, resp.Body); err != nil {
+	if _, err := io.Copy(&fullBody, body); err != nil {
 		return nil, fmt.Errorf("unable to read response body: %w", err)
 	}
 	if err := resp.Body.Close(); err != nil {
 		return nil, fmt.Errorf("unable to close response body: %w", err)
 	}
+	if c.MaxResponseBytes > 0 && int64(fullBody.Len()) > c.MaxResponseBytes {
+		fullBody.Truncate(int(c.MaxResponseBytes))
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("non-200 response for %s larger than %d bytes: %d", destination, c.MaxResponseBytes, resp.StatusCode)
+		}
+		return nil, &truncatedResponseError{destination: destination, limit: c.MaxResponseBytes, body: fullBody.String()}
+	}
 	if resp.StatusCode == http.StatusUnauthorized {
 		var errResp errorResponse
 		if err := json.NewDecoder(&fullBody).Decode(&errResp); err != nil {
@@ -169,22 +429,22 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	if bodyResult.Failure != "" {
 		return nil, fmt.Errorf("failure making plan request: %s", bodyResult.Failure)
 	}
-	var ret PlanResult
-	for _, result := range bodyResult.ProjectResults {
-		if result.Failure != "" {
-			if strings.Contains(result.Failure, "This project is currently locked ") {
-				ret.Summaries = append(ret.Summaries, PlanSummary{HasLock: true})
-				continue
-			}
-		}
-		if result.PlanSuccess != nil {
-			summary := result.PlanSuccess.Summary()
-			ret.Summaries = append(ret.Summaries, PlanSummary{Summary: summary})
+	return &bodyResult, nil
+}
 
-			continue
+// toPlanSummary converts the result of planning one project
+func toPlanSummary(result command.ProjectResult) (PlanSummary, error) {
+	if result.Failure != "" {
+		if strings.Contains(result.Failure, "This project is currently locked ") {
+			return PlanSummary{HasLock: true}, nil
 		}
-		return nil, fmt.Errorf("project result unknown failure: %s", result.Failure)
-
 	}
-	return &ret, nil
+	if result.PlanSuccess != nil {
+		return PlanSummary{
+			Summary: result.PlanSuccess.Summary(),
+			Error:   findPlanErrors(result.PlanSuccess.TerraformOutput),
+			Output:  result.PlanSuccess.TerraformOutput,
+		}, nil
+	}
+	return PlanSummary{}, fmt.Errorf("project result unknown failure: %s", result.Failure)
 }
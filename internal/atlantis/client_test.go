@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/testhelper"
+	"github.com/runatlantis/atlantis/server/controllers"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +66,169 @@ func TestClient_PlanSummaryChanges(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok.HasChanges())
 }
+
+func TestClient_PlanSummaryHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "client-id", r.Header.Get("CF-Access-Client-Id"))
+		require.Equal(t, "drift@example.com", r.Header.Get("X-Auth-Request-Email"))
+		require.Equal(t, "token", r.Header.Get("X-Atlantis-Token"))
+		_, _ = w.Write([]byte(`{"ProjectResults":[]}`))
+	}))
+	defer srv.Close()
+	c := Client{
+		AtlantisHostname: srv.URL,
+		Token:            "token",
+		HTTPClient:       srv.Client(),
+		Headers: map[string]string{
+			"CF-Access-Client-Id":  "client-id",
+			"X-Auth-Request-Email": "drift@example.com",
+			"X-Atlantis-Token":     "overridden",
+		},
+	}
+	_, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders([]string{"CF-Access-Client-Id=abc=def", "X-Empty="})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"CF-Access-Client-Id": "abc=def", "X-Empty": ""}, headers)
+
+	_, err = ParseHeaders([]string{"X-Ok=1", "Authorization: Bearer xyz"})
+	require.EqualError(t, err, "atlantis header 2 is not name=value")
+	_, err = ParseHeaders([]string{"=value"})
+	require.Error(t, err)
+}
+
+func TestClient_PlanSummaryNeverPlanned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults":[]}`))
+	}))
+	defer srv.Close()
+	c := Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()}
+	pr, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "never", Workspace: "default"})
+	require.NoError(t, err)
+	require.True(t, pr.IsNeverPlanned())
+	require.False(t, pr.HasChanges())
+	pr = &PlanResult{Summaries: []PlanSummary{{Summary: "No changes. "}}}
+	require.False(t, pr.IsNeverPlanned())
+}
+
+func TestPlanResult_Errors(t *testing.T) {
+	output := `Planning failed. Terraform encountered an error while generating this plan.
+
+╷
+│ Error: No valid credential sources found
+│
+│   with provider["registry.terraform.io/hashicorp/aws"],
+╵
+`
+	require.Equal(t, "Error: No valid credential sources found", findPlanErrors(output))
+	require.Equal(t, "", findPlanErrors("No changes. Your infrastructure matches the configuration."))
+	p := PlanResult{Summaries: []PlanSummary{
+		{Summary: "", Error: findPlanErrors(output)},
+	}}
+	require.True(t, p.HasErrors())
+	require.False(t, p.HasChanges())
+	require.Equal(t, "Error: No valid credential sources found", p.GetPlanErrors())
+}
+
+func TestApplyCommentArgs(t *testing.T) {
+	newBody := func() *controllers.APIRequest {
+		return &controllers.APIRequest{
+			Paths: []struct {
+				Directory string
+				Workspace string
+			}{{Directory: "dir", Workspace: "default"}},
+		}
+	}
+	body := newBody()
+	require.NoError(t, applyCommentArgs(body, []string{"-p", "myproject"}))
+	require.Equal(t, []string{"myproject"}, body.Projects)
+	require.Empty(t, body.Paths)
+
+	body = newBody()
+	require.NoError(t, applyCommentArgs(body, []string{"--workspace=prod", "-d", "other"}))
+	require.Equal(t, "other", body.Paths[0].Directory)
+	require.Equal(t, "prod", body.Paths[0].Workspace)
+
+	require.Error(t, applyCommentArgs(newBody(), []string{"-p"}))
+	require.Error(t, applyCommentArgs(newBody(), []string{"--", "-target=foo"}))
+}
+
+func TestNormalizePlanText(t *testing.T) {
+	require.Equal(t, "Plan: 1 to add, 0 to change, 0 to destroy.\nNo changes.", NormalizePlanText("\x1b[1mPlan:\x1b[0m 1 to add, 0 to change, 0 to destroy.\r\n\x1b[32;1mNo changes.\x1b[0m"))
+}
+
+func TestPlanResult_GetPlanResultSummaryStripsColor(t *testing.T) {
+	p := PlanResult{Summaries: []PlanSummary{{
+		Summary: "\x1b[1m\x1b[33mNote:\x1b[0m\x1b[1m Objects have changed outside of Terraform\x1b[0m\r\n\x1b[1mPlan:\x1b[0m 0 to add, 1 to change, 0 to destroy.\r\n",
+	}}}
+	require.Equal(t, "Note: Objects have changed outside of Terraform.\nPlan: 0 to add, 1 to change, 0 to destroy.", p.GetPlanResultSummary())
+}
+
+func TestClient_BatchPlanSummary(t *testing.T) {
+	workspace := "prod"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req controllers.APIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Len(t, req.Paths, 2)
+		_, _ = w.Write([]byte(`{"ProjectResults":[{"Workspace":"` + workspace + `","PlanSuccess":{"TerraformOutput":"Plan: 1 to add, 0 to change, 0 to destroy."}}]}`))
+	}))
+	defer srv.Close()
+	c := Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()}
+	req := &BatchPlanSummaryRequest{Dir: "dir", Workspaces: []string{"dev", "prod"}}
+	results, err := c.BatchPlanSummary(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, results["prod"].HasChanges())
+	require.True(t, results["dev"].IsNeverPlanned())
+
+	// Older atlantis versions do not say which workspace a result is for
+	workspace = ""
+	_, err = c.BatchPlanSummary(context.Background(), req)
+	require.Error(t, err)
+}
+
+func TestClient_PlanSummaryProjectName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req controllers.APIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, []string{"network"}, req.Projects)
+		require.Empty(t, req.Paths)
+		_, _ = w.Write([]byte(`{"ProjectResults":[]}`))
+	}))
+	defer srv.Close()
+	c := Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()}
+	_, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "network", Workspace: "default", ProjectName: "network"})
+	require.NoError(t, err)
+}
+
+func TestClient_PlanSummaryMaxResponseBytes(t *testing.T) {
+	output := "  # aws_s3_bucket.logs will be updated in-place\n" + strings.Repeat("      ~ tags = {}\n", 1000) + "Plan: 0 to add, 1 to change, 0 to destroy."
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ProjectResults": []interface{}{
+				map[string]interface{}{"PlanSuccess": map[string]interface{}{"TerraformOutput": output}},
+			},
+		})
+	}))
+	defer srv.Close()
+	c := Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client(), MaxResponseBytes: 1024}
+	pr, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+	require.True(t, pr.Summaries[0].Truncated)
+	require.True(t, pr.HasChanges())
+	require.False(t, pr.HasErrors())
+	require.Equal(t, []string{"aws_s3_bucket.logs"}, pr.ChangedResources(nil))
+	require.Contains(t, pr.GetPlanResultSummary(), "Note: Plan output was truncated.")
+
+	output = strings.Repeat("Reading...\n", 1000)
+	pr, err = c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+	require.True(t, pr.HasErrors())
+
+	c.MaxResponseBytes = 0
+	pr, err = c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+	require.False(t, pr.Summaries[0].Truncated)
+}
@@ -3,7 +3,10 @@ package atlantis
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
@@ -22,13 +25,24 @@ func (d DirectoriesWithWorkspaces) SortedKeys() []string {
 	return keys
 }
 
+// NormalizeDir returns the key used for a project directory, so every spelling of the same directory, like "./a/" and
+// "a", maps to one key. Backslashes are treated as separators and the repository root is ".".
+func NormalizeDir(dir string) string {
+	return path.Clean(strings.ReplaceAll(dir, `\`, "/"))
+}
+
+// ConfigToWorkspaces returns the workspaces of every project directory. Directories are keyed by NormalizeDir and a
+// workspace declared twice for the same directory is listed once.
 func ConfigToWorkspaces(cfg *SimpleAtlantisConfig) DirectoriesWithWorkspaces {
 	workspaces := make(DirectoriesWithWorkspaces)
 	for _, p := range cfg.Projects {
-		if _, exists := workspaces[p.Dir]; !exists {
-			workspaces[p.Dir] = []string{}
+		dir := NormalizeDir(p.Dir)
+		if _, exists := workspaces[dir]; !exists {
+			workspaces[dir] = []string{}
+		}
+		if !slices.Contains(workspaces[dir], p.Workspace) {
+			workspaces[dir] = append(workspaces[dir], p.Workspace)
 		}
-		workspaces[p.Dir] = append(workspaces[p.Dir], p.Workspace)
 	}
 	return workspaces
 }
@@ -36,6 +50,44 @@ func ConfigToWorkspaces(cfg *SimpleAtlantisConfig) DirectoriesWithWorkspaces {
 type SimpleAtlantisConfig struct {
 	Version  int
 	Projects []valid.Project
+	// NotifyTargets maps a normalized project directory to the notification target its drift-notify annotation names
+	NotifyTargets map[string]string `yaml:"-"`
+	// DuplicateProjects maps each project name used by more than one project to the directories of those projects
+	DuplicateProjects map[string][]string `yaml:"-"`
+}
+
+// ForBranch returns the config with only the projects that track branch. Projects without a branch matcher track
+// every branch.
+func (c *SimpleAtlantisConfig) ForBranch(branch string) *SimpleAtlantisConfig {
+	ret := &SimpleAtlantisConfig{Version: c.Version, NotifyTargets: c.NotifyTargets, DuplicateProjects: c.DuplicateProjects}
+	for _, p := range c.Projects {
+		if p.BranchRegex == nil || p.BranchRegex.MatchString(branch) {
+			ret.Projects = append(ret.Projects, p)
+		}
+	}
+	return ret
+}
+
+// ProjectName returns the name of the project for dir and workspace, or an empty string if it has no name or its name
+// is shared with another project, as Atlantis could not tell which one is meant
+func (c *SimpleAtlantisConfig) ProjectName(dir string, workspace string) string {
+	dir = NormalizeDir(dir)
+	for _, p := range c.Projects {
+		if p.Name == nil || *p.Name == "" || NormalizeDir(p.Dir) != dir || p.Workspace != workspace {
+			continue
+		}
+		if _, duplicate := c.DuplicateProjects[*p.Name]; duplicate {
+			return ""
+		}
+		return *p.Name
+	}
+	return ""
+}
+
+type rawProjectBranches struct {
+	Projects []struct {
+		Branch string `yaml:"branch"`
+	} `yaml:"projects"`
 }
 
 func ParseRepoConfig(body string) (*SimpleAtlantisConfig, error) {
@@ -43,9 +95,85 @@ func ParseRepoConfig(body string) (*SimpleAtlantisConfig, error) {
 	if err := yaml.NewDecoder(strings.NewReader(body)).Decode(&ret); err != nil {
 		return nil, fmt.Errorf("error parsing config: %s", err)
 	}
+	// valid.Project has no field for the raw branch key, so branch matchers are read separately
+	var branches rawProjectBranches
+	if err := yaml.NewDecoder(strings.NewReader(body)).Decode(&branches); err != nil {
+		return nil, fmt.Errorf("error parsing config: %s", err)
+	}
+	for i, p := range branches.Projects {
+		if p.Branch == "" || i >= len(ret.Projects) {
+			continue
+		}
+		// Atlantis writes branch matchers as /regex/
+		re, err := regexp.Compile(strings.TrimSuffix(strings.TrimPrefix(p.Branch, "/"), "/"))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing branch matcher of project %d: %w", i, err)
+		}
+		ret.Projects[i].BranchRegex = re
+	}
+	targets, err := parseNotifyTargets(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config: %s", err)
+	}
+	ret.NotifyTargets = targets
+	ret.DuplicateProjects = findDuplicateProjects(ret.Projects)
 	return &ret, nil
 }
 
+// findDuplicateProjects returns the directories of every project whose name another project also uses
+func findDuplicateProjects(projects []valid.Project) map[string][]string {
+	dirs := make(map[string][]string)
+	for _, p := range projects {
+		if p.Name != nil && *p.Name != "" {
+			dirs[*p.Name] = append(dirs[*p.Name], p.Dir)
+		}
+	}
+	ret := make(map[string][]string)
+	for name, d := range dirs {
+		if len(d) > 1 {
+			ret[name] = d
+		}
+	}
+	return ret
+}
+
+var notifyAnnotationRe = regexp.MustCompile(`drift-notify:\s*(\S+)`)
+
+// parseNotifyTargets reads the "# drift-notify: <target>" comments of each project. Atlantis rejects unknown keys in
+// its config, so the annotation has to be a comment on the project, either above it or on one of its lines.
+func parseNotifyTargets(body string) (map[string]string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return targets, nil
+	}
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "projects" || doc.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, project := range doc.Content[i+1].Content {
+			comments := []string{project.HeadComment, project.LineComment}
+			dir := ""
+			for j, n := range project.Content {
+				comments = append(comments, n.HeadComment, n.LineComment)
+				if j%2 == 0 && n.Value == "dir" && j+1 < len(project.Content) {
+					dir = project.Content[j+1].Value
+				}
+			}
+			for _, c := range comments {
+				if m := notifyAnnotationRe.FindStringSubmatch(c); m != nil && dir != "" {
+					targets[NormalizeDir(dir)] = m[1]
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
 func ParseRepoConfigFromDir(atlantisYmlSubpath string, dir string) (*SimpleAtlantisConfig, error) {
 	filename := filepath.Join(dir, atlantisYmlSubpath)
 	body, err := os.ReadFile(filename)
@@ -69,3 +69,113 @@ func TestParseRepoConfigFromDir(t *testing.T) {
 	require.Equal(t, 3, len(cfg.Projects))
 	require.Equal(t, "environments/aws/example", cfg.Projects[0].Dir)
 }
+
+func TestParseRepoConfig_NotifyTargets(t *testing.T) {
+	cfg, err := ParseRepoConfig(`version: 3
+projects:
+# drift-notify: team-a
+- dir: a
+- dir: b # drift-notify: team-b
+  workspace: prod
+- dir: c
+`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "team-a", "b": "team-b"}, cfg.NotifyTargets)
+	require.Equal(t, cfg.NotifyTargets, cfg.ForBranch("main").NotifyTargets)
+}
+
+func TestParseRepoConfig_BranchMatchers(t *testing.T) {
+	cfg, err := ParseRepoConfig(`version: 3
+projects:
+- dir: everywhere
+- dir: release
+  branch: /release\/.*/
+`)
+	require.NoError(t, err)
+	require.Nil(t, cfg.Projects[0].BranchRegex)
+	require.NotNil(t, cfg.Projects[1].BranchRegex)
+
+	mainCfg := cfg.ForBranch("main")
+	require.Len(t, mainCfg.Projects, 1)
+	require.Equal(t, "everywhere", mainCfg.Projects[0].Dir)
+	require.Len(t, cfg.ForBranch("release/v2").Projects, 2)
+}
+
+func TestNormalizeDir(t *testing.T) {
+	for dir, expected := range map[string]string{
+		"a/b":      "a/b",
+		"./a/b":    "a/b",
+		"a/b/":     "a/b",
+		"./a/b/":   "a/b",
+		"a//b":     "a/b",
+		`a\b`:      "a/b",
+		`.\a\b\`:   "a/b",
+		"a/./b/..": "a",
+		".":        ".",
+		"./":       ".",
+		"":         ".",
+	} {
+		require.Equal(t, expected, NormalizeDir(dir), dir)
+	}
+}
+
+func TestConfigToWorkspaces_NormalizesDirs(t *testing.T) {
+	cfg, err := ParseRepoConfig(`version: 3
+projects:
+- dir: ./a/ # drift-notify: team-a
+  workspace: default
+- dir: a
+  workspace: default
+- dir: a//
+  workspace: prod
+- dir: .
+  workspace: default
+- dir: ./
+  workspace: default
+`)
+	require.NoError(t, err)
+	require.Equal(t, DirectoriesWithWorkspaces{
+		"a": {"default", "prod"},
+		".": {"default"},
+	}, ConfigToWorkspaces(cfg))
+	require.Equal(t, map[string]string{"a": "team-a"}, cfg.NotifyTargets)
+}
+
+func TestParseRepoConfig_DuplicateProjects(t *testing.T) {
+	cfg, err := ParseRepoConfig(`version: 3
+projects:
+- name: network
+  dir: network
+- name: network
+  dir: network-copy
+  workspace: prod
+- name: database
+  dir: database
+- dir: unnamed
+- dir: unnamed-too
+`)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"network": {"network", "network-copy"}}, cfg.DuplicateProjects)
+}
+
+func TestSimpleAtlantisConfig_ProjectName(t *testing.T) {
+	cfg, err := ParseRepoConfig(`version: 3
+projects:
+- name: network-prod
+  dir: network
+  workspace: prod
+- name: shared
+  dir: a
+  workspace: default
+- name: shared
+  dir: b
+  workspace: default
+- dir: unnamed
+  workspace: default
+`)
+	require.NoError(t, err)
+	require.Equal(t, "network-prod", cfg.ProjectName("./network", "prod"))
+	require.Equal(t, "", cfg.ProjectName("network", "default"))
+	require.Equal(t, "", cfg.ProjectName("a", "default"))
+	require.Equal(t, "", cfg.ProjectName("unnamed", "default"))
+}
@@ -0,0 +1,150 @@
+package atlantis
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResourceChange is a single resource terraform plans to change, as listed in the plan output
+type ResourceChange struct {
+	// Address is the full resource address, like module.vpc.aws_subnet.private["a"]
+	Address string
+	// Type is the resource type, like aws_subnet
+	Type string
+	// Action is what the header says terraform will do, like "will be updated in-place" or "must be replaced"
+	Action string
+}
+
+// resourceChangeRe matches the header terraform prints above each planned change, like
+// "  # module.vpc.aws_subnet.private["a"] will be updated in-place", "  # aws_instance.web is tainted, so must be
+// replaced" or "  # aws_instance.web (deposed object 1a2b3c) will be destroyed". Headers of the "Objects have changed
+// outside of Terraform" section end in "has changed" or "has been deleted" and are not planned changes.
+var resourceChangeRe = regexp.MustCompile(`(?m)^\s*# ((?:module\.[^.\[\s]+(?:\[[^\]]*\])?\.)*(?:data\.)?([A-Za-z0-9_-]+)\.\S+)(?: \(deposed object [^)]*\))? ((?:will|must be|is tainted, so must be) .*)$`)
+
+// ParseResourceChanges returns the resources a terraform plan output plans to change
+func ParseResourceChanges(terraformOutput string) []ResourceChange {
+	matches := resourceChangeRe.FindAllStringSubmatch(NormalizePlanText(terraformOutput), -1)
+	ret := make([]ResourceChange, 0, len(matches))
+	for _, m := range matches {
+		ret = append(ret, ResourceChange{Address: m[1], Type: m[2], Action: strings.TrimSpace(m[3])})
+	}
+	return ret
+}
+
+// planCountsRe matches the totals line terraform prints after the planned changes
+var planCountsRe = regexp.MustCompile(`Plan: (?:\d+ to import, )?(\d+) to add, (\d+) to change, (\d+) to destroy`)
+
+// planCounts returns the add, change and destroy totals of a plan summary, and false if it has no totals line
+func planCounts(summary PlanSummary) ([3]int, bool) {
+	m := planCountsRe.FindStringSubmatch(NormalizePlanText(summary.Output))
+	if m == nil {
+		m = planCountsRe.FindStringSubmatch(NormalizePlanText(summary.Summary))
+	}
+	if m == nil {
+		return [3]int{}, false
+	}
+	var ret [3]int
+	for i := range ret {
+		ret[i], _ = strconv.Atoi(m[i+1])
+	}
+	return ret, true
+}
+
+// changesAddUp reports whether changes account for the totals of summary. Without a totals line there is nothing to
+// check against.
+func changesAddUp(summary PlanSummary, changes []ResourceChange) bool {
+	want, ok := planCounts(summary)
+	if !ok {
+		return true
+	}
+	var got [3]int
+	for _, c := range changes {
+		switch {
+		case strings.HasSuffix(c.Action, "must be replaced") || strings.HasPrefix(c.Action, "will be replaced"):
+			got[0]++
+			got[2]++
+		case strings.HasPrefix(c.Action, "will be created"):
+			got[0]++
+		case strings.HasPrefix(c.Action, "will be updated"):
+			got[1]++
+		case strings.HasPrefix(c.Action, "will be destroyed"):
+			got[2]++
+		}
+	}
+	return got == want
+}
+
+// HasChangesIgnoring is like HasChanges, but treats a plan as clean if every resource it changes has one of
+// ignoredTypes. Plans that change anything else, including outputs only, still count as changes.
+func (p *PlanResult) HasChangesIgnoring(ignoredTypes []string) bool {
+	if len(ignoredTypes) == 0 {
+		return p.HasChanges()
+	}
+	return p.hasChanges(ignoredTypes, true)
+}
+
+// HasResourceChangesIgnoring is like HasChangesIgnoring, but also treats a plan as clean if the only changes left
+// are to output values
+func (p *PlanResult) HasResourceChangesIgnoring(ignoredTypes []string) bool {
+	return p.hasChanges(ignoredTypes, false)
+}
+
+func (p *PlanResult) hasChanges(ignoredTypes []string, countOutputs bool) bool {
+	ignored := make(map[string]struct{}, len(ignoredTypes))
+	for _, t := range ignoredTypes {
+		ignored[strings.TrimSpace(t)] = struct{}{}
+	}
+	for _, summary := range p.Summaries {
+		single := PlanResult{Summaries: []PlanSummary{summary}}
+		if !single.HasChanges() {
+			continue
+		}
+		changes := ParseResourceChanges(summary.Output)
+		outputs := strings.Contains(NormalizePlanText(summary.Output), "Changes to Outputs")
+		if len(changes) == 0 && !outputs {
+			// Changes we can't attribute to anything count, to be safe
+			return true
+		}
+		if !changesAddUp(summary, changes) {
+			// Some changes are in a form we don't recognize, so we can't tell if they are all ignored. This also means
+			// only a plan without resource totals, or with all zero totals, can count as changing outputs only.
+			return true
+		}
+		for _, c := range changes {
+			if _, ok := ignored[c.Type]; !ok {
+				return true
+			}
+		}
+		if outputs && countOutputs {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangedResources returns the sorted, de-duplicated addresses of every resource the plan changes, leaving out
+// resources with one of ignoredTypes
+func (p *PlanResult) ChangedResources(ignoredTypes []string) []string {
+	ignored := make(map[string]struct{}, len(ignoredTypes))
+	for _, t := range ignoredTypes {
+		ignored[strings.TrimSpace(t)] = struct{}{}
+	}
+	seen := make(map[string]struct{})
+	ret := make([]string, 0)
+	for _, summary := range p.Summaries {
+		for _, c := range ParseResourceChanges(summary.Output) {
+			if _, ok := ignored[c.Type]; ok {
+				continue
+			}
+			if _, ok := seen[c.Address]; ok {
+				continue
+			}
+			seen[c.Address] = struct{}{}
+			ret = append(ret, c.Address)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,137 @@
+package atlantis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testMixedPlan = `Terraform will perform the following actions:
+
+  # aws_iam_access_key.ci will be updated in-place
+  ~ resource "aws_iam_access_key" "ci" {
+    }
+
+  # module.app["blue"].random_id.suffix must be replaced
+-/+ resource "random_id" "suffix" {
+    }
+
+  # module.app["blue"].module.db.aws_db_instance.main will be updated in-place
+  ~ resource "aws_db_instance" "main" {
+    }
+
+Plan: 1 to add, 2 to change, 1 to destroy.
+`
+
+const testTaintedPlan = `Terraform will perform the following actions:
+
+  # aws_instance.web is tainted, so must be replaced
+-/+ resource "aws_instance" "web" {
+    }
+
+  # aws_instance.api (deposed object 1a2b3c4d) will be destroyed
+  - resource "aws_instance" "api" {
+    }
+
+  # random_id.suffix will be updated in-place
+  ~ resource "random_id" "suffix" {
+    }
+
+Plan: 1 to add, 1 to change, 2 to destroy.
+`
+
+func TestParseResourceChanges(t *testing.T) {
+	require.Equal(t, []ResourceChange{
+		{Address: "aws_iam_access_key.ci", Type: "aws_iam_access_key", Action: "will be updated in-place"},
+		{Address: `module.app["blue"].random_id.suffix`, Type: "random_id", Action: "must be replaced"},
+		{Address: `module.app["blue"].module.db.aws_db_instance.main`, Type: "aws_db_instance", Action: "will be updated in-place"},
+	}, ParseResourceChanges(testMixedPlan))
+	require.Equal(t, []ResourceChange{
+		{Address: "aws_instance.web", Type: "aws_instance", Action: "is tainted, so must be replaced"},
+		{Address: "aws_instance.api", Type: "aws_instance", Action: "will be destroyed"},
+		{Address: "random_id.suffix", Type: "random_id", Action: "will be updated in-place"},
+	}, ParseResourceChanges(testTaintedPlan))
+	outside := "Note: Objects have changed outside of Terraform\n\n  # aws_s3_bucket.logs has changed\n"
+	require.Empty(t, ParseResourceChanges(outside))
+}
+
+func TestPlanResult_HasChangesIgnoring(t *testing.T) {
+	mixed := PlanResult{Summaries: []PlanSummary{{Summary: "Plan: 1 to add, 2 to change, 1 to destroy.", Output: testMixedPlan}}}
+	require.True(t, mixed.HasChangesIgnoring(nil))
+	require.True(t, mixed.HasChangesIgnoring([]string{"aws_iam_access_key", "random_id"}))
+	require.False(t, mixed.HasChangesIgnoring([]string{"aws_iam_access_key", "random_id", "aws_db_instance"}))
+
+	onlyIgnored := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 0 to add, 1 to change, 0 to destroy.",
+		Output:  "  # aws_iam_access_key.ci will be updated in-place\n",
+	}}}
+	require.False(t, onlyIgnored.HasChangesIgnoring([]string{"aws_iam_access_key"}))
+
+	withOutputs := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 0 to add, 1 to change, 0 to destroy.",
+		Output:  "  # aws_iam_access_key.ci will be updated in-place\n\nChanges to Outputs:\n  ~ id = \"a\" -> \"b\"\n",
+	}}}
+	require.True(t, withOutputs.HasChangesIgnoring([]string{"aws_iam_access_key"}))
+
+	tainted := PlanResult{Summaries: []PlanSummary{{Summary: "Plan: 1 to add, 1 to change, 2 to destroy.", Output: testTaintedPlan}}}
+	require.True(t, tainted.HasChangesIgnoring([]string{"random_id"}))
+	require.False(t, tainted.HasChangesIgnoring([]string{"random_id", "aws_instance"}))
+
+	unrecognized := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 1 to add, 1 to change, 0 to destroy.",
+		Output:  "  # random_id.suffix will be updated in-place\n  # aws_instance.web is going to appear\n\nPlan: 1 to add, 1 to change, 0 to destroy.\n",
+	}}}
+	require.True(t, unrecognized.HasChangesIgnoring([]string{"random_id"}), "totals that don't add up count as drift")
+}
+
+func TestPlanResult_HasResourceChangesIgnoring(t *testing.T) {
+	outputsOnly := PlanResult{Summaries: []PlanSummary{{
+		Output: "Changes to Outputs:\n  ~ id = \"a\" -> \"b\"\n\nYou can apply this plan to save these new output values to the Terraform\nstate, without changing any real infrastructure.\n",
+	}}}
+	require.True(t, outputsOnly.HasChangesIgnoring(nil))
+	require.False(t, outputsOnly.HasResourceChangesIgnoring(nil))
+
+	withOutputs := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 0 to add, 1 to change, 0 to destroy.",
+		Output:  "  # aws_iam_access_key.ci will be updated in-place\n\nChanges to Outputs:\n  ~ id = \"a\" -> \"b\"\n",
+	}}}
+	require.True(t, withOutputs.HasResourceChangesIgnoring(nil))
+	require.False(t, withOutputs.HasResourceChangesIgnoring([]string{"aws_iam_access_key"}))
+
+	hiddenResources := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 1 to add, 0 to change, 1 to destroy.",
+		Output:  "Changes to Outputs:\n  ~ id = \"a\" -> \"b\"\n",
+	}}}
+	require.True(t, hiddenResources.HasResourceChangesIgnoring(nil), "resource totals mean more than outputs changed")
+
+	zeroTotals := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 0 to add, 0 to change, 0 to destroy.",
+		Output:  "Changes to Outputs:\n  ~ id = \"a\" -> \"b\"\n",
+	}}}
+	require.False(t, zeroTotals.HasResourceChangesIgnoring(nil))
+
+	tainted := PlanResult{Summaries: []PlanSummary{{
+		Summary: "Plan: 1 to add, 1 to change, 2 to destroy.",
+		Output:  testTaintedPlan + "\nChanges to Outputs:\n  ~ id = \"a\" -> \"b\"\n",
+	}}}
+	require.True(t, tainted.HasResourceChangesIgnoring([]string{"random_id"}))
+
+	unknown := PlanResult{Summaries: []PlanSummary{{Output: "something terraform printed"}}}
+	require.True(t, unknown.HasResourceChangesIgnoring(nil))
+
+	clean := PlanResult{Summaries: []PlanSummary{{Summary: "No changes. Your infrastructure matches the configuration."}}}
+	require.False(t, clean.HasResourceChangesIgnoring(nil))
+}
+
+func TestPlanResult_ChangedResources(t *testing.T) {
+	pr := PlanResult{Summaries: []PlanSummary{
+		{Output: testMixedPlan},
+		{Output: "  # aws_iam_access_key.ci will be updated in-place\n"},
+	}}
+	require.Equal(t, []string{
+		"aws_iam_access_key.ci",
+		`module.app["blue"].module.db.aws_db_instance.main`,
+		`module.app["blue"].random_id.suffix`,
+	}, pr.ChangedResources(nil))
+	require.Equal(t, []string{"aws_iam_access_key.ci"}, pr.ChangedResources([]string{"random_id", "aws_db_instance"}))
+}
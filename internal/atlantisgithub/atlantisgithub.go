@@ -1,10 +1,20 @@
 package atlantisgithub
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
+	"github.com/cresta/pipe"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
 	"go.uber.org/zap"
 )
 
@@ -24,3 +34,97 @@ func CheckOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cl
 	}
 	return repository, nil
 }
+
+// CheckOutBranch checks out branch in a clone made by CheckOutTerraformRepo. The branch must exist on the origin
+// remote, so a misspelled branch fails the run instead of silently leaving the default branch checked out.
+func CheckOutBranch(ctx context.Context, repo *gogit.Repository, branch string) error {
+	var stdout, stderr bytes.Buffer
+	// --exit-code makes ls-remote exit with 2 when no ref matches
+	err := pipe.NewPiped("git", "ls-remote", "--exit-code", "--heads", "origin", "refs/heads/"+branch).WithDir(repo.Location()).Execute(ctx, nil, &stdout, &stderr)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return fmt.Errorf("branch %s does not exist on the remote", branch)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up branch %s: %s: %w", branch, strings.TrimSpace(stderr.String()), err)
+	}
+	stderr.Reset()
+	if err := pipe.NewPiped("git", "checkout", "-B", branch, "--track", "origin/"+branch).WithDir(repo.Location()).Execute(ctx, nil, &stdout, &stderr); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %s: %w", branch, strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
+
+// NewAppClient returns a GitHub client authenticated as a GitHub App installation. It signs JWTs with privateKey, a
+// PEM encoded key, and exchanges them for installation tokens as needed.
+func NewAppClient(ctx context.Context, logger *zap.Logger, transport http.RoundTripper, appID int64, installationID int64, privateKey string) (gogithub.GitHub, error) {
+	if appID == 0 || installationID == 0 || privateKey == "" {
+		return nil, fmt.Errorf("github app id, installation id and private key are all required")
+	}
+	// gogithub prefers a GITHUB_TOKEN from the environment over an app key, which would silently ignore the app
+	if os.Getenv("GITHUB_TOKEN") != "" {
+		return nil, fmt.Errorf("GITHUB_TOKEN is set and would be used instead of the github app")
+	}
+	client, err := gogithub.NewGQLClient(ctx, logger, &gogithub.NewGQLClientConfig{
+		Rt:             transport,
+		AppID:          appID,
+		InstallationID: installationID,
+		PEMKey:         privateKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create github app client: %w", err)
+	}
+	return client, nil
+}
+
+// defaultBranchRetryDelay is the delay before the first retry of a failed default branch lookup. It doubles each retry.
+var defaultBranchRetryDelay = time.Second
+
+const defaultBranchAttempts = 3
+
+// DefaultBranch returns the default branch of repo, in owner/name form. A cached value younger than cacheValidDuration
+// at now is used if present, otherwise the branch is looked up from GitHub, retrying on failure, and stored in the
+// cache as looked up at now.
+func DefaultBranch(ctx context.Context, gitHubClient gogithub.GitHub, cache processedcache.ProcessedCache, repo string, cacheValidDuration time.Duration, now time.Time, logger *zap.Logger) (string, error) {
+	cacheKey := &processedcache.ConsiderDefaultBranch{Repo: repo}
+	cacheVal, err := cache.GetDefaultBranch(ctx, cacheKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cached default branch for %s: %w", repo, err)
+	}
+	if cacheVal != nil && now.Sub(cacheVal.When) < cacheValidDuration {
+		return cacheVal.Branch, nil
+	}
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok {
+		return "", fmt.Errorf("repo %s is not in owner/name form", repo)
+	}
+	var info *gogithub.RepositoryInfo
+	delay := defaultBranchRetryDelay
+	for attempt := 1; ; attempt++ {
+		info, err = gitHubClient.RepositoryInfo(ctx, owner, name)
+		if err == nil {
+			break
+		}
+		if attempt == defaultBranchAttempts {
+			return "", fmt.Errorf("failed to get default branch for %s: %w", repo, err)
+		}
+		logger.Warn("Failed to get default branch, retrying", zap.String("repo", repo), zap.Int("attempt", attempt), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		delay *= 2
+	}
+	branch := string(info.Repository.DefaultBranchRef.Name)
+	if branch == "" {
+		return "", fmt.Errorf("repo %s has no default branch", repo)
+	}
+	if err := cache.StoreDefaultBranch(ctx, cacheKey, &processedcache.DefaultBranchValue{
+		Branch: branch,
+		When:   now,
+	}); err != nil {
+		logger.Warn("Unable to cache default branch", zap.String("repo", repo), zap.Error(err))
+	}
+	return branch, nil
+}
@@ -0,0 +1,115 @@
+package atlantisgithub
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/cresta/gogit"
+	"github.com/cresta/gogithub"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type flakyGitHub struct {
+	gogithub.GitHub
+	failures int
+	calls    int
+}
+
+func (f *flakyGitHub) RepositoryInfo(_ context.Context, _ string, _ string) (*gogithub.RepositoryInfo, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("github is down")
+	}
+	var info gogithub.RepositoryInfo
+	info.Repository.DefaultBranchRef.Name = "main"
+	return &info, nil
+}
+
+type memoryBranchCache struct {
+	processedcache.Noop
+	branches map[string]*processedcache.DefaultBranchValue
+}
+
+func (m *memoryBranchCache) GetDefaultBranch(_ context.Context, key *processedcache.ConsiderDefaultBranch) (*processedcache.DefaultBranchValue, error) {
+	return m.branches[key.String()], nil
+}
+
+func (m *memoryBranchCache) StoreDefaultBranch(_ context.Context, key *processedcache.ConsiderDefaultBranch, value *processedcache.DefaultBranchValue) error {
+	m.branches[key.String()] = value
+	return nil
+}
+
+// readOnlyBranchCache fails every store, like a cache without write access
+type readOnlyBranchCache struct {
+	processedcache.Noop
+}
+
+func (readOnlyBranchCache) StoreDefaultBranch(_ context.Context, _ *processedcache.ConsiderDefaultBranch, _ *processedcache.DefaultBranchValue) error {
+	return errors.New("access denied")
+}
+
+func TestDefaultBranch(t *testing.T) {
+	previousDelay := defaultBranchRetryDelay
+	defaultBranchRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		defaultBranchRetryDelay = previousDelay
+	})
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gh := &flakyGitHub{failures: 2}
+	cache := &memoryBranchCache{branches: map[string]*processedcache.DefaultBranchValue{}}
+	branch, err := DefaultBranch(ctx, gh, cache, "owner/repo", time.Hour, now, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+	require.Equal(t, 3, gh.calls)
+
+	branch, err = DefaultBranch(ctx, gh, cache, "owner/repo", time.Hour, now, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+	require.Equal(t, 3, gh.calls, "second lookup should be served from cache")
+
+	now = now.Add(2 * time.Hour)
+	_, err = DefaultBranch(ctx, gh, cache, "owner/repo", time.Hour, now, zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.Equal(t, 4, gh.calls, "an expired cached branch is looked up again")
+	require.Equal(t, now, cache.branches["owner/repo"].When)
+
+	_, err = DefaultBranch(ctx, &flakyGitHub{failures: defaultBranchAttempts}, processedcache.Noop{}, "owner/repo", time.Hour, now, zaptest.NewLogger(t))
+	require.Error(t, err)
+
+	branch, err = DefaultBranch(ctx, &flakyGitHub{}, readOnlyBranchCache{}, "owner/repo", time.Hour, now, zaptest.NewLogger(t))
+	require.NoError(t, err, "failing to cache the branch does not fail the lookup")
+	require.Equal(t, "main", branch)
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestCheckOutBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	origin := t.TempDir()
+	runGit(t, origin, "init", "-b", "main")
+	runGit(t, origin, "commit", "--allow-empty", "-m", "main")
+	runGit(t, origin, "branch", "env/prod")
+	cloner := &gogit.Cloner{Logger: gogit.SilentLogger{}, TempDir: t.TempDir()}
+	repo, err := cloner.Clone(ctx, origin)
+	require.NoError(t, err)
+
+	require.ErrorContains(t, CheckOutBranch(ctx, repo, "env/prdo"), "does not exist")
+	require.NoError(t, CheckOutBranch(ctx, repo, "env/prod"))
+	branch, err := repo.CurrentBranchName(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "env/prod", branch)
+}
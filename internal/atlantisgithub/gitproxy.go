@@ -0,0 +1,48 @@
+package atlantisgithub
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// ConfigureGitProxy makes git subprocesses, like the clone in CheckOutTerraformRepo, use proxyURL for http and https
+// remotes, authenticating with username and password if username is set. The proxy is passed to git as config through
+// the GIT_CONFIG_COUNT environment variables, so for git it takes precedence over HTTP_PROXY, HTTPS_PROXY and
+// ALL_PROXY, while terraform and the HTTP clients keep using the ambient proxy. An empty proxyURL changes nothing.
+func ConfigureGitProxy(proxyURL string, username string, password string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		// The url.Error would repeat the URL, which may hold credentials
+		return fmt.Errorf("invalid git proxy url: %w", errors.Unwrap(err))
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("git proxy url needs a scheme and host, like http://proxy:3128")
+	}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	// Keep any config already passed to git through the environment
+	count := 0
+	if existing := os.Getenv("GIT_CONFIG_COUNT"); existing != "" {
+		if count, err = strconv.Atoi(existing); err != nil {
+			return fmt.Errorf("invalid GIT_CONFIG_COUNT %s: %w", existing, err)
+		}
+	}
+	env := [][2]string{
+		{fmt.Sprintf("GIT_CONFIG_KEY_%d", count), "http.proxy"},
+		{fmt.Sprintf("GIT_CONFIG_VALUE_%d", count), u.String()},
+		{"GIT_CONFIG_COUNT", strconv.Itoa(count + 1)},
+	}
+	for _, kv := range env {
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", kv[0], err)
+		}
+	}
+	return nil
+}
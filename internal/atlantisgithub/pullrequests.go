@@ -0,0 +1,165 @@
+package atlantisgithub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/cresta/gogithub"
+)
+
+// pullRequestPageSize is the largest page the GitHub REST API returns
+const pullRequestPageSize = 100
+
+// maxPullRequestFilePages stops listing a pull request's files where the GitHub API does, at 3000 files
+const maxPullRequestFilePages = 30
+
+// OpenPullRequests finds open pull requests of Repo into Base that change files in a directory, through the GitHub
+// REST API. Draft pull requests are not ready to be applied, so they are left out. Pull requests and their files are
+// listed once, on first successful use, so a run makes the same number of API calls however many directories drift.
+type OpenPullRequests struct {
+	GitHub     gogithub.GitHub
+	HTTPClient *http.Client
+	// BaseURL is the GitHub API URL. Empty uses https://api.github.com.
+	BaseURL string
+	// Repo is in owner/name form
+	Repo string
+	// Base is the branch the pull requests merge into, usually the planned ref. Empty is the default branch of Repo.
+	Base string
+
+	mu     sync.Mutex
+	listed bool
+	pulls  []openPullRequest
+}
+
+type openPullRequest struct {
+	url   string
+	files []string
+}
+
+// PullRequestsTouching returns the URLs of open pull requests that change a file in dir or below it. A failed listing
+// is not remembered, so the next call tries again.
+func (o *OpenPullRequests) PullRequestsTouching(ctx context.Context, dir string) ([]string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.listed {
+		pulls, err := o.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.pulls = pulls
+		o.listed = true
+	}
+	var ret []string
+	for _, pr := range o.pulls {
+		for _, f := range pr.files {
+			if dir == "." || dir == "" || strings.HasPrefix(f, dir+"/") {
+				ret = append(ret, pr.url)
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (o *OpenPullRequests) list(ctx context.Context) ([]openPullRequest, error) {
+	token, err := o.GitHub.GetAccessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	base := o.Base
+	if base == "" {
+		var repo struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := o.get(ctx, token, "/repos/"+o.Repo, &repo); err != nil {
+			return nil, fmt.Errorf("failed to find default branch: %w", err)
+		}
+		base = repo.DefaultBranch
+	}
+	var ret []openPullRequest
+	for page := 1; ; page++ {
+		var pulls []struct {
+			Number  int    `json:"number"`
+			HTMLURL string `json:"html_url"`
+			Draft   bool   `json:"draft"`
+		}
+		if err := o.get(ctx, token, fmt.Sprintf("/repos/%s/pulls?state=open&base=%s&per_page=%d&page=%d", o.Repo, url.QueryEscape(base), pullRequestPageSize, page), &pulls); err != nil {
+			return nil, fmt.Errorf("failed to list open pull requests: %w", err)
+		}
+		for _, p := range pulls {
+			if p.Draft {
+				continue
+			}
+			files, err := o.listFiles(ctx, token, p.Number)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list files of pull request %d: %w", p.Number, err)
+			}
+			ret = append(ret, openPullRequest{url: p.HTMLURL, files: files})
+		}
+		if len(pulls) < pullRequestPageSize {
+			return ret, nil
+		}
+	}
+}
+
+func (o *OpenPullRequests) listFiles(ctx context.Context, token string, number int) ([]string, error) {
+	var ret []string
+	for page := 1; page <= maxPullRequestFilePages; page++ {
+		var files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		}
+		if err := o.get(ctx, token, fmt.Sprintf("/repos/%s/pulls/%d/files?per_page=%d&page=%d", o.Repo, number, pullRequestPageSize, page), &files); err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			ret = append(ret, f.Filename)
+			// A file moved out of a directory still changes it
+			if f.PreviousFilename != "" {
+				ret = append(ret, f.PreviousFilename)
+			}
+		}
+		if len(files) < pullRequestPageSize {
+			break
+		}
+	}
+	return ret, nil
+}
+
+func (o *OpenPullRequests) get(ctx context.Context, token string, path string, into interface{}) error {
+	base := o.BaseURL
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := o.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call github: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d from github: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return fmt.Errorf("failed to decode github response: %w", err)
+	}
+	return nil
+}
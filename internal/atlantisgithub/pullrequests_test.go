@@ -0,0 +1,77 @@
+package atlantisgithub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cresta/gogithub"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenGitHub struct {
+	gogithub.GitHub
+}
+
+func (tokenGitHub) GetAccessToken(_ context.Context) (string, error) {
+	return "token", nil
+}
+
+func TestOpenPullRequests_PullRequestsTouching(t *testing.T) {
+	calls := 0
+	failPulls := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var body interface{}
+		switch r.URL.Path {
+		case "/repos/owner/repo":
+			body = map[string]string{"default_branch": "main"}
+		case "/repos/owner/repo/pulls":
+			if failPulls {
+				failPulls = false
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			require.Equal(t, "open", r.URL.Query().Get("state"))
+			require.Equal(t, "main", r.URL.Query().Get("base"))
+			body = []map[string]interface{}{
+				{"number": 1, "html_url": "https://github.com/owner/repo/pull/1"},
+				{"number": 2, "html_url": "https://github.com/owner/repo/pull/2"},
+				{"number": 3, "html_url": "https://github.com/owner/repo/pull/3", "draft": true},
+			}
+		case "/repos/owner/repo/pulls/1/files":
+			body = []map[string]string{{"filename": "env/prod/main.tf"}}
+		case "/repos/owner/repo/pulls/2/files":
+			body = []map[string]string{{"filename": "env/staging/main.tf", "previous_filename": "env/prod-old/main.tf"}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer srv.Close()
+	o := &OpenPullRequests{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL, Repo: "owner/repo"}
+	ctx := context.Background()
+
+	_, err := o.PullRequestsTouching(ctx, "env/prod")
+	require.ErrorContains(t, err, "502")
+	calls = 0
+	// The failure is not remembered
+	prs, err := o.PullRequestsTouching(ctx, "env/prod")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://github.com/owner/repo/pull/1"}, prs)
+	prs, err = o.PullRequestsTouching(ctx, "env/prod-old")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://github.com/owner/repo/pull/2"}, prs)
+	prs, err = o.PullRequestsTouching(ctx, "env/dev")
+	require.NoError(t, err)
+	require.Empty(t, prs)
+	require.Equal(t, 4, calls, "pull requests are listed once, without the files of drafts")
+
+	failing := &OpenPullRequests{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL, Repo: "owner/missing", Base: "main"}
+	_, err = failing.PullRequestsTouching(ctx, "env/prod")
+	require.ErrorContains(t, err, "404")
+}
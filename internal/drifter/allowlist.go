@@ -0,0 +1,45 @@
+package drifter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// AllowlistMatchMode controls how DirectoryAllowlist entries are compared against a directory
+type AllowlistMatchMode string
+
+const (
+	// AllowlistMatchContains allows a directory if it contains an allowlist entry as a substring
+	AllowlistMatchContains AllowlistMatchMode = "contains"
+	// AllowlistMatchGlob allows a directory if it matches an allowlist entry as a path.Match glob
+	AllowlistMatchGlob AllowlistMatchMode = "glob"
+	// AllowlistMatchRegex allows a directory if it matches an allowlist entry as a regular expression
+	AllowlistMatchRegex AllowlistMatchMode = "regex"
+	// AllowlistMatchExact allows a directory only if it equals an allowlist entry, ignoring trailing slashes
+	AllowlistMatchExact AllowlistMatchMode = "exact"
+)
+
+func ParseAllowlistMatchMode(s string) (AllowlistMatchMode, error) {
+	switch m := AllowlistMatchMode(s); m {
+	case "":
+		return AllowlistMatchContains, nil
+	case AllowlistMatchContains, AllowlistMatchGlob, AllowlistMatchRegex, AllowlistMatchExact:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown allowlist match mode: %s", s)
+}
+
+func (m AllowlistMatchMode) matches(dir string, pattern string) (bool, error) {
+	switch m {
+	case AllowlistMatchGlob:
+		return path.Match(pattern, dir)
+	case AllowlistMatchRegex:
+		return regexp.MatchString(pattern, dir)
+	case AllowlistMatchExact:
+		return strings.TrimRight(dir, "/") == strings.TrimRight(pattern, "/"), nil
+	default:
+		return strings.Contains(dir, pattern), nil
+	}
+}
@@ -0,0 +1,64 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDrifter_shouldSkipDirectory(t *testing.T) {
+	cases := []struct {
+		mode  AllowlistMatchMode
+		allow string
+		dir   string
+		skip  bool
+	}{
+		{mode: AllowlistMatchContains, allow: "infra", dir: "infra", skip: false},
+		{mode: AllowlistMatchContains, allow: "infra", dir: "infra-legacy", skip: false},
+		{mode: AllowlistMatchContains, allow: "infra", dir: "other", skip: true},
+		{mode: AllowlistMatchExact, allow: "infra/", dir: "infra", skip: false},
+		{mode: AllowlistMatchExact, allow: "infra", dir: "infra/", skip: false},
+		{mode: AllowlistMatchExact, allow: "infra", dir: "infra-legacy", skip: true},
+		{mode: AllowlistMatchGlob, allow: "infra/*", dir: "infra/child", skip: false},
+		{mode: AllowlistMatchGlob, allow: "infra/*", dir: "infra/child/grandchild", skip: true},
+		{mode: AllowlistMatchRegex, allow: "^infra(-legacy)?$", dir: "infra-legacy", skip: false},
+		{mode: AllowlistMatchRegex, allow: "^infra(-legacy)?$", dir: "infra-new", skip: true},
+	}
+	for _, c := range cases {
+		d := &Drifter{
+			Logger:             zaptest.NewLogger(t),
+			DirectoryAllowlist: []string{c.allow},
+			AllowlistMatchMode: c.mode,
+		}
+		require.Equal(t, c.skip, d.shouldSkipDirectory(c.dir), "mode=%s allow=%s dir=%s", c.mode, c.allow, c.dir)
+	}
+}
+
+func TestDrifter_shouldSkipWorkspaceAudit(t *testing.T) {
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		AllowlistMatchMode: AllowlistMatchGlob,
+	}
+	require.False(t, d.shouldSkipWorkspaceAudit("prod/app"))
+	d.WorkspaceAuditAllowlist = []string{"prod/*"}
+	require.False(t, d.shouldSkipWorkspaceAudit("prod/app"))
+	require.True(t, d.shouldSkipWorkspaceAudit("dev/app"))
+	d.WorkspaceAuditDenylist = []string{"prod/legacy"}
+	require.True(t, d.shouldSkipWorkspaceAudit("prod/legacy"))
+	require.False(t, d.shouldSkipWorkspaceAudit("prod/app"))
+	d.WorkspaceAuditAllowlist = nil
+	require.False(t, d.shouldSkipWorkspaceAudit("dev/app"))
+	require.False(t, d.shouldSkipDirectory("dev/app"))
+}
+
+func TestParseAllowlistMatchMode(t *testing.T) {
+	m, err := ParseAllowlistMatchMode("")
+	require.NoError(t, err)
+	require.Equal(t, AllowlistMatchContains, m)
+	m, err = ParseAllowlistMatchMode("exact")
+	require.NoError(t, err)
+	require.Equal(t, AllowlistMatchExact, m)
+	_, err = ParseAllowlistMatchMode("fuzzy")
+	require.Error(t, err)
+}
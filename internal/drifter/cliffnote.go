@@ -0,0 +1,54 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"go.uber.org/zap"
+)
+
+// truncateCliffnote keeps the first maxLines lines of a cliffnote plus every "Plan:" count line after them. Zero
+// maxLines keeps everything.
+func truncateCliffnote(cliffnote string, maxLines int) string {
+	lines := strings.Split(cliffnote, "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return cliffnote
+	}
+	kept := append([]string{}, lines[:maxLines]...)
+	dropped := 0
+	for _, line := range lines[maxLines:] {
+		if strings.HasPrefix(line, "Plan:") {
+			kept = append(kept, line)
+			continue
+		}
+		dropped++
+	}
+	if dropped > 0 {
+		kept = append(kept, fmt.Sprintf("... %d more lines", dropped))
+	}
+	return strings.Join(kept, "\n")
+}
+
+// buildCliffnote returns the cliffnote to notify for a drifted plan, redacted, truncated to MaxCliffnoteLines and
+// linking to the full plan if a PlanStore is configured. A failed upload only loses the link.
+func (d *Drifter) buildCliffnote(ctx context.Context, dir string, workspace string, pr *atlantis.PlanResult) string {
+	cliffnote := truncateCliffnote(d.redact(pr.GetPlanResultSummary()), d.MaxCliffnoteLines)
+	if d.PlanStore == nil {
+		return cliffnote
+	}
+	output := d.redact(pr.GetPlanOutput())
+	if output == "" {
+		return cliffnote
+	}
+	// Repositories of a manifest share the plan store
+	key := path.Join(d.RunID, d.Repo, dir, workspace+".txt")
+	link, err := d.PlanStore.Put(ctx, key, []byte(output))
+	if err != nil {
+		d.Logger.Warn("Unable to store full plan", zap.String("dir", dir), zap.String("workspace", workspace), zap.Error(err))
+		return cliffnote
+	}
+	return cliffnote + "\nFull plan: " + link
+}
@@ -0,0 +1,14 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateCliffnote(t *testing.T) {
+	cliffnote := "Note: Objects have changed outside of Terraform.\nNote: Contains output changes.\nPlan: 1 to add, 0 to change, 0 to destroy.\nNote: Contains output changes.\nPlan: 0 to add, 2 to change, 0 to destroy."
+	require.Equal(t, cliffnote, truncateCliffnote(cliffnote, 0))
+	require.Equal(t, cliffnote, truncateCliffnote(cliffnote, 5))
+	require.Equal(t, "Note: Objects have changed outside of Terraform.\nPlan: 1 to add, 0 to change, 0 to destroy.\nPlan: 0 to add, 2 to change, 0 to destroy.\n... 2 more lines", truncateCliffnote(cliffnote, 1))
+}
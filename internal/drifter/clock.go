@@ -0,0 +1,58 @@
+package drifter
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the Drifter the time, so cache expiry and other time based decisions can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the system clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock that only moves when told to. It is safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+var _ Clock = RealClock{}
+var _ Clock = &FakeClock{}
+
+// now returns the time from Clock, defaulting to the system clock
+func (d *Drifter) now() time.Time {
+	if d.Clock == nil {
+		return time.Now()
+	}
+	return d.Clock.Now()
+}
+
+// since is time.Since using Clock
+func (d *Drifter) since(t time.Time) time.Duration {
+	return d.now().Sub(t)
+}
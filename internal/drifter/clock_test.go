@@ -0,0 +1,159 @@
+package drifter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type memoryCache struct {
+	processedcache.Noop
+	mu     sync.Mutex
+	drifts map[string]processedcache.DriftCheckValue
+}
+
+func (m *memoryCache) GetDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, exists := m.drifts[key.CacheKey()]
+	if !exists {
+		return nil, nil
+	}
+	return &val, nil
+}
+
+func (m *memoryCache) StoreDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked, value *processedcache.DriftCheckValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.drifts == nil {
+		m.drifts = make(map[string]processedcache.DriftCheckValue)
+	}
+	m.drifts[key.CacheKey()] = *value
+	return nil
+}
+
+func TestDrifter_CacheExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	checks := 0
+	d := &Drifter{
+		Logger:       zaptest.NewLogger(t),
+		Notification: newRecordingNotification(t),
+		AtlantisClient: newFakeAtlantis(t, map[string]string{
+			"dir": "Plan: 1 to add, 0 to change, 0 to destroy.",
+		}),
+		ResultCache:        &memoryCache{},
+		CacheValidDuration: time.Hour,
+		Clock:              clock,
+		OnResult: func(_ context.Context, _ DriftResult) {
+			checks++
+		},
+	}
+	ws := atlantis.DirectoriesWithWorkspaces{"dir": {"default"}}
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 1, checks)
+
+	clock.Advance(59 * time.Minute)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 1, checks, "cached result is still valid")
+
+	clock.Advance(time.Minute)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 2, checks, "cached result expired")
+}
+
+func TestDrifter_WarnOnCachedResults(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := &memoryCache{}
+	newDrifter := func(notif *recordingNotification) *Drifter {
+		return &Drifter{
+			Logger:       zaptest.NewLogger(t),
+			Notification: notif,
+			AtlantisClient: newFakeAtlantis(t, map[string]string{
+				"a": "No changes. Your infrastructure matches the configuration.",
+				"b": "No changes. Your infrastructure matches the configuration.",
+			}),
+			ResultCache:                   cache,
+			CacheValidDuration:            24 * time.Hour,
+			CachedResultsWarningThreshold: 0.5,
+			Clock:                         clock,
+		}
+	}
+	notif := newRecordingNotification(t)
+	d := newDrifter(notif)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), atlantis.DirectoriesWithWorkspaces{"a": {"default"}}))
+	require.NoError(t, d.warnOnCachedResults(context.Background()))
+	require.Empty(t, notif.cached, "nothing was served from cache")
+
+	clock.Advance(time.Hour)
+	d = newDrifter(notif)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), atlantis.DirectoriesWithWorkspaces{"a": {"default"}, "b": {"default"}}))
+	require.NoError(t, d.warnOnCachedResults(context.Background()))
+	require.Empty(t, notif.cached, "half of the workspaces is not above the threshold")
+
+	d.CachedResultsWarningThreshold = 0.4
+	require.NoError(t, d.warnOnCachedResults(context.Background()))
+	require.Equal(t, []string{"1/2"}, notif.cached)
+}
+
+func TestDrifter_DriftGracePeriodUsesClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	d := &Drifter{Clock: clock, DriftGracePeriod: 2 * time.Hour}
+	val := &processedcache.DriftCheckValue{Drift: true, FirstDriftSeen: start}
+	require.True(t, d.inDriftGracePeriod(val))
+	clock.Advance(2 * time.Hour)
+	require.False(t, d.inDriftGracePeriod(val))
+}
+
+func TestDrifter_TemporaryErrorCache(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	outage := true
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if outage {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("upstream unavailable"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":"No changes. Your infrastructure matches the configuration."}}]}`))
+	}))
+	defer srv.Close()
+	cache := &memoryCache{}
+	d := &Drifter{
+		Logger:                      zaptest.NewLogger(t),
+		Notification:                newRecordingNotification(t),
+		AtlantisClient:              &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+		ResultCache:                 cache,
+		CacheValidDuration:          24 * time.Hour,
+		TemporaryErrorCacheDuration: 10 * time.Minute,
+		Clock:                       clock,
+	}
+	ws := atlantis.DirectoriesWithWorkspaces{"dir": {"default"}}
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 1, requests)
+	val, err := cache.GetDriftCheckResult(context.Background(), &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+	require.True(t, val.Unknown)
+
+	clock.Advance(5 * time.Minute)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 1, requests, "unknown result is still valid")
+
+	outage = false
+	clock.Advance(5 * time.Minute)
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, 2, requests, "unknown result expired")
+	val, err = cache.GetDriftCheckResult(context.Background(), &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "default"})
+	require.NoError(t, err)
+	require.False(t, val.Unknown)
+	require.True(t, val.EverClean)
+}
@@ -1,109 +1,870 @@
 package drifter
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantisgithub"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/notification"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/planstore"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/statelister"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/terraform"
+	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v2"
 )
 
+// DriftResult is the outcome of checking a single workspace for drift
+type DriftResult struct {
+	Dir       string
+	Workspace string
+	// Ref is the comparison ref planned against, or empty for the default ref
+	Ref string
+	// Drift is true if the plan had changes
+	Drift bool
+	// Locked is true if atlantis could not plan because the project is locked
+	Locked bool
+	// NeverPlanned is true if atlantis has no plan for the workspace because it was never planned
+	NeverPlanned bool
+	Cliffnote    string
+	// Err is set if the plan summary could not be fetched or the plan had errors
+	Err error
+	// Source is processedcache.SourceFresh for a workspace planned by this run, or else the cache backend its result
+	// was read from. Only the report has cached results.
+	Source processedcache.Source
+}
+
+// WorkspaceAudit is what the extra workspace check found for one directory. Directories with several backend configs
+// have one audit per backend config.
+type WorkspaceAudit struct {
+	Dir           string `json:"dir"`
+	BackendConfig string `json:"backend_config,omitempty"`
+	// Extra are workspaces in the remote state that atlantis does not know about
+	Extra []string `json:"extra,omitempty"`
+	// Missing are workspaces atlantis plans that have no remote state
+	Missing []string `json:"missing,omitempty"`
+}
+
+// defaultBranchCacheDuration is how long a detected default branch is trusted. Default branches rarely change.
+const defaultBranchCacheDuration = 24 * time.Hour
+
 type Drifter struct {
-	Logger                  *zap.Logger
-	Repo                    string
-	Cloner                  *gogit.Cloner
-	GithubClient            gogithub.GitHub
-	Terraform               *terraform.Client
-	AtlantisRepoYmlPath     string
-	Notification            notification.Notification
-	AtlantisClient          *atlantis.Client
-	ResultCache             processedcache.ProcessedCache
-	CacheValidDuration      time.Duration
-	DirectoryAllowlist      []string
-	SkipWorkspaceCheck      bool
-	ParallelRuns            int
-	AutoGenerateConfig      bool
+	Logger              *zap.Logger
+	Repo                string
+	Cloner              *gogit.Cloner
+	GithubClient        gogithub.GitHub
+	Terraform           *terraform.Client
+	AtlantisRepoYmlPath string
+	Notification        notification.Notification
+	AtlantisClient      *atlantis.Client
+	ResultCache         processedcache.ProcessedCache
+	CacheValidDuration  time.Duration
+	DirectoryAllowlist  []string
+	AllowlistMatchMode  AllowlistMatchMode
+	SkipWorkspaceCheck  bool
+	ParallelRuns        int
+	AutoGenerateConfig  bool
+	// RootModuleRules tunes which directories count as root modules
+	RootModuleRules RootModuleRules
+	// PrioritizeStale checks directories whose cached results are oldest, or missing, first, so a run that is cut short
+	// still spreads its checks fairly over time
+	PrioritizeStale bool
+	// WorkspaceLister lists remote workspaces for the extra workspace check. Defaults to Terraform.
+	WorkspaceLister WorkspaceLister
+	// IgnoreResourceTypes are resource types, like random_id, whose changes never count as drift. A plan that only
+	// changes these types is treated as clean.
+	IgnoreResourceTypes []string
+	// OutputsOnlyDriftPolicy controls whether plans that only change output values count as drift. Empty behaves like
+	// OutputsOnlyDrift.
+	OutputsOnlyDriftPolicy OutputsOnlyDriftPolicy
+	// GeneratedConfigOutput, if set, also receives the generated atlantis config, for pipelines that commit it in a
+	// later step
+	GeneratedConfigOutput io.Writer
+	// CheckGeneratedConfig fails the run if the committed atlantis config differs from the generated one
+	CheckGeneratedConfig bool
+	// ValidateConfigBeforePlan fails the run before any plans if a project dir is missing or not a root module
+	ValidateConfigBeforePlan bool
+	// CheckUnmanagedDirectories reports root modules with a backend that have no project in the atlantis config
+	CheckUnmanagedDirectories bool
+	// CachedResultsWarningThreshold is the fraction (0-1) of workspaces served from cache above which a warning is
+	// sent. Zero disables the warning.
+	CachedResultsWarningThreshold float64
+	// CompareRefs are extra refs every workspace is also planned against, to find drift specific to a branch
+	CompareRefs []string
+	// OnResult, if set, is called synchronously after every workspace drift check, including failed ones
+	OnResult func(ctx context.Context, result DriftResult)
+	// MaintenanceWindows suppress drift notifications, including drift at CompareRefs, for runs that start inside any
+	// of them
+	MaintenanceWindows []MaintenanceWindow
+	// BootstrapMode runs a full check and caches the results, but suppresses drift notifications. Use it on the first
+	// run against a repo so only later drift alerts. Drift it finds is cached as notified, so ReNotifyInterval and
+	// NotifyOnlyChangedDrift count from it.
+	BootstrapMode bool
+	// SamplePercent, if between 0 and 100, checks only this share of workspaces each run, preferring the ones checked
+	// least recently, so repeated runs cover every workspace
+	SamplePercent float32
+	// MaxNotificationsPerRun caps how many drift, extra and missing workspace notifications a run sends. The rest are
+	// summarized in one message. Counts and results still cover everything. Zero is unlimited.
+	MaxNotificationsPerRun int
+	// RoutedNotifications are named notification backends. Projects annotated with "# drift-notify: <name>" in the
+	// atlantis config also send their notifications to the backend of that name.
+	RoutedNotifications map[string]notification.Notification
+	// StateLister, if set, lists the state files in the remote backend so state left behind by directories that no
+	// longer have a project is reported
+	StateLister statelister.Lister
+	// StateKeyPattern extracts the directory from a state key with its "dir" group. Nil matches the keys the s3
+	// backend writes, like DefaultStateKeyPattern.
+	StateKeyPattern *regexp.Regexp
+	// StatePrefix is the prefix StateLister lists state keys under. It is removed before the directory is taken from a
+	// key.
+	StatePrefix string
+	// WorkspaceKeyPrefix is the workspace_key_prefix of the s3 backend, which keys the state of workspaces other than
+	// default. Empty is DefaultWorkspaceKeyPrefix.
+	WorkspaceKeyPrefix string
+	// RunLockTTL, if set, makes each run hold a lock in ResultCache so runs of the same repository do not overlap.
+	// The lock lapses after this long if a run dies without releasing it, so it should exceed the longest run.
+	RunLockTTL time.Duration
+	// RunLockBehavior controls what a run does when the lock is held. Empty behaves like RunLockSkip.
+	RunLockBehavior RunLockBehavior
+	// OrderNotifications sends per-workspace notifications sorted by directory at the end of each phase, instead of
+	// in the order parallel checks finish
+	OrderNotifications bool
+	// LockedPlanBehavior controls how workspaces with locked plans are handled. Empty behaves like LockedPlanSkip.
+	LockedPlanBehavior LockedPlanBehavior
+	// MaxCliffnoteLines truncates drift cliffnotes to this many lines, keeping plan counts. Zero keeps everything.
+	MaxCliffnoteLines int
+	// PlanStore, if set, stores the full output of drifted plans so notifications can link to it
+	PlanStore planstore.Store
+	// FailOnNoProjects fails the run if the atlantis config has no projects, instead of finishing without checking
+	// anything
+	FailOnNoProjects bool
+	// DuplicateProjectPolicy controls what happens when several projects in the atlantis config share a name. Empty
+	// behaves like DuplicateProjectNotify.
+	DuplicateProjectPolicy DuplicateProjectPolicy
+	// FailOnRefMismatch fails the run if the checked out branch is not Ref, instead of warning
+	FailOnRefMismatch bool
+	// DriftGracePeriod delays PlanDrift notifications for workspaces that have never been seen clean until they have
+	// drifted continuously for this long. It relies on ResultCache to remember when drift was first seen.
+	DriftGracePeriod time.Duration
+	// NotifyOnlyChangedDrift skips PlanDrift notifications when the set of drifted resources is the same as the
+	// previous check found. It relies on ResultCache to remember the previous set.
+	NotifyOnlyChangedDrift bool
+	// ReNotifyInterval, if set, sends PlanDrift for drift that persists only once this long has passed since it was
+	// last notified, as a reminder. With NotifyOnlyChangedDrift, unchanged drift is also reminded of at this interval.
+	// It relies on ResultCache to remember when drift was last notified.
+	ReNotifyInterval time.Duration
+	// SkipCleanSummary skips WorkspaceDriftSummary when no workspace drifted, for teams that only want to hear about
+	// drift. By default the summary is sent on every run, so it doubles as a heartbeat.
+	SkipCleanSummary bool
+	// TemporaryErrorCacheDuration, if set, caches a check that failed with a temporary error as unknown for this long,
+	// so an Atlantis outage does not make every run plan every workspace again. Zero caches nothing, so the workspace
+	// is checked again on the next run.
+	TemporaryErrorCacheDuration time.Duration
+	// RunID identifies this run in cached results. Reruns of an interrupted run should reuse it.
+	RunID string
+	// ResumeFromCache skips workspaces already checked by a run with the same RunID, whatever CacheValidDuration is
+	ResumeFromCache bool
+	// CommentArgs are plan comment flags, like "-p project", sent with every plan request
+	CommentArgs []string
+	// PlanByProjectName plans named projects by their name alone, letting Atlantis resolve the directory and
+	// workspace. Unnamed projects, and names shared by several projects, are planned by directory and workspace. It
+	// turns off BatchPlanSummaries, which can only batch by directory.
+	PlanByProjectName bool
+	// CheckExtraWorkspacesForDrift also plans every extra workspace the workspace check finds, and handles it like a
+	// configured workspace, so unmanaged workspaces that drift are reported. Atlantis may refuse to plan a workspace it
+	// has no project for, which is reported as a plan error. Workspaces of backend configs are not planned.
+	CheckExtraWorkspacesForDrift bool
+	// MeterProvider, if set, receives OpenTelemetry metrics: counters of checked, drifted and failed workspaces and a
+	// histogram of plan request durations, labelled by repo and directory. Nil records nothing.
+	MeterProvider metric.MeterProvider
+	// MetricWorkspaceAttribute also labels the workspace counters by workspace. Every workspace becomes its own time
+	// series, so only set it for repositories with few workspaces.
+	MetricWorkspaceAttribute bool
+	// OverlayPath, if set, is a local Overlay file adjusting how projects are checked, like ignored workspaces and cache
+	// durations, without changing the atlantis config
+	OverlayPath string
+	// ProjectResolver, if set, picks the project each plan request names. Nil uses DefaultProjectResolver. Setting it
+	// turns off BatchPlanSummaries.
+	ProjectResolver ProjectResolver
+	// PostRunHook, if set, is called with the results after the drift summary is sent, to start automation outside of
+	// notifications. Its errors are logged and only fail the run if FailOnPostRunHookError is set.
+	PostRunHook func(ctx context.Context, report DriftReport) error
+	// FailOnPostRunHookError fails the run if PostRunHook returns an error
+	FailOnPostRunHookError bool
+	// ReportDriftedOnly leaves workspaces without drift or errors, and audits without extra or missing workspaces, out
+	// of the Report, so reports of large estates stay small. The counts still cover every workspace.
+	ReportDriftedOnly bool
+	// Phases selects the checks a run does. Empty runs every phase.
+	Phases []Phase
+	// PersistReport stores the report of every run in ResultCache, which must implement processedcache.ReportCache, so
+	// drift can be followed over time
+	PersistReport bool
+	// BatchPlanSummaries plans all the workspaces of a directory in one Atlantis request. If Atlantis rejects the
+	// batch, or does not say which workspace each result is for, the workspaces are planned one at a time. It is not
+	// used with CommentArgs, which apply to a single workspace.
+	BatchPlanSummaries bool
+	// WorkspaceAuditAllowlist, if set, limits the extra workspace check, which has to init every directory, to
+	// directories matching one of its entries. Drift checks are not affected. Entries match like DirectoryAllowlist.
+	WorkspaceAuditAllowlist []string
+	// WorkspaceAuditDenylist leaves directories matching any of its entries out of the extra workspace check
+	WorkspaceAuditDenylist []string
+	// PlanRepo is the repository, in owner/name form, atlantis tracks when it is not Repo, like a fork or mirror.
+	// Code is still checked out from Repo. Empty uses Repo.
+	PlanRepo string
+	// PendingApplies, if set, is asked for open pull requests changing each drifted directory. Drift with open pull
+	// requests is sent as PendingApply instead of PlanDrift.
+	PendingApplies PendingApplyFinder
+	// Clock is read for every time based decision, like cache expiry. Nil uses the system clock.
+	Clock Clock
+	// SecretPatterns are redacted from cliffnotes, plan errors and stored plans, on top of DefaultSecretPatterns. Text
+	// matched by a capture group is kept, so a pattern can keep a label and redact only the value.
+	SecretPatterns []*regexp.Regexp
+	// RedactTFVarValues also redacts the values of TF_VAR_ environment variables, ignoring values too short to be
+	// secrets
+	RedactTFVarValues bool
+	// Branch, if set, is checked out after cloning instead of the default branch, and is planned against if Ref is
+	// empty. It must exist on the remote.
+	Branch string
+	// Ref is the ref workspaces are planned against. If empty, the repository's default branch is detected.
+	Ref                     string
 	DriftedWorkspaceCount   int32
 	UndriftedWorkspaceCount int32
 	TotalWorkspacesCount    int32
+	CachedWorkspaceCount    int32
+	SuppressedDriftCount    int32
+	PlanErrorCount          int32
+	NeverPlannedCount       int32
+	ExtraWorkspaceCount     int32
+	MissingWorkspaceCount   int32
+	OrphanedStateCount      int32
+
+	mu                    sync.Mutex
+	oldestCachedCheck     time.Time
+	driftSuppressedReason string
+	results               []DriftResult
+	cachedResults         []DriftResult
+	driftedLocations      []notification.Location
+	notified              []notifiedStamp
+	capped                *notification.Capped
+	phaseTimings          []notification.PhaseTiming
+	redactorOnce          sync.Once
+	redactor              *redactor
+	atlantisConfig        *atlantis.SimpleAtlantisConfig
+	workspaceAudits       []WorkspaceAudit
+	overlay               map[string]OverlayProject
+	instrumentsOnce       sync.Once
+	otelInstruments       *otelInstruments
 }
 
+// Drift runs every check. RunStarted and RunFinished are sent around the run, even if it fails, so a missing pair
+// means the run never executed.
 func (d *Drifter) Drift(ctx context.Context) error {
-	d.Logger.Info("Checking out Terraform repository.")
-	repo, err := atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo, d.Logger)
+	if d.RunLockTTL > 0 {
+		release, err := d.acquireRunLock(ctx)
+		if err != nil {
+			return err
+		}
+		if release == nil {
+			d.Logger.Info("Previous run still in progress, exiting", zap.String("repo", d.Repo))
+			return nil
+		}
+		defer release()
+	}
+	start := d.now()
+	// The ref is resolved first so RunStarted names it. A failure is still reported by RunFinished, like any failed
+	// run.
+	runErr := d.resolveRef(ctx)
+	if err := d.Notification.RunStarted(ctx, d.Repo, d.Ref); err != nil {
+		return errors.Join(runErr, fmt.Errorf("failed to notify of run start: %w", err))
+	}
+	if runErr == nil {
+		runErr = d.drift(ctx)
+	}
+	summary := notification.RunSummary{
+		Repo:                d.Repo,
+		Ref:                 d.Ref,
+		Duration:            d.since(start),
+		WorkspacesDrifted:   atomic.LoadInt32(&d.DriftedWorkspaceCount),
+		WorkspacesUndrifted: atomic.LoadInt32(&d.UndriftedWorkspaceCount),
+		TotalWorkspaces:     atomic.LoadInt32(&d.TotalWorkspacesCount),
+		Phases:              d.phaseNames(),
+		Drifted:             d.drifted(),
+
+		DriftSuppressedReason: d.driftSuppressedReason,
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+	if d.PersistReport {
+		if err := d.persistReport(ctx); err != nil {
+			d.Logger.Error("Unable to persist run report", zap.Error(err))
+		}
+	}
+	if err := d.Notification.RunFinished(ctx, summary); err != nil {
+		return errors.Join(runErr, fmt.Errorf("failed to notify of run finish: %w", err))
+	}
+	return runErr
+}
+
+// noProjectsFound reports an atlantis config without projects, which makes the run a silent no-op. It fails the run
+// if FailOnNoProjects is set.
+func (d *Drifter) noProjectsFound(ctx context.Context, configPath string) error {
+	d.Logger.Warn("No projects found in repo config.")
+	if err := d.Notification.NoProjectsFound(ctx, d.Repo, configPath); err != nil {
+		return fmt.Errorf("failed to notify of no projects: %w", err)
+	}
+	if d.FailOnNoProjects {
+		return fmt.Errorf("no projects found in repo config %s", configPath)
+	}
+	return nil
+}
+
+// resolveRef sets Ref, if it is empty, to Branch or else the repository's default branch
+func (d *Drifter) resolveRef(ctx context.Context) error {
+	if d.Ref == "" && d.Branch != "" {
+		d.Ref = d.Branch
+	}
+	if d.Ref != "" {
+		return nil
+	}
+	ref, err := atlantisgithub.DefaultBranch(ctx, d.GithubClient, d.ResultCache, d.Repo, defaultBranchCacheDuration, d.now(), d.Logger)
 	if err != nil {
-		return fmt.Errorf("failed to checkout repo %s: %w", d.Repo, err)
+		return fmt.Errorf("failed to detect default branch: %w", err)
 	}
-	d.Terraform.Directory = repo.Location()
-	d.Logger.Info("Repo location:", zap.String("location", repo.Location()))
+	d.Ref = ref
+	d.Logger.Info("Detected default branch", zap.String("ref", d.Ref))
+	return nil
+}
 
+// startDriftSuppression decides whether the run suppresses drift notifications, and why
+func (d *Drifter) startDriftSuppression() {
+	if inMaintenanceWindow(d.MaintenanceWindows, d.now()) {
+		d.Logger.Info("Run is inside a maintenance window, drift notifications are suppressed.")
+		d.driftSuppressedReason = "maintenance window"
+	}
+	if d.BootstrapMode {
+		d.Logger.Info("Bootstrap mode, results are cached but drift notifications are suppressed.")
+		d.driftSuppressedReason = "bootstrap mode"
+	}
+}
+
+func (d *Drifter) drift(ctx context.Context) error {
+	d.startDriftSuppression()
+	runStart := d.now()
+	phaseStart := runStart
+	endPhase := func(phase string) {
+		now := d.now()
+		d.mu.Lock()
+		d.phaseTimings = append(d.phaseTimings, notification.PhaseTiming{Phase: phase, Duration: now.Sub(phaseStart)})
+		d.mu.Unlock()
+		phaseStart = now
+	}
+	d.Logger.Info("Checking out Terraform repository.")
+	repo, err := d.checkOut(ctx)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		if err := os.RemoveAll(repo.Location()); err != nil {
 			d.Logger.Warn("failed to cleanup repo", zap.Error(err))
 		}
 	}()
+	d.Terraform.Directory = repo.Location()
+	d.Logger.Info("Repo location:", zap.String("location", repo.Location()))
+
+	if err := d.verifyCheckedOutRef(ctx, repo); err != nil {
+		return err
+	}
+	endPhase("checkout")
+	if d.CheckGeneratedConfig {
+		d.Logger.Info("Checking committed config matches generated config.")
+		matches, diff, err := d.CheckGeneratedConfigMatches(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check generated config: %w", err)
+		}
+		if !matches {
+			return fmt.Errorf("committed atlantis config %s does not match generated config:\n%s", d.AtlantisRepoYmlPath, diff)
+		}
+	}
 	d.Logger.Info("Parsing repo config from directory.")
 	if d.AutoGenerateConfig {
 		d.Logger.Info("Auto generation of config option enabled.")
 		err := d.generateAtlantisProjectsFile()
 		if err != nil {
-			return err
+			return &ConfigParseError{Path: d.AtlantisRepoYmlPath, Err: err}
 		}
 	}
 
-	cfg, err := atlantis.ParseRepoConfigFromDir(d.AtlantisRepoYmlPath, repo.Location())
+	configPath, err := d.atlantisConfigPath()
 	if err != nil {
-		return fmt.Errorf("failed to parse repo config: %w", err)
+		return &ConfigParseError{Path: d.AtlantisRepoYmlPath, Err: err}
+	}
+	cfg, err := atlantis.ParseRepoConfigFromDir(configPath, repo.Location())
+	if err != nil {
+		return &ConfigParseError{Path: d.AtlantisRepoYmlPath, Err: err}
 	}
 	d.Logger.Info("Finished parsing repo config from directory.")
+	if d.ValidateConfigBeforePlan {
+		if problems := d.ValidateConfig(ctx, cfg); len(problems) > 0 {
+			return &ConfigParseError{Path: d.AtlantisRepoYmlPath, Err: errors.Join(problems...)}
+		}
+	}
 	if len(cfg.Projects) == 0 {
-		d.Logger.Warn("No projects found in repo config.")
+		if err := d.noProjectsFound(ctx, configPath); err != nil {
+			return err
+		}
+	}
+	if err := d.checkDuplicateProjects(ctx, cfg); err != nil {
+		return err
+	}
+	d.atlantisConfig = cfg
+	if err := d.applyOverlay(cfg); err != nil {
+		return err
 	}
+	if len(d.RoutedNotifications) > 0 && len(cfg.NotifyTargets) > 0 {
+		for dir, target := range cfg.NotifyTargets {
+			if _, exists := d.RoutedNotifications[target]; !exists {
+				d.Logger.Warn("Unknown drift-notify target", zap.String("dir", dir), zap.String("target", target))
+			}
+		}
+		original := d.Notification
+		d.Notification = &notification.Routing{
+			Notification: original,
+			Backends:     d.RoutedNotifications,
+			Routes:       cfg.NotifyTargets,
+		}
+		defer func() {
+			d.Notification = original
+		}()
+	}
+	if d.MaxNotificationsPerRun > 0 {
+		uncapped := d.Notification
+		d.capped = &notification.Capped{Notification: uncapped, Max: int32(d.MaxNotificationsPerRun)}
+		d.Notification = d.capped
+		defer func() {
+			d.Notification = uncapped
+		}()
+	}
+
+	endPhase("config")
 
 	d.Logger.Info("Parsing workspaces.")
 	workspaces := atlantis.ConfigToWorkspaces(cfg)
-	d.Logger.Info("Finished parsing workspaces. Checking for drift.")
-	if err := d.FindDriftedWorkspaces(ctx, workspaces); err != nil {
-		return fmt.Errorf("failed to find drifted workspaces: %w", err)
+	d.Logger.Info("Finished parsing workspaces.")
+	if d.runsPhase(PhaseDrift) {
+		branchCfg := cfg.ForBranch(d.Ref)
+		if skipped := len(cfg.Projects) - len(branchCfg.Projects); skipped > 0 {
+			d.Logger.Info("Skipping projects that do not track the plan ref", zap.String("ref", d.Ref), zap.Int("skipped", skipped))
+		}
+		d.Logger.Info("Checking for drift.")
+		toCheck, err := d.sampleWorkspaces(ctx, atlantis.ConfigToWorkspaces(branchCfg), d.now().Unix())
+		if err != nil {
+			return fmt.Errorf("failed to sample workspaces: %w", err)
+		}
+		if err := d.orderedPhase(ctx, func() error {
+			return d.FindDriftedWorkspaces(ctx, toCheck)
+		}); err != nil {
+			return fmt.Errorf("failed to find drifted workspaces: %w", err)
+		}
+		endPhase("drift")
+		d.Logger.Info("Total number of workspaces drifted", zap.Int32("drifted workspaces", d.DriftedWorkspaceCount))
+		d.Logger.Info("Total number of workspaces without drift", zap.Int32("drifted workspaces", d.UndriftedWorkspaceCount))
+		d.Logger.Info("Finished checking for drifted workspaces.")
+	}
+	if d.runsPhase(PhaseWorkspaces) {
+		d.Logger.Info("Checking for extra workspaces.")
+		if err := d.orderedPhase(ctx, func() error {
+			return d.FindExtraWorkspaces(ctx, workspaces)
+		}); err != nil {
+			return fmt.Errorf("failed to find extra workspaces: %w", err)
+		}
+		endPhase("workspaces")
+		if d.CheckUnmanagedDirectories {
+			d.Logger.Info("Checking for unmanaged directories.")
+			if err := d.FindUnmanagedDirectories(ctx, workspaces); err != nil {
+				return fmt.Errorf("failed to find unmanaged directories: %w", err)
+			}
+			endPhase("unmanaged")
+		}
+		if d.StateLister != nil {
+			d.Logger.Info("Checking for orphaned state.")
+			if err := d.FindOrphanedState(ctx, workspaces); err != nil {
+				return fmt.Errorf("failed to find orphaned state: %w", err)
+			}
+			endPhase("orphaned-state")
+		}
 	}
-	d.Logger.Info("Total number of workspaces drifted", zap.Int32("drifted workspaces", d.DriftedWorkspaceCount))
-	d.Logger.Info("Total number of workspaces without drift", zap.Int32("drifted workspaces", d.UndriftedWorkspaceCount))
-	d.Logger.Info("Finished checking for drifted workspaces. Checking for extra workspaces.")
-	if err := d.FindExtraWorkspaces(ctx, workspaces); err != nil {
-		return fmt.Errorf("failed to find extra workspaces: %w", err)
+	if err := d.notifyRunSummaries(ctx, runStart); err != nil {
+		return err
 	}
-	d.Notification.WorkspaceDriftSummary(ctx, d.DriftedWorkspaceCount, d.UndriftedWorkspaceCount, d.TotalWorkspacesCount)
 	d.Logger.Info("Finished checking for workspaces with extra drift.")
 	return nil
 }
 
+// notifyRunSummaries sends the summaries of the run, then runs PostRunHook. The hook runs last so a failing hook
+// cannot keep the summaries from being sent.
+func (d *Drifter) notifyRunSummaries(ctx context.Context, runStart time.Time) error {
+	if d.runsPhase(PhaseDrift) {
+		d.notifyDriftSummary(ctx)
+	}
+	if d.auditsWorkspaces() {
+		if err := d.Notification.WorkspaceAuditSummary(ctx, d.ExtraWorkspaceCount, d.MissingWorkspaceCount); err != nil {
+			return fmt.Errorf("failed to notify of workspace audit summary: %w", err)
+		}
+	}
+	if err := d.warnOnCachedResults(ctx); err != nil {
+		return fmt.Errorf("failed to notify of cached results: %w", err)
+	}
+	if err := d.notifySuppressedDrift(ctx); err != nil {
+		return err
+	}
+	if d.capped != nil && d.capped.Suppressed() > 0 {
+		reason := fmt.Sprintf("notification cap of %d reached, see the report for the rest", d.MaxNotificationsPerRun)
+		if err := d.Notification.DriftNotificationsSuppressed(ctx, reason, d.capped.Suppressed()); err != nil {
+			return fmt.Errorf("failed to notify of capped notifications: %w", err)
+		}
+	}
+	if err := d.Notification.RunTimings(ctx, d.since(runStart), d.PhaseTimings()); err != nil {
+		return fmt.Errorf("failed to notify of run timings: %w", err)
+	}
+	return d.runPostRunHook(ctx)
+}
+
+// PhaseTimings returns how long each finished phase of the run took, in order
+func (d *Drifter) PhaseTimings() []notification.PhaseTiming {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]notification.PhaseTiming(nil), d.phaseTimings...)
+}
+
+func (d *Drifter) recordCachedResult(dir string, workspace string, val *processedcache.DriftCheckValue) {
+	atomic.AddInt32(&d.CachedWorkspaceCount, 1)
+	d.addCachedResult(dir, workspace, "", val)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.oldestCachedCheck.IsZero() || val.When.Before(d.oldestCachedCheck) {
+		d.oldestCachedCheck = val.When
+	}
+}
+
+// addCachedResult keeps the cached result of a workspace this run skipped for the report
+func (d *Drifter) addCachedResult(dir string, workspace string, ref string, val *processedcache.DriftCheckValue) {
+	result := DriftResult{
+		Dir:       dir,
+		Workspace: workspace,
+		Ref:       ref,
+		Drift:     val.Drift,
+		Source:    val.Source,
+	}
+	if val.Error != "" {
+		result.Err = errors.New(val.Error)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cachedResults = append(d.cachedResults, result)
+}
+
+// notifyDriftSummary sends WorkspaceDriftSummary, unless nothing drifted and SkipCleanSummary is set
+func (d *Drifter) notifyDriftSummary(ctx context.Context) {
+	drifted := atomic.LoadInt32(&d.DriftedWorkspaceCount)
+	if drifted == 0 && d.SkipCleanSummary {
+		d.Logger.Info("No drift found, skipping drift summary")
+		return
+	}
+	d.Notification.WorkspaceDriftSummary(ctx, drifted, atomic.LoadInt32(&d.UndriftedWorkspaceCount), atomic.LoadInt32(&d.TotalWorkspacesCount))
+}
+
+// warnOnCachedResults sends CachedResultsWarning when more than CachedResultsWarningThreshold of the workspaces were
+// served from cache, which usually means CacheValidDuration is too long for the schedule and drift goes unchecked
+func (d *Drifter) warnOnCachedResults(ctx context.Context) error {
+	cached := atomic.LoadInt32(&d.CachedWorkspaceCount)
+	if d.CachedResultsWarningThreshold <= 0 || cached == 0 {
+		return nil
+	}
+	total := cached + atomic.LoadInt32(&d.TotalWorkspacesCount)
+	if float64(cached)/float64(total) <= d.CachedResultsWarningThreshold {
+		return nil
+	}
+	d.mu.Lock()
+	oldest := d.oldestCachedCheck
+	d.mu.Unlock()
+	d.Logger.Warn("Most workspaces were served from cache, check CacheValidDuration", zap.Int32("cached", cached), zap.Int32("total", total))
+	return d.Notification.CachedResultsWarning(ctx, cached, total, oldest)
+}
+
+// DriftedLocations returns every drifted workspace found so far, sorted by directory and workspace
+func (d *Drifter) DriftedLocations() []notification.Location {
+	d.mu.Lock()
+	ret := append([]notification.Location(nil), d.driftedLocations...)
+	d.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Directory != ret[j].Directory {
+			return ret[i].Directory < ret[j].Directory
+		}
+		return ret[i].Workspace < ret[j].Workspace
+	})
+	return ret
+}
+
+// WorkspaceAudits returns what the extra workspace check found for every directory it checked this run, sorted by
+// directory and backend config. Directories skipped because of a cached result are not included.
+func (d *Drifter) WorkspaceAudits() []WorkspaceAudit {
+	d.mu.Lock()
+	ret := append([]WorkspaceAudit(nil), d.workspaceAudits...)
+	d.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Dir != ret[j].Dir {
+			return ret[i].Dir < ret[j].Dir
+		}
+		return ret[i].BackendConfig < ret[j].BackendConfig
+	})
+	return ret
+}
+
+// orderedPhase runs phase, and if OrderNotifications is set buffers its per-directory notifications and sends them
+// in directory order once it is done
+func (d *Drifter) orderedPhase(ctx context.Context, phase func() error) error {
+	if !d.OrderNotifications {
+		return phase()
+	}
+	original := d.Notification
+	ordered := &notification.Ordered{Notification: original}
+	d.Notification = ordered
+	phaseErr := phase()
+	d.Notification = original
+	// Drift is only recorded as notified once its notification was sent
+	if err := ordered.Flush(ctx); err != nil {
+		return errors.Join(phaseErr, err)
+	}
+	return errors.Join(phaseErr, d.storeNotified(ctx))
+}
+
+type notifiedStamp struct {
+	key   *processedcache.ConsiderDriftChecked
+	value *processedcache.DriftCheckValue
+}
+
+// storeNotified caches the LastNotified of the drift notified since it was last called. Drift whose notification the
+// cap dropped keeps its previous LastNotified, so it is notified again by the next run.
+func (d *Drifter) storeNotified(ctx context.Context) error {
+	d.mu.Lock()
+	stamps := d.notified
+	d.notified = nil
+	d.mu.Unlock()
+	dropped := make(map[notification.Location]struct{})
+	if d.capped != nil {
+		for _, l := range d.capped.Dropped() {
+			dropped[l] = struct{}{}
+		}
+	}
+	for _, s := range stamps {
+		if _, exists := dropped[notification.Location{Directory: s.key.Dir, Workspace: s.key.Workspace}]; exists {
+			d.Logger.Info("Drift notification was dropped by the notification cap, not recording it as notified", zap.String("dir", s.key.Dir), zap.String("workspace", s.key.Workspace))
+			continue
+		}
+		if err := d.ResultCache.StoreDriftCheckResult(ctx, s.key, s.value); err != nil {
+			return fmt.Errorf("failed to store cache value for %s/%s: %w", s.key.Dir, s.key.Workspace, err)
+		}
+	}
+	return nil
+}
+
+func (d *Drifter) reportResult(ctx context.Context, result DriftResult) {
+	d.mu.Lock()
+	d.results = append(d.results, result)
+	d.mu.Unlock()
+	d.recordResultMetrics(ctx, result)
+	if d.OnResult != nil {
+		d.OnResult(ctx, result)
+	}
+}
+
+// drifted returns the workspaces found drifted at the planned ref, sorted by directory and workspace
+func (d *Drifter) drifted() []notification.DriftedWorkspace {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ret []notification.DriftedWorkspace
+	for _, r := range d.results {
+		if r.Drift && r.Ref == "" {
+			ret = append(ret, notification.DriftedWorkspace{Directory: r.Dir, Workspace: r.Workspace, Cliffnote: r.Cliffnote})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Directory != ret[j].Directory {
+			return ret[i].Directory < ret[j].Directory
+		}
+		return ret[i].Workspace < ret[j].Workspace
+	})
+	return ret
+}
+
+func (d *Drifter) planRepo() string {
+	if d.PlanRepo != "" {
+		return d.PlanRepo
+	}
+	return d.Repo
+}
+
+// notifySuppressedDrift tells how many drift notifications the run suppressed. Bootstrap mode only logs it: it is
+// meant to be quiet, and the suppressed drift is the baseline rather than something to follow up on.
+func (d *Drifter) notifySuppressedDrift(ctx context.Context) error {
+	if d.SuppressedDriftCount == 0 {
+		return nil
+	}
+	if d.BootstrapMode {
+		d.Logger.Info("Bootstrap mode suppressed drift notifications", zap.Int32("suppressed", d.SuppressedDriftCount))
+		return nil
+	}
+	if err := d.Notification.DriftNotificationsSuppressed(ctx, d.driftSuppressedReason, d.SuppressedDriftCount); err != nil {
+		return fmt.Errorf("failed to notify of suppressed drift: %w", err)
+	}
+	return nil
+}
+
+// suppressDrift returns true, counting the suppressed notification, if the run suppresses drift notifications, like
+// in a maintenance window or bootstrap mode
+func (d *Drifter) suppressDrift(dir string, workspace string, ref string) bool {
+	if d.driftSuppressedReason == "" {
+		return false
+	}
+	d.Logger.Info("Drift notification suppressed", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("ref", ref), zap.String("reason", d.driftSuppressedReason))
+	atomic.AddInt32(&d.SuppressedDriftCount, 1)
+	return true
+}
+
+func (d *Drifter) notifyPlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	if d.suppressDrift(dir, workspace, d.Ref) {
+		return nil
+	}
+	if prs := d.pendingApplies(ctx, dir); len(prs) > 0 {
+		d.Logger.Info("Drift has open pull requests, notifying as pending apply", zap.String("dir", dir), zap.String("workspace", workspace), zap.Strings("pull-requests", prs))
+		return d.Notification.PendingApply(ctx, dir, workspace, prs)
+	}
+	return d.Notification.PlanDrift(ctx, dir, workspace, cliffnote)
+}
+
+// checkOut clones the repository and checks out Branch, if set
+func (d *Drifter) checkOut(ctx context.Context) (*gogit.Repository, error) {
+	repo, err := atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo, d.Logger)
+	if err != nil {
+		return nil, &CheckoutError{Repo: d.Repo, Err: err}
+	}
+	if d.Branch == "" {
+		return repo, nil
+	}
+	d.Logger.Info("Checking out branch", zap.String("branch", d.Branch))
+	if err := atlantisgithub.CheckOutBranch(ctx, repo, d.Branch); err != nil {
+		if err := os.RemoveAll(repo.Location()); err != nil {
+			d.Logger.Warn("failed to cleanup repo", zap.Error(err))
+		}
+		return nil, &CheckoutError{Repo: d.Repo, Err: err}
+	}
+	return repo, nil
+}
+
+// verifyCheckedOutRef compares the checked out branch, which generated config and directory scans are based on, with
+// the ref Atlantis plans. A mismatch is an error if FailOnRefMismatch is set, otherwise a warning.
+func (d *Drifter) verifyCheckedOutRef(ctx context.Context, repo *gogit.Repository) error {
+	branch, err := repo.CurrentBranchName(ctx)
+	if err != nil {
+		d.Logger.Warn("Unable to verify checked out branch", zap.Error(err))
+		return nil
+	}
+	if branch == d.Ref {
+		return nil
+	}
+	if d.FailOnRefMismatch {
+		return &RefMismatchError{CheckedOut: branch, PlanRef: d.Ref}
+	}
+	d.Logger.Warn("Checked out branch does not match plan ref, drift results may be wrong", zap.String("checked-out", branch), zap.String("plan-ref", d.Ref))
+	return nil
+}
+
+// checkedThisRun returns true if ResumeFromCache is set and the cached check was done by this run, so an interrupted
+// run picks up where it left off.
+func (d *Drifter) checkedThisRun(val *processedcache.DriftCheckValue) bool {
+	return d.ResumeFromCache && d.RunID != "" && val.RunID == d.RunID
+}
+
+// inDriftGracePeriod returns true if a workspace that has never been seen clean started drifting less than
+// DriftGracePeriod ago.
+func (d *Drifter) inDriftGracePeriod(val *processedcache.DriftCheckValue) bool {
+	if d.DriftGracePeriod <= 0 || val.EverClean {
+		return false
+	}
+	return d.since(val.FirstDriftSeen) < d.DriftGracePeriod
+}
+
+// notifiedRecently is true if ReNotifyInterval is set and the ongoing drift cur was last notified less than
+// ReNotifyInterval ago. Drift whose resources changed since prev is never considered notified.
+func (d *Drifter) notifiedRecently(prev *processedcache.DriftCheckValue, cur *processedcache.DriftCheckValue) bool {
+	if d.ReNotifyInterval <= 0 || cur.LastNotified.IsZero() {
+		return false
+	}
+	if prev != nil && len(prev.DriftedResources) > 0 && !slices.Equal(prev.DriftedResources, cur.DriftedResources) {
+		return false
+	}
+	return d.since(cur.LastNotified) < d.ReNotifyInterval
+}
+
+// sameDriftedResources is true if prev recorded the same, non-empty, set of drifted resources as cur. Drift that
+// could not be broken down into resources, like output only changes, is never considered the same.
+func sameDriftedResources(prev *processedcache.DriftCheckValue, cur *processedcache.DriftCheckValue) bool {
+	if prev == nil || len(prev.DriftedResources) == 0 {
+		return false
+	}
+	return slices.Equal(prev.DriftedResources, cur.DriftedResources)
+}
+
 func (d *Drifter) shouldSkipDirectory(dir string) bool {
 	if len(d.DirectoryAllowlist) == 0 {
 		return false
 	}
-	for _, allowedDirectoryPattern := range d.DirectoryAllowlist {
-		if strings.Contains(dir, allowedDirectoryPattern) {
-			return false
+	return !d.matchesAny(dir, d.DirectoryAllowlist)
+}
+
+// shouldSkipWorkspaceAudit is true for directories the extra workspace check leaves out, on top of the ones
+// shouldSkipDirectory leaves out of every phase
+func (d *Drifter) shouldSkipWorkspaceAudit(dir string) bool {
+	if len(d.WorkspaceAuditAllowlist) > 0 && !d.matchesAny(dir, d.WorkspaceAuditAllowlist) {
+		return true
+	}
+	return d.matchesAny(dir, d.WorkspaceAuditDenylist)
+}
+
+// matchesAny is true if dir matches any of patterns with AllowlistMatchMode
+func (d *Drifter) matchesAny(dir string, patterns []string) bool {
+	for _, pattern := range patterns {
+		matches, err := d.AllowlistMatchMode.matches(dir, pattern)
+		if err != nil {
+			d.Logger.Warn("Invalid allowlist pattern", zap.String("pattern", pattern), zap.Error(err))
+			continue
+		}
+		if matches {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 type errFunc func(ctx context.Context) error
@@ -159,75 +920,369 @@ func (d *Drifter) FindDriftedWorkspaces(ctx context.Context, ws atlantis.Directo
 			}
 			workspaces := ws[dir]
 			d.Logger.Info("Checking for drifted workspaces", zap.String("dir", dir))
-			for _, workspace := range workspaces {
-				cacheKey := &processedcache.ConsiderDriftChecked{
-					Dir:       dir,
-					Workspace: workspace,
+			batched := d.BatchPlanSummaries && len(workspaces) > 1 && len(d.CommentArgs) == 0 && !d.PlanByProjectName && d.ProjectResolver == nil
+			if batched {
+				if err := d.checkWorkspacesDrift(ctx, dir, workspaces); err != nil {
+					return err
 				}
-				cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
-				if err != nil {
-					return fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
-				}
-				if cacheVal != nil {
-					if time.Since(cacheVal.When) < d.CacheValidDuration {
-						d.Logger.Info("Skipping workspace, already checked", zap.String("dir", dir), zap.String("workspace", workspace))
-						continue
-					}
-					d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.String("workspace", workspace), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-					if err := d.ResultCache.DeleteDriftCheckResult(ctx, cacheKey); err != nil {
-						return fmt.Errorf("failed to delete cache value for %s/%s: %w", dir, workspace, err)
-					}
-				}
-
-				pr, err := d.AtlantisClient.PlanSummary(ctx, &atlantis.PlanSummaryRequest{
-					Repo:      d.Repo,
-					Ref:       "master",
-					Type:      "Github",
-					Dir:       dir,
-					Workspace: workspace,
-				})
-				if err != nil {
-					var tmp atlantis.TemporaryError
-					if errors.As(err, &tmp) && tmp.Temporary() {
-						d.Logger.Warn("Temporary error.  Will try again later.", zap.Error(err))
-						continue
+			}
+			for _, workspace := range workspaces {
+				if !batched {
+					if err := d.checkWorkspaceDrift(ctx, dir, workspace); err != nil {
+						return err
 					}
-					return fmt.Errorf("failed to get plan summary for (%s#%s): %w", dir, workspace, err)
-				}
-				atomic.AddInt32(&d.TotalWorkspacesCount, 1)
-				if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &processedcache.DriftCheckValue{
-					When:  time.Now(),
-					Error: "",
-					Drift: pr.HasChanges(),
-				}); err != nil {
-					return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
 				}
-				if pr.IsLocked() {
-					d.Logger.Info("Plan is locked, skipping drift check", zap.String("dir", dir))
-					continue
-				}
-				if pr.HasChanges() {
-					atomic.AddInt32(&d.DriftedWorkspaceCount, 1)
-					cliffnote := pr.GetPlanResultSummary()
-					if err := d.Notification.PlanDrift(ctx, dir, workspace, cliffnote); err != nil {
-						return fmt.Errorf("failed to notify of plan drift in %s: %w", dir, err)
+				for _, ref := range d.CompareRefs {
+					if err := d.checkWorkspaceDriftAtRef(ctx, dir, workspace, ref); err != nil {
+						return err
 					}
-				} else {
-					atomic.AddInt32(&d.UndriftedWorkspaceCount, 1)
 				}
 			}
 			return nil
 		}
 	}
+	dirs := ws.SortedKeys()
+	if d.PrioritizeStale {
+		var err error
+		if dirs, err = d.staleFirst(ctx, ws); err != nil {
+			return err
+		}
+	}
 	runs := make([]errFunc, 0)
-	for _, dir := range ws.SortedKeys() {
+	for _, dir := range dirs {
 		runs = append(runs, runningFunc(dir))
 	}
-	return d.drainAndExecute(ctx, runs)
+	err := d.drainAndExecute(ctx, runs)
+	if d.OrderNotifications {
+		// The notifications are only sent when the phase is flushed, which stores what was notified
+		return err
+	}
+	return errors.Join(err, d.storeNotified(ctx))
+}
+
+// staleFirst returns the directories of ws ordered by the oldest cached result of their workspaces, directories with
+// an uncached workspace first. Ties keep directory order.
+func (d *Drifter) staleFirst(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) ([]string, error) {
+	dirs := ws.SortedKeys()
+	oldest := make(map[string]time.Time, len(dirs))
+	for _, dir := range dirs {
+		if d.shouldSkipDirectory(dir) {
+			continue
+		}
+		for _, workspace := range ws[dir] {
+			cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{Repo: d.Repo, Dir: dir, Workspace: workspace})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
+			}
+			if cacheVal == nil {
+				oldest[dir] = time.Time{}
+				break
+			}
+			if when, ok := oldest[dir]; !ok || cacheVal.When.Before(when) {
+				oldest[dir] = cacheVal.When
+			}
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return oldest[dirs[i]].Before(oldest[dirs[j]])
+	})
+	return dirs, nil
+}
+
+func (d *Drifter) checkWorkspaceDrift(ctx context.Context, dir string, workspace string) error {
+	cacheVal, needsCheck, err := d.needsDriftCheck(ctx, dir, workspace)
+	if err != nil || !needsCheck {
+		return err
+	}
+	pr, err := d.planSummary(ctx, dir, workspace)
+	return d.handlePlanSummary(ctx, dir, workspace, cacheVal, pr, err)
+}
+
+// checkWorkspacesDrift checks several workspaces of a directory, planning the ones that need a check in one batched
+// request
+func (d *Drifter) checkWorkspacesDrift(ctx context.Context, dir string, workspaces []string) error {
+	cacheVals := make(map[string]*processedcache.DriftCheckValue, len(workspaces))
+	toCheck := make([]string, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		cacheVal, needsCheck, err := d.needsDriftCheck(ctx, dir, workspace)
+		if err != nil {
+			return err
+		}
+		if needsCheck {
+			cacheVals[workspace] = cacheVal
+			toCheck = append(toCheck, workspace)
+		}
+	}
+	var results map[string]*atlantis.PlanResult
+	if len(toCheck) > 1 {
+		var err error
+		start := time.Now()
+		results, err = d.AtlantisClient.BatchPlanSummary(ctx, &atlantis.BatchPlanSummaryRequest{
+			Repo:       d.planRepo(),
+			Ref:        d.Ref,
+			Type:       "Github",
+			Dir:        dir,
+			Workspaces: toCheck,
+		})
+		d.recordPlanLatency(ctx, dir, start)
+		if err != nil {
+			d.Logger.Warn("Batched plan summary failed, planning workspaces one at a time", zap.String("dir", dir), zap.Error(err))
+			results = nil
+		}
+	}
+	for _, workspace := range toCheck {
+		pr, batched := results[workspace]
+		var err error
+		if !batched {
+			pr, err = d.planSummary(ctx, dir, workspace)
+		}
+		if err := d.handlePlanSummary(ctx, dir, workspace, cacheVals[workspace], pr, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsDriftCheck returns the cached result of a workspace and whether it is stale enough to be checked again.
+// Stale results are deleted from the cache.
+func (d *Drifter) needsDriftCheck(ctx context.Context, dir string, workspace string) (*processedcache.DriftCheckValue, bool, error) {
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Repo:      d.Repo,
+		Dir:       dir,
+		Workspace: workspace,
+	}
+	cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
+	}
+	if cacheVal != nil {
+		if d.checkedThisRun(cacheVal) {
+			d.Logger.Info("Skipping workspace, already checked this run", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("cache-source", string(cacheVal.Source)))
+			d.recordCachedResult(dir, workspace, cacheVal)
+			return cacheVal, false, nil
+		}
+		validFor := d.cacheValidDuration(dir)
+		if cacheVal.Unknown {
+			validFor = d.TemporaryErrorCacheDuration
+		}
+		if d.since(cacheVal.When) < validFor {
+			d.Logger.Info("Skipping workspace, already checked", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("cache-source", string(cacheVal.Source)), zap.Bool("unknown", cacheVal.Unknown))
+			d.recordCachedResult(dir, workspace, cacheVal)
+			return cacheVal, false, nil
+		}
+		d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("cache-source", string(cacheVal.Source)), zap.Duration("cache-age", d.since(cacheVal.When)), zap.Duration("cache-valid-duration", validFor))
+		if err := d.ResultCache.DeleteDriftCheckResult(ctx, cacheKey); err != nil {
+			return nil, false, fmt.Errorf("failed to delete cache value for %s/%s: %w", dir, workspace, err)
+		}
+	}
+	return cacheVal, true, nil
+}
+
+func (d *Drifter) planSummary(ctx context.Context, dir string, workspace string) (*atlantis.PlanResult, error) {
+	defer d.recordPlanLatency(ctx, dir, time.Now())
+	return d.AtlantisClient.PlanSummary(ctx, d.planSummaryRequest(dir, workspace, d.Ref))
+}
+
+// projectName returns the name to plan dir and workspace by, if PlanByProjectName is set and the project has a name
+// only it uses
+func (d *Drifter) projectName(dir string, workspace string) string {
+	if !d.PlanByProjectName || d.atlantisConfig == nil {
+		return ""
+	}
+	return d.atlantisConfig.ProjectName(dir, workspace)
+}
+
+// handlePlanSummary records, caches and notifies the result of planning a workspace. cacheVal is its previous cached
+// result, if any.
+func (d *Drifter) handlePlanSummary(ctx context.Context, dir string, workspace string, cacheVal *processedcache.DriftCheckValue, pr *atlantis.PlanResult, err error) error {
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Repo:      d.Repo,
+		Dir:       dir,
+		Workspace: workspace,
+	}
+	result := DriftResult{
+		Dir:       dir,
+		Workspace: workspace,
+		Source:    processedcache.SourceFresh,
+	}
+	if err != nil {
+		result.Err = err
+		d.reportResult(ctx, result)
+		var tmp atlantis.TemporaryError
+		if errors.As(err, &tmp) && tmp.Temporary() {
+			d.Logger.Warn("Temporary error.  Will try again later.", zap.Error(err))
+			if d.TemporaryErrorCacheDuration > 0 {
+				unknownVal := processedcache.UnknownDriftCheckValue(cacheVal, d.now())
+				unknownVal.RunID = d.RunID
+				if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, unknownVal); err != nil {
+					return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
+				}
+			}
+			return nil
+		}
+		return &PlanError{Dir: dir, Workspace: workspace, Err: err}
+	}
+	atomic.AddInt32(&d.TotalWorkspacesCount, 1)
+	if pr.HasErrors() {
+		planErrors := d.redact(pr.GetPlanErrors())
+		d.Logger.Warn("Plan has errors", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("errors", planErrors))
+		atomic.AddInt32(&d.PlanErrorCount, 1)
+		result.Err = fmt.Errorf("plan for (%s#%s) has errors: %s", dir, workspace, planErrors)
+		d.reportResult(ctx, result)
+		errorVal := &processedcache.DriftCheckValue{
+			When:  d.now(),
+			Error: planErrors,
+			RunID: d.RunID,
+		}
+		if cacheVal != nil {
+			errorVal.FirstDriftSeen = cacheVal.FirstDriftSeen
+			errorVal.EverClean = cacheVal.EverClean
+			errorVal.DriftedResources = cacheVal.DriftedResources
+			errorVal.LastNotified = cacheVal.LastNotified
+		}
+		if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, errorVal); err != nil {
+			return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
+		}
+		if err := d.Notification.PlanError(ctx, dir, workspace, planErrors); err != nil {
+			return fmt.Errorf("failed to notify of plan error in %s: %w", dir, err)
+		}
+		return nil
+	}
+	if pr.IsNeverPlanned() {
+		// Not cached, so the workspace is checked again every run until it is planned
+		d.Logger.Warn("Workspace was never planned", zap.String("dir", dir), zap.String("workspace", workspace))
+		atomic.AddInt32(&d.NeverPlannedCount, 1)
+		result.NeverPlanned = true
+		d.reportResult(ctx, result)
+		if err := d.Notification.NeverPlanned(ctx, dir, workspace); err != nil {
+			return fmt.Errorf("failed to notify of never planned workspace in %s: %w", dir, err)
+		}
+		return nil
+	}
+	newVal := processedcache.NextDriftCheckValue(cacheVal, d.hasDrift(pr), d.now())
+	newVal.RunID = d.RunID
+	if newVal.Drift {
+		newVal.DriftedResources = pr.ChangedResources(d.IgnoreResourceTypes)
+	}
+	if !pr.IsLocked() || d.LockedPlanBehavior != LockedPlanRecheck {
+		if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, newVal); err != nil {
+			return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
+		}
+	}
+	if pr.IsLocked() {
+		d.Logger.Info("Plan is locked, skipping drift check", zap.String("dir", dir))
+		result.Locked = true
+		d.reportResult(ctx, result)
+		if d.LockedPlanBehavior == LockedPlanNotify {
+			if err := d.Notification.PlanLocked(ctx, dir, workspace); err != nil {
+				return fmt.Errorf("failed to notify of locked plan in %s: %w", dir, err)
+			}
+		}
+		return nil
+	}
+	if d.hasDrift(pr) {
+		atomic.AddInt32(&d.DriftedWorkspaceCount, 1)
+		d.mu.Lock()
+		d.driftedLocations = append(d.driftedLocations, notification.Location{Directory: dir, Workspace: workspace})
+		d.mu.Unlock()
+		result.Drift = true
+		result.Cliffnote = d.buildCliffnote(ctx, dir, workspace, pr)
+		d.reportResult(ctx, result)
+		if d.inDriftGracePeriod(newVal) {
+			d.Logger.Info("Workspace is new and within the drift grace period, not notifying", zap.String("dir", dir), zap.String("workspace", workspace), zap.Time("first-drift-seen", newVal.FirstDriftSeen))
+			return nil
+		}
+		if d.NotifyOnlyChangedDrift && d.ReNotifyInterval <= 0 && sameDriftedResources(cacheVal, newVal) {
+			d.Logger.Info("Drifted resources unchanged since the last check, not notifying", zap.String("dir", dir), zap.String("workspace", workspace), zap.Strings("resources", newVal.DriftedResources))
+			return nil
+		}
+		if d.notifiedRecently(cacheVal, newVal) {
+			d.Logger.Info("Drift was notified recently, not notifying", zap.String("dir", dir), zap.String("workspace", workspace), zap.Time("last-notified", newVal.LastNotified))
+			return nil
+		}
+		if err := d.notifyPlanDrift(ctx, dir, workspace, result.Cliffnote); err != nil {
+			return fmt.Errorf("failed to notify of plan drift in %s: %w", dir, err)
+		}
+		// Bootstrap mode records the drift it suppressed as notified, so later runs treat it as the baseline
+		if d.BootstrapMode || (d.ReNotifyInterval > 0 && d.driftSuppressedReason == "") {
+			newVal.LastNotified = d.now()
+			d.mu.Lock()
+			d.notified = append(d.notified, notifiedStamp{key: cacheKey, value: newVal})
+			d.mu.Unlock()
+		}
+	} else {
+		atomic.AddInt32(&d.UndriftedWorkspaceCount, 1)
+		d.reportResult(ctx, result)
+		// Errors and temporary errors keep FirstDriftSeen, so drift that was hidden by them still resolves. Without a
+		// cached result we can't tell if the workspace drifted before, so it resolves too.
+		if cacheVal == nil || !cacheVal.FirstDriftSeen.IsZero() {
+			if err := d.Notification.DriftResolved(ctx, dir, workspace); err != nil {
+				return fmt.Errorf("failed to notify of resolved drift in %s: %w", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkWorkspaceDriftAtRef plans a workspace against one of the comparison refs. Results only go to OnResult and
+// RefPlanDrift: they are not part of the run's drift counts.
+func (d *Drifter) checkWorkspaceDriftAtRef(ctx context.Context, dir string, workspace string, ref string) error {
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Repo:      d.Repo,
+		Dir:       dir,
+		Workspace: workspace,
+		Ref:       ref,
+	}
+	cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
+	if err != nil {
+		return fmt.Errorf("failed to get cache value for %s/%s@%s: %w", dir, workspace, ref, err)
+	}
+	if cacheVal != nil && (d.checkedThisRun(cacheVal) || d.since(cacheVal.When) < d.cacheValidDuration(dir)) {
+		d.Logger.Info("Skipping workspace at ref, already checked", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("ref", ref), zap.String("cache-source", string(cacheVal.Source)))
+		d.addCachedResult(dir, workspace, ref, cacheVal)
+		return nil
+	}
+	result := DriftResult{
+		Dir:       dir,
+		Workspace: workspace,
+		Ref:       ref,
+		Source:    processedcache.SourceFresh,
+	}
+	start := time.Now()
+	pr, err := d.AtlantisClient.PlanSummary(ctx, d.planSummaryRequest(dir, workspace, ref))
+	d.recordPlanLatency(ctx, dir, start)
+	if err == nil && pr.HasErrors() {
+		err = fmt.Errorf("plan has errors: %s", d.redact(pr.GetPlanErrors()))
+	}
+	if err != nil {
+		d.Logger.Warn("Unable to plan against ref", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("ref", ref), zap.Error(err))
+		result.Err = err
+		d.reportResult(ctx, result)
+		return nil
+	}
+	if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &processedcache.DriftCheckValue{
+		When:  d.now(),
+		Drift: d.hasDrift(pr),
+		RunID: d.RunID,
+	}); err != nil {
+		return fmt.Errorf("failed to store cache value for %s/%s@%s: %w", dir, workspace, ref, err)
+	}
+	result.Locked = pr.IsLocked()
+	if !result.Locked && d.hasDrift(pr) {
+		result.Drift = true
+		result.Cliffnote = truncateCliffnote(d.redact(pr.GetPlanResultSummary()), d.MaxCliffnoteLines)
+	}
+	d.reportResult(ctx, result)
+	if result.Drift && !d.suppressDrift(dir, workspace, ref) {
+		if err := d.Notification.RefPlanDrift(ctx, ref, dir, workspace, result.Cliffnote); err != nil {
+			return fmt.Errorf("failed to notify of plan drift in %s at %s: %w", dir, ref, err)
+		}
+	}
+	return nil
 }
 
 func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
-	if d.SkipWorkspaceCheck {
+	if !d.auditsWorkspaces() {
 		return nil
 	}
 	runFunc := func(dir string) errFunc {
@@ -236,48 +1291,27 @@ func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.Directori
 				d.Logger.Info("Skipping directory", zap.String("dir", dir))
 				return nil
 			}
-			cacheKey := &processedcache.ConsiderWorkspacesChecked{
-				Dir: dir,
-			}
-			cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
-			if err != nil {
-				return fmt.Errorf("failed to get cache value for %s: %w", dir, err)
+			if d.shouldSkipWorkspaceAudit(dir) {
+				d.Logger.Info("Skipping workspace audit of directory", zap.String("dir", dir))
+				return nil
 			}
-			if cacheVal != nil {
-				if time.Since(cacheVal.When) < d.CacheValidDuration {
-					d.Logger.Info("Skipping directory, in cache", zap.String("dir", dir))
-					return nil
-				}
-				d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-				if err := d.ResultCache.DeleteRemoteWorkspaces(ctx, cacheKey); err != nil {
-					return fmt.Errorf("failed to delete cache value for %s: %w", dir, err)
+			var backendConfigs []string
+			if lister, ok := d.workspaceLister().(backendConfigLister); ok {
+				var err error
+				backendConfigs, err = lister.BackendConfigs(dir)
+				if err != nil {
+					return &WorkspaceListError{Dir: dir, Err: err}
 				}
 			}
-			workspaces := ws[dir]
-			d.Logger.Info("Checking for extra workspaces", zap.String("dir", dir))
-			if err := d.Terraform.Init(ctx, dir); err != nil {
-				return fmt.Errorf("failed to init workspace %s: %w", dir, err)
-			}
-			var expectedWorkspaces []string
-			expectedWorkspaces = append(expectedWorkspaces, workspaces...)
-			expectedWorkspaces = append(expectedWorkspaces, "default")
-			remoteWorkspaces, err := d.Terraform.ListWorkspaces(ctx, dir)
-			if err != nil {
-				return fmt.Errorf("failed to list workspaces in %s: %w", dir, err)
+			if len(backendConfigs) == 0 {
+				return d.findExtraWorkspacesInBackend(ctx, dir, "", ws[dir])
 			}
-			for _, w := range remoteWorkspaces {
-				if !contains(expectedWorkspaces, w) {
-					if err := d.Notification.ExtraWorkspaceInRemote(ctx, dir, w); err != nil {
-						return fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, dir, err)
-					}
+			// Each backend config is a separate state, so it is checked like a separate directory
+			for _, backendConfig := range backendConfigs {
+				if err := d.findExtraWorkspacesInBackend(ctx, dir, backendConfig, ws[dir]); err != nil {
+					return err
 				}
 			}
-			if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
-				Workspaces: remoteWorkspaces,
-				When:       time.Now(),
-			}); err != nil {
-				return fmt.Errorf("failed to store cache value for %s: %w", dir, err)
-			}
 			return nil
 		}
 	}
@@ -288,6 +1322,174 @@ func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.Directori
 	return d.drainAndExecute(ctx, runs)
 }
 
+// findExtraWorkspacesInBackend compares the workspaces of dir, initialized with backendConfig if it is set, to the
+// workspaces atlantis expects
+func (d *Drifter) findExtraWorkspacesInBackend(ctx context.Context, dir string, backendConfig string, workspaces []string) error {
+	// Notifications name the backend config along with the directory, so each state reads as its own module
+	module := dir
+	if backendConfig != "" {
+		module = fmt.Sprintf("%s[%s]", dir, backendConfig)
+	}
+	cacheKey := &processedcache.ConsiderWorkspacesChecked{
+		Repo:          d.Repo,
+		Dir:           dir,
+		BackendConfig: backendConfig,
+	}
+	cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
+	if err != nil {
+		return fmt.Errorf("failed to get cache value for %s: %w", module, err)
+	}
+	if cacheVal != nil {
+		if d.since(cacheVal.When) < d.cacheValidDuration(dir) {
+			d.Logger.Info("Skipping directory, in cache", zap.String("dir", module))
+			return nil
+		}
+		d.Logger.Info("Cache expired, checking again", zap.String("dir", module), zap.Duration("cache-age", d.since(cacheVal.When)), zap.Duration("cache-valid-duration", d.cacheValidDuration(dir)))
+		if err := d.ResultCache.DeleteRemoteWorkspaces(ctx, cacheKey); err != nil {
+			return fmt.Errorf("failed to delete cache value for %s: %w", module, err)
+		}
+	}
+	d.Logger.Info("Checking for extra workspaces", zap.String("dir", module))
+	lister := d.workspaceLister()
+	if backendConfig != "" {
+		// backendConfig is only set by listers that implement backendConfigLister
+		err = lister.(backendConfigLister).InitBackendConfig(ctx, dir, backendConfig)
+	} else {
+		err = lister.Init(ctx, dir)
+	}
+	if err != nil {
+		return &WorkspaceListError{Dir: module, Err: fmt.Errorf("failed to init: %w", err)}
+	}
+	if checker, ok := lister.(remoteBackendChecker); ok {
+		hasBackend, err := checker.HasRemoteBackend(dir)
+		if err != nil {
+			return &WorkspaceListError{Dir: module, Err: err}
+		}
+		if !hasBackend {
+			d.Logger.Info("Skipping extra workspace check, no remote backend", zap.String("dir", module))
+			return nil
+		}
+	}
+	var expectedWorkspaces []string
+	expectedWorkspaces = append(expectedWorkspaces, workspaces...)
+	expectedWorkspaces = append(expectedWorkspaces, "default")
+	expectedWorkspaces = append(expectedWorkspaces, d.overlay[dir].IgnoredWorkspaces...)
+	remoteWorkspaces, err := lister.ListWorkspaces(ctx, dir)
+	if err != nil {
+		if len(remoteWorkspaces) > 0 {
+			d.Logger.Warn("Workspace listing failed part way", zap.String("dir", module), zap.Strings("workspaces-seen", remoteWorkspaces), zap.Error(err))
+		}
+		return &WorkspaceListError{Dir: module, Err: err}
+	}
+	audit := WorkspaceAudit{Dir: dir, BackendConfig: backendConfig}
+	for _, w := range remoteWorkspaces {
+		if !contains(expectedWorkspaces, w) {
+			audit.Extra = append(audit.Extra, w)
+		}
+	}
+	for _, w := range workspaces {
+		if w != "" && w != "default" && !contains(remoteWorkspaces, w) {
+			audit.Missing = append(audit.Missing, w)
+		}
+	}
+	d.mu.Lock()
+	d.workspaceAudits = append(d.workspaceAudits, audit)
+	d.mu.Unlock()
+	for _, w := range audit.Extra {
+		atomic.AddInt32(&d.ExtraWorkspaceCount, 1)
+		if err := d.Notification.ExtraWorkspaceInRemote(ctx, module, w); err != nil {
+			return fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, module, err)
+		}
+	}
+	if d.CheckExtraWorkspacesForDrift && backendConfig == "" {
+		for _, w := range audit.Extra {
+			d.Logger.Info("Checking extra workspace for drift", zap.String("dir", dir), zap.String("workspace", w))
+			if err := d.checkWorkspaceDrift(ctx, dir, w); err != nil {
+				return err
+			}
+		}
+	}
+	for _, w := range audit.Missing {
+		atomic.AddInt32(&d.MissingWorkspaceCount, 1)
+		if err := d.Notification.MissingWorkspaceInRemote(ctx, module, w); err != nil {
+			return fmt.Errorf("failed to notify of missing workspace %s in %s: %w", w, module, err)
+		}
+	}
+	if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
+		Workspaces: remoteWorkspaces,
+		When:       d.now(),
+	}); err != nil {
+		return fmt.Errorf("failed to store cache value for %s: %w", module, err)
+	}
+	return nil
+}
+
+// FindUnmanagedDirectories notifies for every terraform root module in the repository that has no atlantis project
+func (d *Drifter) FindUnmanagedDirectories(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
+	directories, err := d.findRootModules(d.Terraform.Directory, backendPattern)
+	if err != nil {
+		return fmt.Errorf("error finding root modules: %w", err)
+	}
+	unmanaged := make([]string, 0)
+	for dir := range directories {
+		relativeDir := d.relativeDir(dir)
+		if _, exists := ws[relativeDir]; !exists {
+			unmanaged = append(unmanaged, relativeDir)
+		}
+	}
+	sort.Strings(unmanaged)
+	for _, dir := range unmanaged {
+		if d.shouldSkipDirectory(dir) {
+			continue
+		}
+		if err := d.Notification.UnmanagedDirectory(ctx, dir); err != nil {
+			return fmt.Errorf("failed to notify of unmanaged directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// ValidateConfig checks that every project directory in cfg exists and is a terraform root module, returning every
+// problem found
+func (d *Drifter) ValidateConfig(_ context.Context, cfg *atlantis.SimpleAtlantisConfig) []error {
+	var problems []error
+	checked := map[string]struct{}{}
+	for _, p := range cfg.Projects {
+		if _, exists := checked[atlantis.NormalizeDir(p.Dir)]; exists {
+			continue
+		}
+		checked[atlantis.NormalizeDir(p.Dir)] = struct{}{}
+		dir := filepath.Join(d.Terraform.Directory, p.Dir)
+		info, err := os.Stat(dir)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("project dir %s does not exist: %w", p.Dir, err))
+			continue
+		}
+		if !info.IsDir() {
+			problems = append(problems, fmt.Errorf("project dir %s is not a directory", p.Dir))
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("unable to list tf files in project dir %s: %w", p.Dir, err))
+			continue
+		}
+		if len(files) == 0 {
+			problems = append(problems, fmt.Errorf("project dir %s has no .tf files", p.Dir))
+			continue
+		}
+		rootModules, err := d.findTerraformRootModules(files, backendPattern)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("unable to read tf files in project dir %s: %w", p.Dir, err))
+			continue
+		}
+		if len(rootModules) == 0 {
+			problems = append(problems, fmt.Errorf("project dir %s has no backend, so is not a root module", p.Dir))
+		}
+	}
+	return problems
+}
+
 func contains(workspaces []string, w string) bool {
 	for _, workspace := range workspaces {
 		if workspace == w {
@@ -297,63 +1499,231 @@ func contains(workspaces []string, w string) bool {
 	return false
 }
 
-func (d *Drifter) generateAtlantisProjectsFile() error {
-	files, err := findTFFiles(d.Terraform.Directory)
+func (d *Drifter) generateAtlantisConfig() ([]byte, error) {
+	directories, err := d.findRootModules(d.Terraform.Directory, backendPattern)
 	if err != nil {
-		return fmt.Errorf("error finding tf files: %v", err)
+		return nil, fmt.Errorf("error finding root modules: %v", err)
 	}
 
-	// Look for s3/gcs/azurerm storage backends
-	backendPattern := regexp.MustCompile(`backend[\s]+"(s3)|(gcs)|(azurerm)"`)
-	directories, err := d.findTerraformRootModules(files, backendPattern)
+	yamlOutputBytes, err := d.generateAtlantisRepoYaml(directories)
 	if err != nil {
-		return fmt.Errorf("error processing files: %v", err)
+		return nil, fmt.Errorf("error generating YAML: %v", err)
 	}
+	return yamlOutputBytes, nil
+}
 
-	yamlOutputBytes, err := d.generateAtlantisRepoYaml(directories)
+// CheckGeneratedConfigMatches generates the atlantis config in memory and compares it to the committed file at
+// AtlantisRepoYmlPath. If they differ, a unified diff from the committed to the generated config is returned.
+func (d *Drifter) CheckGeneratedConfigMatches(_ context.Context) (bool, string, error) {
+	generated, err := d.generateAtlantisConfig()
+	if err != nil {
+		return false, "", err
+	}
+	configPath, err := d.atlantisConfigPath()
+	if err != nil {
+		return false, "", err
+	}
+	committed, err := os.ReadFile(filepath.Join(d.Terraform.Directory, configPath))
+	if err != nil && !os.IsNotExist(err) {
+		return false, "", fmt.Errorf("error reading committed atlantis config: %w", err)
+	}
+	if bytes.Equal(committed, generated) {
+		return true, "", nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(committed)),
+		B:        difflib.SplitLines(string(generated)),
+		FromFile: d.AtlantisRepoYmlPath,
+		ToFile:   "generated",
+		Context:  3,
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("error diffing atlantis config: %w", err)
+	}
+	return false, diff, nil
+}
+
+func (d *Drifter) generateAtlantisProjectsFile() error {
+	configPath, err := d.atlantisConfigPath()
+	if err != nil {
+		return err
+	}
+	yamlOutputBytes, err := d.generateAtlantisConfig()
 	if err != nil {
-		return fmt.Errorf("error generating YAML: %v", err)
+		return err
 	}
 	d.Logger.Info("atlantis YAML generated successfully.")
 	d.Logger.Debug("yaml content: ", zap.String("atlantis.yml", string(yamlOutputBytes)))
 
-	writeErr := os.WriteFile(fmt.Sprintf("%s/%s", d.Terraform.Directory, d.AtlantisRepoYmlPath), yamlOutputBytes, 0644)
+	writeErr := os.WriteFile(filepath.Join(d.Terraform.Directory, configPath), yamlOutputBytes, 0644)
 	if writeErr != nil {
 		return fmt.Errorf("error writing Atlantis yaml config file: %v", writeErr)
 	}
+	if d.GeneratedConfigOutput != nil {
+		if _, err := d.GeneratedConfigOutput.Write(yamlOutputBytes); err != nil {
+			return fmt.Errorf("error writing generated atlantis config: %w", err)
+		}
+	}
+	return nil
+}
+
+// GenerateConfig checks out the repository and writes the generated atlantis config to w. It runs no drift checks
+// and never writes into the checkout.
+func (d *Drifter) GenerateConfig(ctx context.Context, w io.Writer) error {
+	repo, err := d.checkOut(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(repo.Location()); err != nil {
+			d.Logger.Warn("failed to cleanup repo", zap.Error(err))
+		}
+	}()
+	d.Terraform.Directory = repo.Location()
+	yamlOutputBytes, err := d.generateAtlantisConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(yamlOutputBytes); err != nil {
+		return fmt.Errorf("error writing generated atlantis config: %w", err)
+	}
 	return nil
 }
 
-func findTFFiles(root string) ([]string, error) {
-	var files []string
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+// Look for s3/gcs/azurerm storage backends
+var backendPattern = regexp.MustCompile(`backend[\s]+"(s3)|(gcs)|(azurerm)"`)
+
+// walkTFFiles calls fn with the path of every .tf file under root, as the walk finds them
+func walkTFFiles(root string, fn func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		if !d.IsDir() && strings.HasSuffix(d.Name(), ".tf") {
-			files = append(files, path)
+			return fn(path)
 		}
 		return nil
 	})
-	return files, err
+}
+
+// findRootModules returns the terraform root module directories under root. Files are checked as the walk finds
+// them, so paths are not collected up front.
+func (d *Drifter) findRootModules(root string, pattern *regexp.Regexp) (map[string]struct{}, error) {
+	directories := map[string]struct{}{}
+	err := walkTFFiles(root, func(file string) error {
+		return d.addRootModule(directories, file, pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return directories, nil
 }
 
 func (d *Drifter) findTerraformRootModules(files []string, pattern *regexp.Regexp) (map[string]struct{}, error) {
 	directories := map[string]struct{}{}
 	for _, file := range files {
-		content, err := os.ReadFile(file)
+		if err := d.addRootModule(directories, file, pattern); err != nil {
+			return nil, err
+		}
+	}
+	return directories, nil
+}
+
+// addRootModule adds the directory of file to directories if file makes it a root module. Files of directories
+// already known to be root modules are not read.
+func (d *Drifter) addRootModule(directories map[string]struct{}, file string, pattern *regexp.Regexp) error {
+	reversed := reverseString(file)
+	cutPath := strings.SplitN(reversed, "/", 2)[1]
+	directory := reverseString(cutPath)
+	if _, exists := directories[directory]; exists {
+		return nil
+	}
+	if d.RootModuleRules.excluded(d.relativeDir(directory)) {
+		return nil
+	}
+	matched, err := fileMatchesAny(file, d.RootModuleRules.patterns(pattern))
+	if errors.Is(err, errNotText) {
+		d.Logger.Warn("Skipping .tf file that is not UTF-8 text", zap.String("file", file))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading tf file %s: %w", file, err)
+	}
+	if matched || d.RootModuleRules.hasAutoTFVars(directory) {
+		directories[directory] = struct{}{}
+	}
+	return nil
+}
+
+// scanChunkSize is how much of a file fileMatchesAny reads at a time. Up to scanOverlap bytes of the previous chunk,
+// starting at a line, are kept, so a match split across chunks is still found. Matches longer than that are not.
+const (
+	scanChunkSize = 64 << 10
+	scanOverlap   = 4 << 10
+)
+
+// errNotText is returned by fileMatchesAny for files that are not UTF-8 text, like binary files named .tf
+var errNotText = errors.New("not UTF-8 text")
+
+// fileMatchesAny returns true if any of patterns matches the content of filename. The file is scanned in bounded
+// chunks and the scan stops at the first match, so large files are never held in memory. Content is checked to be
+// UTF-8 text without NUL bytes before it is matched, and errNotText is returned as soon as it is not.
+func fileMatchesAny(filename string, patterns []*regexp.Regexp) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, 0, scanOverlap+scanChunkSize)
+	chunk := make([]byte, scanChunkSize)
+	for {
+		n, err := f.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if !isText(buf, errors.Is(err, io.EOF)) {
+			return false, errNotText
+		}
+		for _, p := range patterns {
+			if p.Match(buf) {
+				return true, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error reading tf file %s: %w", file, err)
+			return false, err
+		}
+		if len(buf) > scanOverlap {
+			tail := buf[len(buf)-scanOverlap:]
+			// Start the kept part at a line, so line anchored patterns do not match mid-line
+			if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+				tail = tail[i+1:]
+			}
+			for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+				tail = tail[1:]
+			}
+			buf = append(buf[:0], tail...)
 		}
+	}
+}
 
-		if pattern.Match(content) {
-			reversed := reverseString(file)
-			cutPath := strings.SplitN(reversed, "/", 2)[1]
-			directory := reverseString(cutPath)
-			directories[directory] = struct{}{}
+// isText returns true if b is UTF-8 without NUL bytes. Unless final, b may end in a rune the next read completes.
+func isText(b []byte, final bool) bool {
+	if bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	if utf8.Valid(b) {
+		return true
+	}
+	if final {
+		return false
+	}
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			return utf8.Valid(b[:len(b)-i])
 		}
 	}
-	return directories, nil
+	return false
 }
 
 func (d *Drifter) generateAtlantisRepoYaml(directories map[string]struct{}) ([]byte, error) {
@@ -365,7 +1735,7 @@ func (d *Drifter) generateAtlantisRepoYaml(directories map[string]struct{}) ([]b
 
 	var projects []map[string]interface{}
 	for _, dir := range dirList {
-		relativeDir := strings.Replace(dir, fmt.Sprintf("%s/", d.Terraform.Directory), "", 1)
+		relativeDir := d.relativeDir(dir)
 		project := map[string]interface{}{
 			"name":     relativeDir,
 			"dir":      relativeDir,
@@ -387,6 +1757,32 @@ func (d *Drifter) generateAtlantisRepoYaml(directories map[string]struct{}) ([]b
 	return yamlDataBytes, nil
 }
 
+// atlantisConfigPath resolves AtlantisRepoYmlPath, which may be absolute or relative to the repository, to a clean
+// path relative to the repository. Paths that resolve outside the repository are an error.
+func (d *Drifter) atlantisConfigPath() (string, error) {
+	p := d.AtlantisRepoYmlPath
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(d.Terraform.Directory, p)
+	}
+	rel, err := filepath.Rel(d.Terraform.Directory, p)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve atlantis config path %s: %w", d.AtlantisRepoYmlPath, err)
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("atlantis config path %s is not a file inside the repository %s", d.AtlantisRepoYmlPath, d.Terraform.Directory)
+	}
+	return rel, nil
+}
+
+// relativeDir returns dir relative to the repository, or dir unchanged if it is not inside the repository
+func (d *Drifter) relativeDir(dir string) string {
+	rel, err := filepath.Rel(d.Terraform.Directory, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dir
+	}
+	return rel
+}
+
 func reverseString(s string) string {
 	r := []rune(s)
 	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
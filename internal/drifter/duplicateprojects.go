@@ -0,0 +1,60 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"go.uber.org/zap"
+)
+
+// DuplicateProjectPolicy controls what happens when several projects in the atlantis config share a name
+type DuplicateProjectPolicy string
+
+const (
+	// DuplicateProjectNotify sends a DuplicateProject notification for each shared name and carries on
+	DuplicateProjectNotify DuplicateProjectPolicy = "notify"
+	// DuplicateProjectFail fails the run before anything is planned
+	DuplicateProjectFail DuplicateProjectPolicy = "fail"
+	// DuplicateProjectIgnore only logs shared names
+	DuplicateProjectIgnore DuplicateProjectPolicy = "ignore"
+)
+
+func ParseDuplicateProjectPolicy(s string) (DuplicateProjectPolicy, error) {
+	switch p := DuplicateProjectPolicy(s); p {
+	case "":
+		return DuplicateProjectNotify, nil
+	case DuplicateProjectNotify, DuplicateProjectFail, DuplicateProjectIgnore:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown duplicate project policy: %s", s)
+}
+
+// checkDuplicateProjects handles project names shared by several projects according to DuplicateProjectPolicy
+func (d *Drifter) checkDuplicateProjects(ctx context.Context, cfg *atlantis.SimpleAtlantisConfig) error {
+	if len(cfg.DuplicateProjects) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.DuplicateProjects))
+	for name := range cfg.DuplicateProjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.Logger.Warn("Several atlantis projects share a name", zap.String("project", name), zap.Strings("dirs", cfg.DuplicateProjects[name]))
+	}
+	switch d.DuplicateProjectPolicy {
+	case DuplicateProjectFail:
+		return &ConfigParseError{Path: d.AtlantisRepoYmlPath, Err: fmt.Errorf("atlantis projects share names: %s", strings.Join(names, ", "))}
+	case DuplicateProjectIgnore:
+		return nil
+	}
+	for _, name := range names {
+		if err := d.Notification.DuplicateProject(ctx, name, cfg.DuplicateProjects[name]); err != nil {
+			return fmt.Errorf("failed to notify of duplicate project %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestParseDuplicateProjectPolicy(t *testing.T) {
+	for s, want := range map[string]DuplicateProjectPolicy{
+		"":       DuplicateProjectNotify,
+		"notify": DuplicateProjectNotify,
+		"fail":   DuplicateProjectFail,
+		"ignore": DuplicateProjectIgnore,
+	} {
+		p, err := ParseDuplicateProjectPolicy(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, p, s)
+	}
+	_, err := ParseDuplicateProjectPolicy("sometimes")
+	require.Error(t, err)
+}
+
+func TestDrifter_checkDuplicateProjectsPolicies(t *testing.T) {
+	duplicated := &atlantis.SimpleAtlantisConfig{DuplicateProjects: map[string][]string{
+		"network": {"a", "b"},
+		"dns":     {"c", "d"},
+	}}
+	unique := &atlantis.SimpleAtlantisConfig{}
+	cases := []struct {
+		policy   DuplicateProjectPolicy
+		cfg      *atlantis.SimpleAtlantisConfig
+		fails    bool
+		notified []string
+	}{
+		{policy: "", cfg: duplicated, notified: []string{"dns=c,d", "network=a,b"}},
+		{policy: DuplicateProjectNotify, cfg: duplicated, notified: []string{"dns=c,d", "network=a,b"}},
+		{policy: DuplicateProjectIgnore, cfg: duplicated},
+		{policy: DuplicateProjectFail, cfg: duplicated, fails: true},
+		{policy: DuplicateProjectNotify, cfg: unique},
+		{policy: DuplicateProjectFail, cfg: unique},
+	}
+	for _, c := range cases {
+		n := newRecordingNotification(t)
+		d := &Drifter{
+			Logger:                 zaptest.NewLogger(t),
+			Notification:           n,
+			AtlantisRepoYmlPath:    "atlantis.yaml",
+			DuplicateProjectPolicy: c.policy,
+		}
+		err := d.checkDuplicateProjects(context.Background(), c.cfg)
+		if c.fails {
+			var parseErr *ConfigParseError
+			require.ErrorAs(t, err, &parseErr, "policy=%s", c.policy)
+			require.Equal(t, "atlantis.yaml", parseErr.Path)
+			require.ErrorContains(t, err, "dns, network")
+		} else {
+			require.NoError(t, err, "policy=%s", c.policy)
+		}
+		require.Equal(t, c.notified, n.duplicates, "policy=%s", c.policy)
+	}
+}
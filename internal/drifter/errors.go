@@ -0,0 +1,70 @@
+package drifter
+
+import "fmt"
+
+// CheckoutError is returned when the terraform repository could not be checked out
+type CheckoutError struct {
+	Repo string
+	Err  error
+}
+
+func (e *CheckoutError) Error() string {
+	return fmt.Sprintf("failed to checkout repo %s: %v", e.Repo, e.Err)
+}
+
+func (e *CheckoutError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigParseError is returned when the atlantis repo config could not be generated or parsed
+type ConfigParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigParseError) Error() string {
+	return fmt.Sprintf("failed to parse repo config %s: %v", e.Path, e.Err)
+}
+
+func (e *ConfigParseError) Unwrap() error {
+	return e.Err
+}
+
+// PlanError is returned when atlantis could not produce a plan summary for a workspace
+type PlanError struct {
+	Dir       string
+	Workspace string
+	Err       error
+}
+
+func (e *PlanError) Error() string {
+	return fmt.Sprintf("failed to get plan summary for (%s#%s): %v", e.Dir, e.Workspace, e.Err)
+}
+
+func (e *PlanError) Unwrap() error {
+	return e.Err
+}
+
+// WorkspaceListError is returned when the remote workspaces of a directory could not be initialized or listed
+type WorkspaceListError struct {
+	Dir string
+	Err error
+}
+
+func (e *WorkspaceListError) Error() string {
+	return fmt.Sprintf("failed to list workspaces in %s: %v", e.Dir, e.Err)
+}
+
+func (e *WorkspaceListError) Unwrap() error {
+	return e.Err
+}
+
+// RefMismatchError is returned when the checked out branch is not the ref Atlantis is asked to plan
+type RefMismatchError struct {
+	CheckedOut string
+	PlanRef    string
+}
+
+func (e *RefMismatchError) Error() string {
+	return fmt.Sprintf("checked out branch %s does not match plan ref %s", e.CheckedOut, e.PlanRef)
+}
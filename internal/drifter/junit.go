@@ -0,0 +1,72 @@
+package drifter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results of the run as a JUnit XML report, with one test case per workspace. The directory is
+// the class name and the workspace the test name. Drifted workspaces fail with the cliffnote as the message,
+// workspaces that could not be checked are errors and locked or never planned workspaces are skipped.
+func (d *Drifter) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{Name: d.Repo}
+	for _, r := range d.defaultRefResults() {
+		tc := junitTestCase{ClassName: r.Dir, Name: r.Workspace}
+		switch {
+		case r.Err != nil:
+			tc.Error = &junitMessage{Message: r.Err.Error(), Body: r.Err.Error()}
+			suite.Errors++
+		case r.Locked:
+			tc.Skipped = &junitMessage{Message: "plan is locked"}
+			suite.Skipped++
+		case r.NeverPlanned:
+			tc.Skipped = &junitMessage{Message: "never planned"}
+			suite.Skipped++
+		case r.Drift:
+			tc.Failure = &junitMessage{Message: r.Cliffnote, Type: "drift", Body: r.Cliffnote}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	b, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal junit report: %w", err)
+	}
+	_, err = io.WriteString(w, xml.Header+string(b)+"\n")
+	return err
+}
+
+// WriteJUnitFile writes the results of the run to filename as a JUnit XML report. The file is replaced atomically.
+func (d *Drifter) WriteJUnitFile(filename string) error {
+	return replaceFile(filename, "junit report", d.WriteJUnit)
+}
@@ -0,0 +1,42 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrifter_WriteJUnitFile(t *testing.T) {
+	d := &Drifter{Repo: "org/repo"}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default"})
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true, Cliffnote: "1 to add & <0> to change"})
+	d.reportResult(ctx, DriftResult{Dir: "c", Workspace: "prod", Err: errors.New("bad plan")})
+	d.reportResult(ctx, DriftResult{Dir: "d", Workspace: "default", Locked: true})
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Ref: "release", Drift: true})
+
+	filename := filepath.Join(t.TempDir(), "drift.xml")
+	require.NoError(t, d.WriteJUnitFile(filename))
+	body, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="org/repo" tests="4" failures="1" errors="1" skipped="1">
+    <testcase classname="a" name="default">
+      <failure message="1 to add &amp; &lt;0&gt; to change" type="drift">1 to add &amp; &lt;0&gt; to change</failure>
+    </testcase>
+    <testcase classname="b" name="default"></testcase>
+    <testcase classname="c" name="prod">
+      <error message="bad plan">bad plan</error>
+    </testcase>
+    <testcase classname="d" name="default">
+      <skipped message="plan is locked"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, string(body))
+}
@@ -0,0 +1,25 @@
+package drifter
+
+import "fmt"
+
+// LockedPlanBehavior controls what happens to a workspace whose plan could not run because the project is locked
+type LockedPlanBehavior string
+
+const (
+	// LockedPlanSkip caches the locked result and moves on
+	LockedPlanSkip LockedPlanBehavior = "skip"
+	// LockedPlanRecheck does not cache the locked result, so the next run checks the workspace again
+	LockedPlanRecheck LockedPlanBehavior = "recheck"
+	// LockedPlanNotify caches the locked result and sends a PlanLocked notification
+	LockedPlanNotify LockedPlanBehavior = "notify"
+)
+
+func ParseLockedPlanBehavior(s string) (LockedPlanBehavior, error) {
+	switch b := LockedPlanBehavior(s); b {
+	case "":
+		return LockedPlanSkip, nil
+	case LockedPlanSkip, LockedPlanRecheck, LockedPlanNotify:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown locked plan behavior: %s", s)
+}
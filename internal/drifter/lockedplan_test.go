@@ -0,0 +1,62 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestParseLockedPlanBehavior(t *testing.T) {
+	for s, want := range map[string]LockedPlanBehavior{
+		"":        LockedPlanSkip,
+		"skip":    LockedPlanSkip,
+		"recheck": LockedPlanRecheck,
+		"notify":  LockedPlanNotify,
+	} {
+		b, err := ParseLockedPlanBehavior(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, b, s)
+	}
+	_, err := ParseLockedPlanBehavior("sometimes")
+	require.Error(t, err)
+}
+
+func TestDrifter_LockedPlanBehavior(t *testing.T) {
+	locked := &atlantis.PlanResult{Summaries: []atlantis.PlanSummary{{HasLock: true}}}
+	cases := []struct {
+		behavior LockedPlanBehavior
+		cached   bool
+		notified []string
+	}{
+		{behavior: "", cached: true},
+		{behavior: LockedPlanSkip, cached: true},
+		{behavior: LockedPlanRecheck, cached: false},
+		{behavior: LockedPlanNotify, cached: true, notified: []string{"dir#default"}},
+	}
+	for _, c := range cases {
+		n := newRecordingNotification(t)
+		cache := &memoryCache{}
+		var results []DriftResult
+		d := &Drifter{
+			Logger:             zaptest.NewLogger(t),
+			Notification:       n,
+			ResultCache:        cache,
+			LockedPlanBehavior: c.behavior,
+			OnResult: func(_ context.Context, result DriftResult) {
+				results = append(results, result)
+			},
+		}
+		require.NoError(t, d.handlePlanSummary(context.Background(), "dir", "default", nil, locked, nil))
+		cached, err := cache.GetDriftCheckResult(context.Background(), &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "default"})
+		require.NoError(t, err)
+		require.Equal(t, c.cached, cached != nil, "behavior=%s", c.behavior)
+		require.Equal(t, c.notified, n.locked, "behavior=%s", c.behavior)
+		require.Len(t, results, 1)
+		require.True(t, results[0].Locked)
+		require.Empty(t, n.drifts)
+	}
+}
@@ -0,0 +1,106 @@
+package drifter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring UTC time range during which drift notifications are suppressed
+type MaintenanceWindow struct {
+	// Weekday the window starts on. Nil means every day.
+	Weekday *time.Weekday
+	// Start is the offset from midnight that the window starts
+	Start time.Duration
+	// End is the offset from midnight that the window ends. An End before Start crosses midnight.
+	End time.Duration
+}
+
+// ParseMaintenanceWindow parses windows like "22:00-02:00" or "Sat 01:00-05:00". Times are UTC.
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	var ret MaintenanceWindow
+	fields := strings.Fields(spec)
+	if len(fields) == 2 {
+		day, err := parseWeekday(fields[0])
+		if err != nil {
+			return ret, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		ret.Weekday = &day
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return ret, fmt.Errorf("invalid maintenance window %q: expected [weekday] HH:MM-HH:MM", spec)
+	}
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return ret, fmt.Errorf("invalid maintenance window %q: expected [weekday] HH:MM-HH:MM", spec)
+	}
+	var err error
+	if ret.Start, err = parseClock(start); err != nil {
+		return ret, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if ret.End, err = parseClock(end); err != nil {
+		return ret, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	return ret, nil
+}
+
+func ParseMaintenanceWindows(specs []string) ([]MaintenanceWindow, error) {
+	ret := make([]MaintenanceWindow, 0, len(specs))
+	for _, spec := range specs {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, w)
+	}
+	return ret, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %s", s)
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if t falls inside the window
+func (m MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+	if m.Start <= m.End {
+		return m.startsOn(t.Weekday()) && sinceMidnight >= m.Start && sinceMidnight < m.End
+	}
+	// The window crosses midnight: either we are late on the start day or early on the day after
+	if sinceMidnight >= m.Start {
+		return m.startsOn(t.Weekday())
+	}
+	if sinceMidnight < m.End {
+		return m.startsOn((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (m MaintenanceWindow) startsOn(day time.Weekday) bool {
+	return m.Weekday == nil || *m.Weekday == day
+}
+
+func inMaintenanceWindow(windows []MaintenanceWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMaintenanceWindow_Contains(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		spec     string
+		when     time.Time
+		contains bool
+	}{
+		{spec: "01:00-05:00", when: at(1, 3, 0), contains: true},
+		{spec: "01:00-05:00", when: at(1, 5, 0), contains: false},
+		{spec: "22:00-02:00", when: at(1, 23, 0), contains: true},
+		{spec: "22:00-02:00", when: at(2, 1, 59), contains: true},
+		{spec: "22:00-02:00", when: at(2, 12, 0), contains: false},
+		{spec: "Sat 01:00-05:00", when: at(1, 2, 0), contains: true},
+		{spec: "Sat 01:00-05:00", when: at(2, 2, 0), contains: false},
+		{spec: "saturday 23:00-01:00", when: at(2, 0, 30), contains: true},
+		{spec: "saturday 23:00-01:00", when: at(3, 0, 30), contains: false},
+	}
+	for _, c := range cases {
+		w, err := ParseMaintenanceWindow(c.spec)
+		require.NoError(t, err)
+		require.Equal(t, c.contains, w.Contains(c.when), "spec=%s when=%s", c.spec, c.when)
+	}
+}
+
+func TestParseMaintenanceWindowInvalid(t *testing.T) {
+	for _, spec := range []string{"", "01:00", "Someday 01:00-02:00", "25:00-26:00", "a b c"} {
+		_, err := ParseMaintenanceWindow(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestDrifter_MaintenanceWindowSuppressesRefDrift(t *testing.T) {
+	window, err := ParseMaintenanceWindow("Mon 09:00-10:00")
+	require.NoError(t, err)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	n := newRecordingNotification(t)
+	d := &Drifter{
+		Logger:       zaptest.NewLogger(t),
+		Notification: n,
+		AtlantisClient: newFakeAtlantis(t, map[string]string{
+			"dir": "Plan: 1 to add, 0 to change, 0 to destroy.",
+		}),
+		ResultCache:        &memoryCache{},
+		Clock:              clock,
+		CompareRefs:        []string{"release"},
+		MaintenanceWindows: []MaintenanceWindow{window},
+	}
+	d.startDriftSuppression()
+	ws := atlantis.DirectoriesWithWorkspaces{"dir": {"default"}}
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Empty(t, n.drifts)
+	require.Empty(t, n.refDrifts, "drift at a comparison ref is suppressed too")
+	require.Equal(t, int32(2), d.SuppressedDriftCount)
+
+	// The next day's run is outside the window
+	d.driftSuppressedReason = ""
+	clock.Advance(24 * time.Hour)
+	d.startDriftSuppression()
+	require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+	require.Equal(t, []string{"dir#default@release"}, n.refDrifts)
+}
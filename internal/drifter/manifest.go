@@ -0,0 +1,143 @@
+package drifter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest lists repositories to check in one run, each with its own overrides of the run-wide settings. It is read
+// from a YAML file like:
+//
+//	repos:
+//	- repo: org/infra
+//	  ref: main
+//	  directory_allowlist: [environments/prod]
+//	- repo: org/network
+//	  atlantis_config_path: atlantis.yaml
+//	  notify_target: network-team
+//	  cache_table: drift-network
+//	  plan_repo: org/network-mirror
+//	  state_prefix: network/
+//
+// Settings an entry leaves out keep their run-wide value.
+type Manifest struct {
+	Repos []ManifestRepo `yaml:"repos"`
+}
+
+// ManifestRepo is one repository of a Manifest
+type ManifestRepo struct {
+	// Repo is the repository to check, in owner/name form
+	Repo string `yaml:"repo"`
+	// Ref replaces Drifter.Ref
+	Ref string `yaml:"ref"`
+	// Branch replaces Drifter.Branch
+	Branch string `yaml:"branch"`
+	// DirectoryAllowlist replaces Drifter.DirectoryAllowlist
+	DirectoryAllowlist []string `yaml:"directory_allowlist"`
+	// AtlantisConfigPath replaces Drifter.AtlantisRepoYmlPath
+	AtlantisConfigPath string `yaml:"atlantis_config_path"`
+	// NotifyTarget, if set, sends every notification of the repository to the backend of that name in
+	// Drifter.RoutedNotifications instead of Drifter.Notification
+	NotifyTarget string `yaml:"notify_target"`
+	// CacheTable, if set, is the DynamoDB table results of the repository are cached in instead of DYNAMODB_TABLE
+	CacheTable string `yaml:"cache_table"`
+	// PlanRepo replaces Drifter.PlanRepo
+	PlanRepo string `yaml:"plan_repo"`
+	// StatePrefix replaces Drifter.StatePrefix. newDrifter should also list state under it.
+	StatePrefix string `yaml:"state_prefix"`
+}
+
+// LoadManifest reads a manifest file. Unknown keys are rejected, so a typo does not silently do nothing.
+func LoadManifest(filename string) (*Manifest, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var ret Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(ret.Repos) == 0 {
+		return nil, fmt.Errorf("manifest has no repos")
+	}
+	seen := make(map[string]struct{}, len(ret.Repos))
+	statePrefixes := make(map[string]string, len(ret.Repos))
+	for i, r := range ret.Repos {
+		if r.Repo == "" {
+			return nil, fmt.Errorf("manifest repo %d has no repo", i)
+		}
+		if _, exists := seen[r.Repo]; exists {
+			return nil, fmt.Errorf("manifest has more than one entry for %s", r.Repo)
+		}
+		seen[r.Repo] = struct{}{}
+		if other, exists := statePrefixes[r.StatePrefix]; exists && r.StatePrefix != "" {
+			return nil, fmt.Errorf("%s and %s share state prefix %s", other, r.Repo, r.StatePrefix)
+		}
+		statePrefixes[r.StatePrefix] = r.Repo
+	}
+	return &ret, nil
+}
+
+// Configure applies the overrides of r to d
+func (r ManifestRepo) Configure(d *Drifter) error {
+	d.Repo = r.Repo
+	if d.Logger != nil {
+		d.Logger = d.Logger.With(zap.String("repo", r.Repo))
+	}
+	if r.Ref != "" {
+		d.Ref = r.Ref
+	}
+	if r.Branch != "" {
+		d.Branch = r.Branch
+	}
+	if r.DirectoryAllowlist != nil {
+		d.DirectoryAllowlist = r.DirectoryAllowlist
+	}
+	if r.AtlantisConfigPath != "" {
+		d.AtlantisRepoYmlPath = r.AtlantisConfigPath
+	}
+	if r.PlanRepo != "" {
+		d.PlanRepo = r.PlanRepo
+	}
+	if r.StatePrefix != "" {
+		d.StatePrefix = r.StatePrefix
+	}
+	if r.NotifyTarget != "" {
+		target, exists := d.RoutedNotifications[r.NotifyTarget]
+		if !exists {
+			return fmt.Errorf("unknown notify target %s for %s", r.NotifyTarget, r.Repo)
+		}
+		d.Notification = target
+	}
+	return nil
+}
+
+// RunManifest checks every repository of m in order, each with a new Drifter from newDrifter configured by its entry.
+// newDrifter sets up what Configure cannot, like the result cache of CacheTable. A repository that fails does not stop
+// the others, and the errors of every failed repository are returned together.
+func RunManifest(ctx context.Context, m *Manifest, newDrifter func(r ManifestRepo) (*Drifter, error)) error {
+	var errs []error
+	for _, r := range m.Repos {
+		d, err := newDrifter(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to set up %s: %w", r.Repo, err))
+			continue
+		}
+		if err := r.Configure(d); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := d.Drift(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to check %s: %w", r.Repo, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,139 @@
+package drifter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/notification"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func writeManifest(t *testing.T, body string) string {
+	filename := filepath.Join(t.TempDir(), "manifest.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(body), 0o600))
+	return filename
+}
+
+func TestLoadManifest(t *testing.T) {
+	m, err := LoadManifest(writeManifest(t, `repos:
+- repo: org/infra
+  ref: main
+  directory_allowlist: [environments/prod]
+- repo: org/network
+  atlantis_config_path: atlantis.yaml
+  notify_target: network-team
+`))
+	require.NoError(t, err)
+	require.Equal(t, &Manifest{Repos: []ManifestRepo{
+		{Repo: "org/infra", Ref: "main", DirectoryAllowlist: []string{"environments/prod"}},
+		{Repo: "org/network", AtlantisConfigPath: "atlantis.yaml", NotifyTarget: "network-team"},
+	}}, m)
+
+	_, err = LoadManifest(writeManifest(t, "repos:\n- repo: org/infra\n- repo: org/infra\n"))
+	require.ErrorContains(t, err, "more than one entry")
+	_, err = LoadManifest(writeManifest(t, "repos:\n- repo: org/infra\n  reff: main\n"))
+	require.Error(t, err)
+	_, err = LoadManifest(writeManifest(t, "repos:\n- repo: org/a\n  cache_table: drift\n- repo: org/b\n  cache_table: drift\n"))
+	require.NoError(t, err, "cache keys name the repository, so repositories can share a table")
+	_, err = LoadManifest(writeManifest(t, "repos:\n- repo: org/a\n  state_prefix: live/\n- repo: org/b\n  state_prefix: live/\n"))
+	require.ErrorContains(t, err, "share state prefix")
+	_, err = LoadManifest(writeManifest(t, "repos: []\n"))
+	require.Error(t, err)
+}
+
+func TestManifestRepo_Configure(t *testing.T) {
+	team := newRecordingNotification(t)
+	d := &Drifter{
+		Logger:              zaptest.NewLogger(t),
+		Ref:                 "main",
+		AtlantisRepoYmlPath: ".atlantis/atlantis.yml",
+		DirectoryAllowlist:  []string{"a"},
+		RoutedNotifications: map[string]notification.Notification{"network-team": team},
+	}
+	require.NoError(t, ManifestRepo{Repo: "org/network", Branch: "develop", NotifyTarget: "network-team"}.Configure(d))
+	require.Equal(t, "org/network", d.Repo)
+	require.Equal(t, "main", d.Ref)
+	require.Equal(t, "develop", d.Branch)
+	require.Equal(t, []string{"a"}, d.DirectoryAllowlist)
+	require.Equal(t, ".atlantis/atlantis.yml", d.AtlantisRepoYmlPath)
+	require.Same(t, team, d.Notification)
+
+	require.Error(t, ManifestRepo{Repo: "org/infra", NotifyTarget: "nobody"}.Configure(d))
+}
+
+func TestManifest_RepoOverrides(t *testing.T) {
+	var planned []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repository string
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		planned = append(planned, req.Repository)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ProjectResults": []interface{}{
+				map[string]interface{}{"PlanSuccess": map[string]interface{}{"TerraformOutput": "No changes."}},
+			},
+		})
+	}))
+	defer srv.Close()
+	m, err := LoadManifest(writeManifest(t, `repos:
+- repo: org/infra
+  plan_repo: org/infra-mirror
+  state_prefix: infra/
+- repo: org/network
+  plan_repo: org/network-mirror
+  state_prefix: network/
+`))
+	require.NoError(t, err)
+	// Both repositories share one bucket, and each only owns the state under its prefix
+	lister := fakeStateLister{
+		"infra/app/terraform.tfstate",
+		"infra/gone/terraform.tfstate",
+		"network/vpc/terraform.tfstate",
+		"network/app/terraform.tfstate",
+	}
+	orphaned := map[string][]string{}
+	for _, r := range m.Repos {
+		n := newRecordingNotification(t)
+		d := &Drifter{
+			Logger:         zaptest.NewLogger(t),
+			Notification:   n,
+			AtlantisClient: &atlantis.Client{AtlantisHostname: srv.URL, HTTPClient: srv.Client()},
+			ResultCache:    processedcache.Noop{},
+			PlanRepo:       "org/shared-mirror",
+			StateLister:    lister,
+		}
+		require.NoError(t, r.Configure(d))
+		ws := atlantis.DirectoriesWithWorkspaces{"app": {"default"}}
+		require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws))
+		require.NoError(t, d.FindOrphanedState(context.Background(), ws))
+		orphaned[r.Repo] = n.orphaned
+	}
+	require.Equal(t, []string{"org/infra-mirror", "org/network-mirror"}, planned)
+	require.Equal(t, map[string][]string{
+		"org/infra":   {"gone=infra/gone/terraform.tfstate"},
+		"org/network": {"vpc=network/vpc/terraform.tfstate"},
+	}, orphaned)
+}
+
+func TestRunManifest_ConfigureErrors(t *testing.T) {
+	var created []string
+	err := RunManifest(context.Background(), &Manifest{Repos: []ManifestRepo{
+		{Repo: "org/a", NotifyTarget: "nobody"},
+		{Repo: "org/b", NotifyTarget: "nobody"},
+	}}, func(r ManifestRepo) (*Drifter, error) {
+		created = append(created, r.Repo)
+		return &Drifter{}, nil
+	})
+	require.ErrorContains(t, err, "org/a")
+	require.ErrorContains(t, err, "org/b")
+	require.Equal(t, []string{"org/a", "org/b"}, created)
+}
@@ -0,0 +1,42 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/notification"
+	"go.uber.org/zap"
+)
+
+// TestNotifications sends a harmless test message through every notification backend, including routed ones, so
+// webhook URLs, tokens and network access can be checked before drift depends on them. Each backend is logged as
+// working or failing, and the errors of the failing ones are returned together.
+func (d *Drifter) TestNotifications(ctx context.Context) error {
+	message := "Drift detection notification test, no action needed"
+	if d.Repo != "" {
+		message = fmt.Sprintf("Drift detection notification test for %s, no action needed", d.Repo)
+	}
+	var errs []error
+	test := func(name string, n notification.Notification) {
+		if err := n.TestMessage(ctx, message); err != nil {
+			d.Logger.Error("Notification backend failed", zap.String("backend", name), zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		d.Logger.Info("Notification backend works", zap.String("backend", name))
+	}
+	for i, n := range notification.Backends(d.Notification) {
+		test(fmt.Sprintf("%d %T", i, n), n)
+	}
+	routes := make([]string, 0, len(d.RoutedNotifications))
+	for name := range d.RoutedNotifications {
+		routes = append(routes, name)
+	}
+	sort.Strings(routes)
+	for _, name := range routes {
+		test("route "+name, d.RoutedNotifications[name])
+	}
+	return errors.Join(errs...)
+}
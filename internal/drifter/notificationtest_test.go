@@ -0,0 +1,44 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/notification"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type testMessageNotification struct {
+	*notification.Zap
+	err      error
+	messages []string
+}
+
+func (n *testMessageNotification) TestMessage(_ context.Context, message string) error {
+	n.messages = append(n.messages, message)
+	return n.err
+}
+
+func TestDrifter_TestNotifications(t *testing.T) {
+	zapNotification := &notification.Zap{Logger: zaptest.NewLogger(t)}
+	working := &testMessageNotification{Zap: zapNotification}
+	broken := &testMessageNotification{Zap: zapNotification, err: errors.New("invalid webhook")}
+	routed := &testMessageNotification{Zap: zapNotification}
+	d := &Drifter{
+		Logger:              zaptest.NewLogger(t),
+		Repo:                "org/repo",
+		Notification:        &notification.Multi{Notifications: []notification.Notification{working, broken}},
+		RoutedNotifications: map[string]notification.Notification{"team-a": routed},
+	}
+	err := d.TestNotifications(context.Background())
+	require.ErrorContains(t, err, "invalid webhook")
+	require.ErrorContains(t, err, "1 *drifter.testMessageNotification")
+	require.Equal(t, []string{"Drift detection notification test for org/repo, no action needed"}, working.messages)
+	require.Len(t, broken.messages, 1)
+	require.Len(t, routed.messages, 1)
+
+	broken.err = nil
+	require.NoError(t, d.TestNotifications(context.Background()))
+}
@@ -0,0 +1,116 @@
+package drifter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var openMetricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// defaultRefResults returns the results for the default ref, sorted by directory and workspace
+func (d *Drifter) defaultRefResults() []DriftResult {
+	d.mu.Lock()
+	results := make([]DriftResult, 0, len(d.results))
+	for _, r := range d.results {
+		// Comparison refs are not part of the run's drift state
+		if r.Ref == "" {
+			results = append(results, r)
+		}
+	}
+	d.mu.Unlock()
+	sortResults(results)
+	return results
+}
+
+// sortResults sorts results by directory, workspace and ref
+func sortResults(results []DriftResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Dir != results[j].Dir {
+			return results[i].Dir < results[j].Dir
+		}
+		if results[i].Workspace != results[j].Workspace {
+			return results[i].Workspace < results[j].Workspace
+		}
+		return results[i].Ref < results[j].Ref
+	})
+}
+
+// WriteOpenMetrics writes the results of the run in OpenMetrics text format
+func (d *Drifter) WriteOpenMetrics(w io.Writer) error {
+	results := d.defaultRefResults()
+	var b strings.Builder
+	b.WriteString("# TYPE atlantis_drift_workspace_drifted gauge\n")
+	b.WriteString("# HELP atlantis_drift_workspace_drifted Whether the workspace has drifted.\n")
+	for _, r := range results {
+		if r.Err != nil || r.Locked || r.NeverPlanned {
+			continue
+		}
+		drifted := 0
+		if r.Drift {
+			drifted = 1
+		}
+		fmt.Fprintf(&b, "atlantis_drift_workspace_drifted{dir=\"%s\",workspace=\"%s\"} %d\n", openMetricsLabelEscaper.Replace(r.Dir), openMetricsLabelEscaper.Replace(r.Workspace), drifted)
+	}
+	b.WriteString("# TYPE atlantis_drift_workspace_error gauge\n")
+	b.WriteString("# HELP atlantis_drift_workspace_error Whether the workspace could not be checked for drift.\n")
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(&b, "atlantis_drift_workspace_error{dir=\"%s\",workspace=\"%s\"} 1\n", openMetricsLabelEscaper.Replace(r.Dir), openMetricsLabelEscaper.Replace(r.Workspace))
+		}
+	}
+	b.WriteString("# TYPE atlantis_drift_workspaces gauge\n")
+	b.WriteString("# HELP atlantis_drift_workspaces Number of workspaces by state.\n")
+	for _, state := range []struct {
+		name  string
+		count int32
+	}{
+		{"drifted", d.DriftedWorkspaceCount},
+		{"undrifted", d.UndriftedWorkspaceCount},
+		{"extra", d.ExtraWorkspaceCount},
+		{"missing", d.MissingWorkspaceCount},
+		{"plan_error", d.PlanErrorCount},
+		{"never_planned", d.NeverPlannedCount},
+		{"cached", d.CachedWorkspaceCount},
+	} {
+		fmt.Fprintf(&b, "atlantis_drift_workspaces{state=\"%s\"} %d\n", state.name, state.count)
+	}
+	b.WriteString("# EOF\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteOpenMetricsFile writes the results of the run to filename in OpenMetrics text format. The file is replaced
+// atomically so a textfile collector never reads a partial file.
+func (d *Drifter) WriteOpenMetricsFile(filename string) error {
+	return replaceFile(filename, "metrics file", d.WriteOpenMetrics)
+}
+
+// replaceFile atomically replaces filename with what write writes, so readers never see a partial file. what names
+// the file in errors.
+func replaceFile(filename string, what string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", what, err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", what, err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to chmod %s: %w", what, err)
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", what, err)
+	}
+	return nil
+}
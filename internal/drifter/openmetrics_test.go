@@ -0,0 +1,47 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrifter_WriteOpenMetricsFile(t *testing.T) {
+	d := &Drifter{
+		DriftedWorkspaceCount:   1,
+		UndriftedWorkspaceCount: 1,
+		PlanErrorCount:          1,
+	}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default"})
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true})
+	d.reportResult(ctx, DriftResult{Dir: "c", Workspace: "prod", Err: errors.New("bad plan")})
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Ref: "release", Drift: true})
+
+	filename := filepath.Join(t.TempDir(), "drift.prom")
+	require.NoError(t, d.WriteOpenMetricsFile(filename))
+	body, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, `# TYPE atlantis_drift_workspace_drifted gauge
+# HELP atlantis_drift_workspace_drifted Whether the workspace has drifted.
+atlantis_drift_workspace_drifted{dir="a",workspace="default"} 1
+atlantis_drift_workspace_drifted{dir="b",workspace="default"} 0
+# TYPE atlantis_drift_workspace_error gauge
+# HELP atlantis_drift_workspace_error Whether the workspace could not be checked for drift.
+atlantis_drift_workspace_error{dir="c",workspace="prod"} 1
+# TYPE atlantis_drift_workspaces gauge
+# HELP atlantis_drift_workspaces Number of workspaces by state.
+atlantis_drift_workspaces{state="drifted"} 1
+atlantis_drift_workspaces{state="undrifted"} 1
+atlantis_drift_workspaces{state="extra"} 0
+atlantis_drift_workspaces{state="missing"} 0
+atlantis_drift_workspaces{state="plan_error"} 1
+atlantis_drift_workspaces{state="never_planned"} 0
+atlantis_drift_workspaces{state="cached"} 0
+# EOF
+`, string(body))
+}
@@ -0,0 +1,73 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"go.uber.org/zap"
+)
+
+// DefaultWorkspaceKeyPrefix is the s3 backend's default workspace_key_prefix
+const DefaultWorkspaceKeyPrefix = "env:"
+
+// DefaultStateKeyPattern matches the state keys the s3 backend writes when key is <dir>/terraform.tfstate: the key
+// itself for the default workspace, and env:/<workspace>/<key> for the others
+var DefaultStateKeyPattern = stateKeyPattern(DefaultWorkspaceKeyPrefix, "")
+
+// stateKeyPattern matches the state keys the s3 backend writes when key is <statePrefix><dir>/terraform.tfstate and
+// workspace_key_prefix is workspaceKeyPrefix
+func stateKeyPattern(workspaceKeyPrefix string, statePrefix string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?:` + regexp.QuoteMeta(strings.TrimSuffix(workspaceKeyPrefix, "/")) + `/[^/]+/)?` + regexp.QuoteMeta(statePrefix) + `(?P<dir>.+)/terraform\.tfstate$`)
+}
+
+// FindOrphanedState lists the state files in StateLister and notifies of every one whose directory, taken from the
+// "dir" group of StateKeyPattern, has no project in ws. Keys the pattern does not match are ignored. Without a
+// StateKeyPattern, keys are matched as the s3 backend writes them under StatePrefix with WorkspaceKeyPrefix. A custom
+// StateKeyPattern is matched against the key with StatePrefix removed.
+func (d *Drifter) FindOrphanedState(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
+	pattern := d.StateKeyPattern
+	stripPrefix := d.StatePrefix
+	if pattern == nil {
+		workspaceKeyPrefix := d.WorkspaceKeyPrefix
+		if workspaceKeyPrefix == "" {
+			workspaceKeyPrefix = DefaultWorkspaceKeyPrefix
+		}
+		pattern = stateKeyPattern(workspaceKeyPrefix, d.StatePrefix)
+		stripPrefix = ""
+	}
+	dirGroup := pattern.SubexpIndex("dir")
+	if dirGroup < 0 {
+		return fmt.Errorf("state key pattern %s has no dir group", pattern)
+	}
+	keys, err := d.StateLister.ListStateKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list state files: %w", err)
+	}
+	managed := make(map[string]struct{}, len(ws))
+	for dir := range ws {
+		managed[path.Clean(dir)] = struct{}{}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m := pattern.FindStringSubmatch(strings.TrimPrefix(key, stripPrefix))
+		if m == nil {
+			d.Logger.Debug("Ignoring state key that does not match the pattern", zap.String("key", key))
+			continue
+		}
+		dir := path.Clean(m[dirGroup])
+		if _, exists := managed[dir]; exists {
+			continue
+		}
+		atomic.AddInt32(&d.OrphanedStateCount, 1)
+		if err := d.Notification.OrphanedState(ctx, dir, key); err != nil {
+			return fmt.Errorf("failed to notify of orphaned state %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestStateKeyPattern(t *testing.T) {
+	cases := []struct {
+		workspaceKeyPrefix string
+		statePrefix        string
+		key                string
+		dir                string
+	}{
+		{workspaceKeyPrefix: "env:", key: "live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "env:", key: "env:/prod/live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "env:/", key: "env:/prod/live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "env:", key: "live/app/other.tfstate"},
+		{workspaceKeyPrefix: "env:", key: "terraform.tfstate"},
+		{workspaceKeyPrefix: "env:", statePrefix: "terraform/", key: "terraform/live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "env:", statePrefix: "terraform/", key: "env:/prod/terraform/live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "env:", statePrefix: "terraform/", key: "live/app/terraform.tfstate"},
+		{workspaceKeyPrefix: "workspaces", key: "workspaces/prod/live/app/terraform.tfstate", dir: "live/app"},
+		{workspaceKeyPrefix: "a.b", key: "axb/prod/live/app/terraform.tfstate", dir: "axb/prod/live/app"},
+	}
+	for _, c := range cases {
+		pattern := stateKeyPattern(c.workspaceKeyPrefix, c.statePrefix)
+		m := pattern.FindStringSubmatch(c.key)
+		if c.dir == "" {
+			require.Nil(t, m, "key=%s pattern=%s", c.key, pattern)
+			continue
+		}
+		require.NotNil(t, m, "key=%s pattern=%s", c.key, pattern)
+		require.Equal(t, c.dir, m[pattern.SubexpIndex("dir")], "key=%s pattern=%s", c.key, pattern)
+	}
+}
+
+type brokenStateLister struct{}
+
+func (brokenStateLister) ListStateKeys(_ context.Context) ([]string, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestDrifter_FindOrphanedStateListError(t *testing.T) {
+	n := newRecordingNotification(t)
+	d := &Drifter{
+		Logger:       zaptest.NewLogger(t),
+		Notification: n,
+		StateLister:  brokenStateLister{},
+	}
+	require.Error(t, d.FindOrphanedState(context.Background(), atlantis.DirectoriesWithWorkspaces{"live/app": {"default"}}))
+	require.Empty(t, n.orphaned)
+	require.Zero(t, d.OrphanedStateCount)
+}
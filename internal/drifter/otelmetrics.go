@@ -0,0 +1,83 @@
+package drifter
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// meterName is the instrumentation scope of the OpenTelemetry instruments
+const meterName = "github.com/revdotcom/gha-atlantis-drift-detection/internal/drifter"
+
+// otelInstruments are the OpenTelemetry instruments results are recorded to. They count the same workspaces as the
+// OpenMetrics report, as they are found instead of once the run is done.
+type otelInstruments struct {
+	checked     metric.Int64Counter
+	drifted     metric.Int64Counter
+	errors      metric.Int64Counter
+	planLatency metric.Float64Histogram
+}
+
+// newOtelInstruments creates the instruments on provider. An instrument that cannot be created is replaced by a no-op
+// one, so metrics never fail a run.
+func newOtelInstruments(provider metric.MeterProvider) *otelInstruments {
+	if provider == nil {
+		provider = noop.NewMeterProvider()
+	}
+	meter := provider.Meter(meterName)
+	noopMeter := noop.NewMeterProvider().Meter(meterName)
+	counter := func(name string, description string) metric.Int64Counter {
+		c, err := meter.Int64Counter(name, metric.WithDescription(description), metric.WithUnit("{workspace}"))
+		if err != nil {
+			c, _ = noopMeter.Int64Counter(name)
+		}
+		return c
+	}
+	ret := &otelInstruments{
+		checked: counter("atlantis_drift.workspaces.checked", "Workspaces checked for drift."),
+		drifted: counter("atlantis_drift.workspaces.drifted", "Workspaces found to have drifted."),
+		errors:  counter("atlantis_drift.workspaces.errors", "Workspaces that could not be checked for drift."),
+	}
+	var err error
+	ret.planLatency, err = meter.Float64Histogram("atlantis_drift.plan.duration", metric.WithDescription("Time taken by Atlantis plan requests."), metric.WithUnit("s"))
+	if err != nil {
+		ret.planLatency, _ = noopMeter.Float64Histogram("atlantis_drift.plan.duration")
+	}
+	return ret
+}
+
+func (d *Drifter) instruments() *otelInstruments {
+	d.instrumentsOnce.Do(func() {
+		d.otelInstruments = newOtelInstruments(d.MeterProvider)
+	})
+	return d.otelInstruments
+}
+
+// recordResultMetrics counts result on the OpenTelemetry instruments. The workspace is only an attribute with
+// MetricWorkspaceAttribute, to keep the number of series down.
+func (d *Drifter) recordResultMetrics(ctx context.Context, result DriftResult) {
+	attrs := []attribute.KeyValue{attribute.String("repo", d.Repo), attribute.String("dir", result.Dir)}
+	if d.MetricWorkspaceAttribute {
+		attrs = append(attrs, attribute.String("workspace", result.Workspace))
+	}
+	if result.Ref != "" {
+		attrs = append(attrs, attribute.String("ref", result.Ref))
+	}
+	opt := metric.WithAttributes(attrs...)
+	instruments := d.instruments()
+	instruments.checked.Add(ctx, 1, opt)
+	if result.Drift {
+		instruments.drifted.Add(ctx, 1, opt)
+	}
+	if result.Err != nil {
+		instruments.errors.Add(ctx, 1, opt)
+	}
+}
+
+// recordPlanLatency records the time since start, when a plan request for dir was sent
+func (d *Drifter) recordPlanLatency(ctx context.Context, dir string, start time.Time) {
+	d.instruments().planLatency.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("repo", d.Repo), attribute.String("dir", dir)))
+}
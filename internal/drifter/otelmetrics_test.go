@@ -0,0 +1,76 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestDrifter_MeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	d := &Drifter{MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true})
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default"})
+	d.reportResult(ctx, DriftResult{Dir: "c", Workspace: "default", Err: errors.New("bad plan")})
+	d.recordPlanLatency(ctx, "a", time.Now().Add(-time.Second))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	totals := make(map[string]int64)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			for _, dp := range data.DataPoints {
+				totals[m.Name] += dp.Value
+			}
+		case metricdata.Histogram[float64]:
+			require.Len(t, data.DataPoints, 1)
+			require.Equal(t, uint64(1), data.DataPoints[0].Count)
+			require.GreaterOrEqual(t, data.DataPoints[0].Sum, 1.0)
+		}
+	}
+	require.Equal(t, map[string]int64{
+		"atlantis_drift.workspaces.checked": 3,
+		"atlantis_drift.workspaces.drifted": 1,
+		"atlantis_drift.workspaces.errors":  1,
+	}, totals)
+
+	// Workspaces are only an attribute when asked for
+	for _, withWorkspace := range []bool{false, true} {
+		reader := sdkmetric.NewManualReader()
+		d := &Drifter{
+			Repo:                     "owner/repo",
+			MeterProvider:            sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+			MetricWorkspaceAttribute: withWorkspace,
+		}
+		d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default"})
+		d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "staging"})
+		var rm metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(ctx, &rm))
+		var checked metricdata.Sum[int64]
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name == "atlantis_drift.workspaces.checked" {
+				checked = m.Data.(metricdata.Sum[int64])
+			}
+		}
+		if !withWorkspace {
+			require.Len(t, checked.DataPoints, 1)
+			require.Equal(t, attribute.NewSet(attribute.String("repo", "owner/repo"), attribute.String("dir", "a")), checked.DataPoints[0].Attributes)
+			continue
+		}
+		require.Len(t, checked.DataPoints, 2)
+		_, ok := checked.DataPoints[0].Attributes.Value("workspace")
+		require.True(t, ok)
+	}
+
+	// Without a provider nothing is recorded, and nothing fails
+	(&Drifter{}).reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true})
+}
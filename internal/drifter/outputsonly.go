@@ -0,0 +1,35 @@
+package drifter
+
+import (
+	"fmt"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+)
+
+// OutputsOnlyDriftPolicy controls whether a plan whose only changes are to output values counts as drift
+type OutputsOnlyDriftPolicy string
+
+const (
+	// OutputsOnlyDrift counts output-only changes as drift
+	OutputsOnlyDrift OutputsOnlyDriftPolicy = "drift"
+	// OutputsOnlyIgnore treats plans that only change outputs as clean
+	OutputsOnlyIgnore OutputsOnlyDriftPolicy = "ignore"
+)
+
+func ParseOutputsOnlyDriftPolicy(s string) (OutputsOnlyDriftPolicy, error) {
+	switch p := OutputsOnlyDriftPolicy(s); p {
+	case "":
+		return OutputsOnlyDrift, nil
+	case OutputsOnlyDrift, OutputsOnlyIgnore:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown outputs only drift policy: %s", s)
+}
+
+// hasDrift reports whether a plan counts as drift, after IgnoreResourceTypes and OutputsOnlyDriftPolicy
+func (d *Drifter) hasDrift(pr *atlantis.PlanResult) bool {
+	if d.OutputsOnlyDriftPolicy == OutputsOnlyIgnore {
+		return pr.HasResourceChangesIgnoring(d.IgnoreResourceTypes)
+	}
+	return pr.HasChangesIgnoring(d.IgnoreResourceTypes)
+}
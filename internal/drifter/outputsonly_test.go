@@ -0,0 +1,54 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutputsOnlyDriftPolicy(t *testing.T) {
+	for s, want := range map[string]OutputsOnlyDriftPolicy{
+		"":       OutputsOnlyDrift,
+		"drift":  OutputsOnlyDrift,
+		"ignore": OutputsOnlyIgnore,
+	} {
+		p, err := ParseOutputsOnlyDriftPolicy(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, p, s)
+	}
+	_, err := ParseOutputsOnlyDriftPolicy("sometimes")
+	require.Error(t, err)
+}
+
+func TestDrifter_hasDrift(t *testing.T) {
+	plan := func(summary string, output string) *atlantis.PlanResult {
+		return &atlantis.PlanResult{Summaries: []atlantis.PlanSummary{{Summary: summary, Output: output}}}
+	}
+	outputsOnly := plan("Changes to Outputs.", "Changes to Outputs:\n  ~ id = \"a\" -> \"b\"\n")
+	resources := plan("Plan: 0 to add, 1 to change, 0 to destroy.", "  # aws_s3_bucket.logs will be updated in-place\n\nPlan: 0 to add, 1 to change, 0 to destroy.\n")
+	ignoredResourcesAndOutputs := plan("Plan: 0 to add, 1 to change, 0 to destroy.", "  # aws_s3_bucket.logs will be updated in-place\n\nPlan: 0 to add, 1 to change, 0 to destroy.\n\nChanges to Outputs:\n  ~ id = \"a\" -> \"b\"\n")
+	clean := plan("No changes. Your infrastructure matches the configuration.", "No changes. Your infrastructure matches the configuration.\n")
+	cases := []struct {
+		name    string
+		policy  OutputsOnlyDriftPolicy
+		ignore  []string
+		plan    *atlantis.PlanResult
+		drifted bool
+	}{
+		{name: "outputs only", policy: "", plan: outputsOnly, drifted: true},
+		{name: "outputs only", policy: OutputsOnlyDrift, plan: outputsOnly, drifted: true},
+		{name: "outputs only", policy: OutputsOnlyIgnore, plan: outputsOnly, drifted: false},
+		{name: "resources", policy: OutputsOnlyDrift, plan: resources, drifted: true},
+		{name: "resources", policy: OutputsOnlyIgnore, plan: resources, drifted: true},
+		{name: "ignored resources", policy: OutputsOnlyIgnore, ignore: []string{"aws_s3_bucket"}, plan: resources, drifted: false},
+		{name: "ignored resources and outputs", policy: OutputsOnlyDrift, ignore: []string{"aws_s3_bucket"}, plan: ignoredResourcesAndOutputs, drifted: true},
+		{name: "ignored resources and outputs", policy: OutputsOnlyIgnore, ignore: []string{"aws_s3_bucket"}, plan: ignoredResourcesAndOutputs, drifted: false},
+		{name: "clean", policy: OutputsOnlyDrift, plan: clean, drifted: false},
+		{name: "clean", policy: OutputsOnlyIgnore, plan: clean, drifted: false},
+	}
+	for _, c := range cases {
+		d := &Drifter{OutputsOnlyDriftPolicy: c.policy, IgnoreResourceTypes: c.ignore}
+		require.Equal(t, c.drifted, d.hasDrift(c.plan), "plan=%s policy=%s", c.name, c.policy)
+	}
+}
@@ -0,0 +1,101 @@
+package drifter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Overlay adjusts how projects of the atlantis config are checked, without changing the config itself. It is read from
+// a YAML file like:
+//
+//	projects:
+//	- dir: network
+//	  ignored_workspaces: [scratch]
+//	  cache_valid_duration: 12h
+//
+// Each entry applies to every workspace of its directory. Settings an entry leaves out keep their run-wide value, and
+// a directory may only have one entry.
+type Overlay struct {
+	Projects []OverlayProject `yaml:"projects"`
+}
+
+// OverlayProject is the overlay of one project directory
+type OverlayProject struct {
+	Dir string `yaml:"dir"`
+	// IgnoredWorkspaces are remote workspaces the extra workspace check does not report, on top of the ones atlantis
+	// plans
+	IgnoredWorkspaces []string `yaml:"ignored_workspaces"`
+	// CacheValidDuration, if set, replaces Drifter.CacheValidDuration for the directory
+	CacheValidDuration time.Duration `yaml:"cache_valid_duration"`
+}
+
+// LoadOverlay reads an overlay file. Unknown keys are rejected, so a typo does not silently do nothing.
+func LoadOverlay(filename string) (*Overlay, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay: %w", err)
+	}
+	var ret Overlay
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("failed to parse overlay: %w", err)
+	}
+	seen := make(map[string]struct{}, len(ret.Projects))
+	for i, p := range ret.Projects {
+		if p.Dir == "" {
+			return nil, fmt.Errorf("overlay project %d has no dir", i)
+		}
+		dir := atlantis.NormalizeDir(p.Dir)
+		if _, exists := seen[dir]; exists {
+			return nil, fmt.Errorf("overlay has more than one entry for %s", dir)
+		}
+		seen[dir] = struct{}{}
+		ret.Projects[i].Dir = dir
+	}
+	return &ret, nil
+}
+
+// applyOverlay loads OverlayPath, if set, and logs every override it applies to the projects of cfg
+func (d *Drifter) applyOverlay(cfg *atlantis.SimpleAtlantisConfig) error {
+	d.overlay = nil
+	if d.OverlayPath == "" {
+		return nil
+	}
+	overlay, err := LoadOverlay(d.OverlayPath)
+	if err != nil {
+		return &ConfigParseError{Path: d.OverlayPath, Err: err}
+	}
+	workspaces := atlantis.ConfigToWorkspaces(cfg)
+	d.overlay = make(map[string]OverlayProject, len(overlay.Projects))
+	for _, p := range overlay.Projects {
+		if _, exists := workspaces[p.Dir]; !exists {
+			d.Logger.Warn("Overlay names a directory with no atlantis project", zap.String("dir", p.Dir))
+			continue
+		}
+		fields := []zap.Field{zap.String("dir", p.Dir)}
+		if len(p.IgnoredWorkspaces) > 0 {
+			fields = append(fields, zap.Strings("ignored-workspaces", p.IgnoredWorkspaces))
+		}
+		if p.CacheValidDuration > 0 {
+			fields = append(fields, zap.Duration("cache-valid-duration", p.CacheValidDuration))
+		}
+		d.Logger.Info("Applying overlay", fields...)
+		d.overlay[p.Dir] = p
+	}
+	return nil
+}
+
+// cacheValidDuration is how long a cached result for dir is trusted
+func (d *Drifter) cacheValidDuration(dir string) time.Duration {
+	if p, exists := d.overlay[dir]; exists && p.CacheValidDuration > 0 {
+		return p.CacheValidDuration
+	}
+	return d.CacheValidDuration
+}
@@ -0,0 +1,79 @@
+package drifter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func writeOverlay(t *testing.T, body string) string {
+	filename := filepath.Join(t.TempDir(), "overlay.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(body), 0o600))
+	return filename
+}
+
+func TestLoadOverlay(t *testing.T) {
+	overlay, err := LoadOverlay(writeOverlay(t, `projects:
+- dir: ./network/
+  ignored_workspaces: [scratch]
+  cache_valid_duration: 12h
+`))
+	require.NoError(t, err)
+	require.Equal(t, &Overlay{Projects: []OverlayProject{
+		{Dir: "network", IgnoredWorkspaces: []string{"scratch"}, CacheValidDuration: 12 * time.Hour},
+	}}, overlay)
+
+	_, err = LoadOverlay(writeOverlay(t, `projects:
+- dir: network
+- dir: ./network
+`))
+	require.ErrorContains(t, err, "more than one entry")
+
+	_, err = LoadOverlay(writeOverlay(t, `projects:
+- dir: network
+  ignore_workspaces: [scratch]
+`))
+	require.Error(t, err)
+}
+
+func TestDrifter_Overlay(t *testing.T) {
+	cfg, err := atlantis.ParseRepoConfig(`version: 3
+projects:
+- dir: network
+  workspace: default
+- dir: database
+  workspace: default
+`)
+	require.NoError(t, err)
+	n := newRecordingNotification(t)
+	d := &Drifter{
+		Logger:             zaptest.NewLogger(t),
+		Notification:       n,
+		CacheValidDuration: time.Hour,
+		OverlayPath: writeOverlay(t, `projects:
+- dir: network
+  ignored_workspaces: [scratch]
+  cache_valid_duration: 12h
+- dir: gone
+  cache_valid_duration: 1m
+`),
+		WorkspaceLister: &fakeWorkspaceLister{remote: map[string][]string{
+			"network":  {"default", "scratch"},
+			"database": {"default", "scratch"},
+		}},
+		ResultCache: processedcache.Noop{},
+	}
+	require.NoError(t, d.applyOverlay(cfg))
+	require.Equal(t, 12*time.Hour, d.cacheValidDuration("network"))
+	require.Equal(t, time.Hour, d.cacheValidDuration("database"))
+	require.Equal(t, time.Hour, d.cacheValidDuration("gone"))
+	require.NoError(t, d.FindExtraWorkspaces(context.Background(), atlantis.ConfigToWorkspaces(cfg)))
+	require.Equal(t, []string{"database#scratch"}, n.extra)
+}
@@ -0,0 +1,82 @@
+package drifter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ParallelRunsAuto picks ParallelRuns from the CPUs available to the process
+const ParallelRunsAuto = "auto"
+
+// maxAutoParallelRuns caps the parallelism picked by AutoParallelRuns, so a large runner does not flood Atlantis
+const maxAutoParallelRuns = 16
+
+// autoRunsPerCPU is how many checks run per CPU. Most of a check is spent waiting on Atlantis, not on local CPU.
+const autoRunsPerCPU = 2
+
+// ParseParallelRuns parses a ParallelRuns setting: a positive number, or ParallelRunsAuto. Empty means 1.
+func ParseParallelRuns(s string) (int, error) {
+	switch s {
+	case "":
+		return 1, nil
+	case ParallelRunsAuto:
+		return AutoParallelRuns(), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("parallel runs must be a positive number or %s: %s", ParallelRunsAuto, s)
+	}
+	return n, nil
+}
+
+// AutoParallelRuns returns a parallelism suited to the CPUs available to the process, including cgroup CPU limits
+// when running in a container
+func AutoParallelRuns() int {
+	return autoParallelRuns(runtime.NumCPU(), "/sys/fs/cgroup")
+}
+
+func autoParallelRuns(cpus int, cgroupRoot string) int {
+	if limit, ok := cgroupCPULimit(cgroupRoot); ok && limit < cpus {
+		cpus = limit
+	}
+	return min(max(cpus*autoRunsPerCPU, 1), maxAutoParallelRuns)
+}
+
+// cgroupCPULimit returns the CPU quota of the cgroup mounted at root, rounded up to whole CPUs. It reads cgroup v2's
+// cpu.max and falls back to cgroup v1's cpu.cfs_quota_us. ok is false if there is no limit or it can't be read.
+func cgroupCPULimit(root string) (int, bool) {
+	if b, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		quota, period, found := strings.Cut(strings.TrimSpace(string(b)), " ")
+		if !found {
+			return 0, false
+		}
+		return cpuQuota(quota, period)
+	}
+	quota, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+// cpuQuota converts a cgroup quota and period, both in microseconds, to whole CPUs. "max" and -1 mean no limit.
+func cpuQuota(quota string, period string) (int, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return int(math.Ceil(q / p)), true
+}
@@ -0,0 +1,42 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoParallelRuns(t *testing.T) {
+	noCgroup := t.TempDir()
+	require.Equal(t, 4, autoParallelRuns(2, noCgroup))
+	require.Equal(t, maxAutoParallelRuns, autoParallelRuns(32, noCgroup))
+
+	v2 := t.TempDir()
+	writeTestFile(t, v2, "cpu.max", "150000 100000\n")
+	require.Equal(t, 4, autoParallelRuns(32, v2))
+	writeTestFile(t, v2, "cpu.max", "max 100000\n")
+	require.Equal(t, maxAutoParallelRuns, autoParallelRuns(32, v2))
+
+	v1 := t.TempDir()
+	writeTestFile(t, v1, "cpu/cpu.cfs_quota_us", "300000\n")
+	writeTestFile(t, v1, "cpu/cpu.cfs_period_us", "100000\n")
+	require.Equal(t, 6, autoParallelRuns(32, v1))
+	writeTestFile(t, v1, "cpu/cpu.cfs_quota_us", "-1\n")
+	require.Equal(t, maxAutoParallelRuns, autoParallelRuns(32, v1))
+}
+
+func TestParseParallelRuns(t *testing.T) {
+	n, err := ParseParallelRuns("")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = ParseParallelRuns("8")
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+	n, err = ParseParallelRuns(ParallelRunsAuto)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, 1)
+	_, err = ParseParallelRuns("0")
+	require.Error(t, err)
+	_, err = ParseParallelRuns("lots")
+	require.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package drifter
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// PendingApplyFinder finds open pull requests that change a directory. Drift in such a directory is usually the
+// pull request waiting to be applied, not an unexpected change.
+type PendingApplyFinder interface {
+	PullRequestsTouching(ctx context.Context, dir string) ([]string, error)
+}
+
+// pendingApplies returns the open pull requests changing dir. A failed lookup is logged and treated as none, so the
+// drift is still alerted.
+func (d *Drifter) pendingApplies(ctx context.Context, dir string) []string {
+	if d.PendingApplies == nil {
+		return nil
+	}
+	prs, err := d.PendingApplies.PullRequestsTouching(ctx, dir)
+	if err != nil {
+		d.Logger.Warn("Unable to look up open pull requests, alerting drift", zap.String("dir", dir), zap.Error(err))
+		return nil
+	}
+	return prs
+}
@@ -0,0 +1,33 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDrifter_pendingApplies(t *testing.T) {
+	d := &Drifter{Logger: zaptest.NewLogger(t)}
+	require.Nil(t, d.pendingApplies(context.Background(), "pending"))
+
+	d.PendingApplies = fakePendingApplies{"pending": {"https://github.com/owner/repo/pull/7", "https://github.com/owner/repo/pull/9"}}
+	require.Equal(t, []string{"https://github.com/owner/repo/pull/7", "https://github.com/owner/repo/pull/9"}, d.pendingApplies(context.Background(), "pending"))
+	require.Nil(t, d.pendingApplies(context.Background(), "drifted"))
+	require.Nil(t, d.pendingApplies(context.Background(), "broken"))
+}
+
+func TestDrifter_notifyPlanDriftWithPendingApply(t *testing.T) {
+	n := newRecordingNotification(t)
+	d := &Drifter{
+		Logger:         zaptest.NewLogger(t),
+		Notification:   n,
+		PendingApplies: fakePendingApplies{"pending": {"https://github.com/owner/repo/pull/7"}},
+	}
+	for _, dir := range []string{"pending", "drifted", "broken"} {
+		require.NoError(t, d.notifyPlanDrift(context.Background(), dir, "default", "cliffnote"))
+	}
+	require.Equal(t, []string{"pending#default=https://github.com/owner/repo/pull/7"}, n.pending)
+	require.Equal(t, []string{"drifted#default", "broken#default"}, n.drifts)
+}
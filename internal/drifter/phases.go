@@ -0,0 +1,63 @@
+package drifter
+
+import "fmt"
+
+// Phase is a group of checks a run can do on its own, so cheap checks can run more often than expensive ones
+type Phase string
+
+const (
+	// PhaseDrift plans every workspace through Atlantis to find drift
+	PhaseDrift Phase = "drift"
+	// PhaseWorkspaces audits remote workspaces for extra and missing ones, along with unmanaged directories and
+	// orphaned state when those checks are enabled
+	PhaseWorkspaces Phase = "workspaces"
+)
+
+// AllPhases are the phases a run does when Phases is empty, in the order they run
+var AllPhases = []Phase{PhaseDrift, PhaseWorkspaces}
+
+// ParsePhases parses phase names. No names means every phase.
+func ParsePhases(names []string) ([]Phase, error) {
+	ret := make([]Phase, 0, len(names))
+	for _, name := range names {
+		switch p := Phase(name); p {
+		case PhaseDrift, PhaseWorkspaces:
+			ret = append(ret, p)
+		default:
+			return nil, fmt.Errorf("unknown phase: %s", name)
+		}
+	}
+	return ret, nil
+}
+
+func (d *Drifter) runsPhase(phase Phase) bool {
+	if len(d.Phases) == 0 {
+		return true
+	}
+	for _, p := range d.Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// auditsWorkspaces reports whether the run looks for extra and missing workspaces. Selecting PhaseWorkspaces in
+// Phases asks for the audit, so it overrides SkipWorkspaceCheck, which otherwise turns it off.
+func (d *Drifter) auditsWorkspaces() bool {
+	if !d.runsPhase(PhaseWorkspaces) {
+		return false
+	}
+	return len(d.Phases) > 0 || !d.SkipWorkspaceCheck
+}
+
+// phaseNames returns the names of the phases the run does, in the order they run
+func (d *Drifter) phaseNames() []string {
+	ret := make([]string, 0, len(AllPhases))
+	for _, p := range AllPhases {
+		if d.runsPhase(p) {
+			ret = append(ret, string(p))
+		}
+	}
+	return ret
+}
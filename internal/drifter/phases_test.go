@@ -0,0 +1,31 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePhases(t *testing.T) {
+	phases, err := ParsePhases(nil)
+	require.NoError(t, err)
+	require.Empty(t, phases)
+	require.Equal(t, []string{"drift", "workspaces"}, (&Drifter{Phases: phases}).phaseNames())
+
+	phases, err = ParsePhases([]string{"workspaces"})
+	require.NoError(t, err)
+	d := &Drifter{Phases: phases}
+	require.True(t, d.runsPhase(PhaseWorkspaces))
+	require.False(t, d.runsPhase(PhaseDrift))
+	require.Equal(t, []string{"workspaces"}, d.phaseNames())
+
+	// Asking for the workspaces phase overrides SkipWorkspaceCheck
+	d.SkipWorkspaceCheck = true
+	require.True(t, d.auditsWorkspaces())
+	require.False(t, (&Drifter{SkipWorkspaceCheck: true}).auditsWorkspaces())
+	require.True(t, (&Drifter{}).auditsWorkspaces())
+	require.False(t, (&Drifter{Phases: []Phase{PhaseDrift}}).auditsWorkspaces())
+
+	_, err = ParsePhases([]string{"plan"})
+	require.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package drifter
+
+import "github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+
+// ProjectTarget is what a plan request names to find a project in Atlantis
+type ProjectTarget struct {
+	// ProjectName, if set, is planned instead of Dir and Workspace
+	ProjectName string
+	Dir         string
+	Workspace   string
+	// CommentArgs are plan comment flags sent with the request
+	CommentArgs []string
+}
+
+// ProjectResolver maps a directory and workspace of the atlantis config to the project a plan request names, for
+// repositories whose Atlantis projects do not line up with their directories. Results are still cached, reported and
+// notified under the directory and workspace of the config.
+type ProjectResolver interface {
+	// ResolveProject returns the target to plan for dir and workspace. def is the target planned without a resolver.
+	ResolveProject(dir string, workspace string, def ProjectTarget) ProjectTarget
+}
+
+// ProjectResolverFunc adapts a function to a ProjectResolver
+type ProjectResolverFunc func(dir string, workspace string, def ProjectTarget) ProjectTarget
+
+func (f ProjectResolverFunc) ResolveProject(dir string, workspace string, def ProjectTarget) ProjectTarget {
+	return f(dir, workspace, def)
+}
+
+// DefaultProjectResolver plans the default target: the directory and workspace, or the project name if
+// PlanByProjectName is set, with CommentArgs
+type DefaultProjectResolver struct{}
+
+func (DefaultProjectResolver) ResolveProject(_ string, _ string, def ProjectTarget) ProjectTarget {
+	return def
+}
+
+// planSummaryRequest returns the request planning dir and workspace at ref
+func (d *Drifter) planSummaryRequest(dir string, workspace string, ref string) *atlantis.PlanSummaryRequest {
+	target := ProjectTarget{
+		ProjectName: d.projectName(dir, workspace),
+		Dir:         dir,
+		Workspace:   workspace,
+		CommentArgs: d.CommentArgs,
+	}
+	if d.ProjectResolver != nil {
+		target = d.ProjectResolver.ResolveProject(dir, workspace, target)
+	}
+	return &atlantis.PlanSummaryRequest{
+		Repo:        d.planRepo(),
+		Ref:         ref,
+		Type:        "Github",
+		Dir:         target.Dir,
+		Workspace:   target.Workspace,
+		ProjectName: target.ProjectName,
+		CommentArgs: target.CommentArgs,
+	}
+}
@@ -0,0 +1,53 @@
+package drifter
+
+import (
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultProjectResolver(t *testing.T) {
+	def := ProjectTarget{ProjectName: "network", Dir: "network", Workspace: "default", CommentArgs: []string{"-p", "x"}}
+	require.Equal(t, def, DefaultProjectResolver{}.ResolveProject("network", "default", def))
+}
+
+func TestDrifter_planSummaryRequestResolvesFromDefaultTarget(t *testing.T) {
+	cfg, err := atlantis.ParseRepoConfig(`version: 3
+projects:
+- name: network
+  dir: network
+  workspace: default
+`)
+	require.NoError(t, err)
+	var got []ProjectTarget
+	d := &Drifter{
+		Repo:              "org/repo",
+		CommentArgs:       []string{"-p", "x"},
+		PlanByProjectName: true,
+		atlantisConfig:    cfg,
+		ProjectResolver: ProjectResolverFunc(func(dir string, workspace string, def ProjectTarget) ProjectTarget {
+			got = append(got, def)
+			return ProjectTarget{ProjectName: "resolved-" + workspace, Dir: dir}
+		}),
+	}
+	require.Equal(t, &atlantis.PlanSummaryRequest{
+		Repo:        "org/repo",
+		Ref:         "main",
+		Type:        "Github",
+		Dir:         "network",
+		ProjectName: "resolved-default",
+	}, d.planSummaryRequest("network", "default", "main"))
+	require.Equal(t, []ProjectTarget{{ProjectName: "network", Dir: "network", Workspace: "default", CommentArgs: []string{"-p", "x"}}}, got)
+
+	d.ProjectResolver = DefaultProjectResolver{}
+	require.Equal(t, &atlantis.PlanSummaryRequest{
+		Repo:        "org/repo",
+		Ref:         "main",
+		Type:        "Github",
+		Dir:         "network",
+		Workspace:   "default",
+		ProjectName: "network",
+		CommentArgs: []string{"-p", "x"},
+	}, d.planSummaryRequest("network", "default", "main"))
+}
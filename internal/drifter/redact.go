@@ -0,0 +1,77 @@
+package drifter
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// redactedText replaces every secret found in plan output
+const redactedText = "[REDACTED]"
+
+// minTFVarSecretLength is the shortest TF_VAR_ value redacted. Shorter values, like "dev" or "true", are too common in
+// plan output to be secrets worth hiding.
+const minTFVarSecretLength = 8
+
+// DefaultSecretPatterns match obvious secrets that slipped past `sensitive = true`. They are always redacted.
+var DefaultSecretPatterns = []*regexp.Regexp{
+	// AWS access key IDs
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// AWS secret access keys, when labelled as such
+	regexp.MustCompile(`(?i)(aws_secret_access_key|secret_access_key|secret_key)(["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`),
+	// Bearer tokens, as in Authorization headers
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+	// GitHub tokens
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+	// PEM private keys
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+type redactor struct {
+	patterns []*regexp.Regexp
+	values   *strings.Replacer
+}
+
+func newRedactor(patterns []*regexp.Regexp, environ []string, redactTFVars bool) *redactor {
+	r := &redactor{patterns: append(append([]*regexp.Regexp{}, DefaultSecretPatterns...), patterns...)}
+	if !redactTFVars {
+		return r
+	}
+	var pairs []string
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "TF_VAR_") && len(value) >= minTFVarSecretLength {
+			pairs = append(pairs, value, redactedText)
+		}
+	}
+	if len(pairs) > 0 {
+		r.values = strings.NewReplacer(pairs...)
+	}
+	return r
+}
+
+// redact replaces secrets in s. Text matched by a pattern's capture groups, like a "Bearer " prefix, is kept so the
+// redacted text still reads naturally.
+func (r *redactor) redact(s string) string {
+	if r.values != nil {
+		s = r.values.Replace(s)
+	}
+	for _, p := range r.patterns {
+		s = p.ReplaceAllStringFunc(s, func(match string) string {
+			groups := p.FindStringSubmatch(match)
+			if len(groups) < 2 {
+				return redactedText
+			}
+			return strings.Join(groups[1:], "") + redactedText
+		})
+	}
+	return s
+}
+
+// redact removes secrets from plan output before it is notified, stored in PlanStore or cached
+func (d *Drifter) redact(s string) string {
+	d.redactorOnce.Do(func() {
+		d.redactor = newRedactor(d.SecretPatterns, os.Environ(), d.RedactTFVarValues)
+	})
+	return d.redactor.redact(s)
+}
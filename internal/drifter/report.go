@@ -0,0 +1,149 @@
+package drifter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cresta/pipe"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// DriftReport is the outcome of a run, as handed to PostRunHook
+type DriftReport struct {
+	Repo                string              `json:"repo"`
+	Ref                 string              `json:"ref,omitempty"`
+	WorkspacesDrifted   int32               `json:"workspaces_drifted"`
+	WorkspacesUndrifted int32               `json:"workspaces_undrifted"`
+	TotalWorkspaces     int32               `json:"total_workspaces"`
+	Phases              []string            `json:"phases"`
+	Results             []DriftReportResult `json:"results"`
+	WorkspaceAudits     []WorkspaceAudit    `json:"workspace_audits,omitempty"`
+	// DriftedOnly is set if Results and WorkspaceAudits only have problems, rather than everything checked
+	DriftedOnly bool `json:"drifted_only,omitempty"`
+}
+
+// DriftReportResult is a DriftResult with its error as text, so it can be encoded
+type DriftReportResult struct {
+	Dir          string `json:"dir"`
+	Workspace    string `json:"workspace"`
+	Ref          string `json:"ref,omitempty"`
+	Drift        bool   `json:"drift"`
+	Locked       bool   `json:"locked,omitempty"`
+	NeverPlanned bool   `json:"never_planned,omitempty"`
+	Cliffnote    string `json:"cliffnote,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// Source is "fresh" for a workspace planned by the run, or the cache backend a skipped workspace's result came from
+	Source string `json:"source,omitempty"`
+}
+
+// Report returns the results of the run so far, sorted by directory and workspace, including comparison refs and the
+// cached results of skipped workspaces. With ReportDriftedOnly it only has the problems.
+func (d *Drifter) Report() DriftReport {
+	d.mu.Lock()
+	results := make([]DriftResult, 0, len(d.results)+len(d.cachedResults))
+	results = append(results, d.results...)
+	results = append(results, d.cachedResults...)
+	d.mu.Unlock()
+	sortResults(results)
+	ret := DriftReport{
+		Repo:                d.Repo,
+		Ref:                 d.Ref,
+		WorkspacesDrifted:   atomic.LoadInt32(&d.DriftedWorkspaceCount),
+		WorkspacesUndrifted: atomic.LoadInt32(&d.UndriftedWorkspaceCount),
+		TotalWorkspaces:     atomic.LoadInt32(&d.TotalWorkspacesCount),
+		Phases:              d.phaseNames(),
+		Results:             make([]DriftReportResult, 0, len(results)),
+		WorkspaceAudits:     d.WorkspaceAudits(),
+		DriftedOnly:         d.ReportDriftedOnly,
+	}
+	if d.ReportDriftedOnly {
+		audits := ret.WorkspaceAudits[:0]
+		for _, a := range ret.WorkspaceAudits {
+			if len(a.Extra) > 0 || len(a.Missing) > 0 {
+				audits = append(audits, a)
+			}
+		}
+		ret.WorkspaceAudits = audits
+	}
+	for _, r := range results {
+		if d.ReportDriftedOnly && !r.Drift && r.Err == nil {
+			continue
+		}
+		rr := DriftReportResult{
+			Dir:          r.Dir,
+			Workspace:    r.Workspace,
+			Ref:          r.Ref,
+			Drift:        r.Drift,
+			Locked:       r.Locked,
+			NeverPlanned: r.NeverPlanned,
+			Cliffnote:    r.Cliffnote,
+			Source:       string(r.Source),
+		}
+		if r.Err != nil {
+			rr.Error = r.Err.Error()
+		}
+		ret.Results = append(ret.Results, rr)
+	}
+	return ret
+}
+
+// persistReport stores the report of the run in ResultCache, keyed by RunID or, without one, by when the run finished
+func (d *Drifter) persistReport(ctx context.Context) error {
+	cache, ok := d.ResultCache.(processedcache.ReportCache)
+	if !ok {
+		return fmt.Errorf("result cache %T cannot keep run reports", d.ResultCache)
+	}
+	body, err := json.Marshal(d.Report())
+	if err != nil {
+		return fmt.Errorf("failed to marshal drift report: %w", err)
+	}
+	now := d.now()
+	runID := d.RunID
+	if runID == "" {
+		runID = now.UTC().Format(time.RFC3339Nano)
+	}
+	if err := cache.StoreRunReport(ctx, &processedcache.ConsiderRunReport{Repo: d.Repo, RunID: runID}, &processedcache.RunReportValue{
+		RunID:  runID,
+		When:   now,
+		Report: string(body),
+	}); err != nil {
+		return fmt.Errorf("failed to store run report: %w", err)
+	}
+	return nil
+}
+
+// runPostRunHook hands the report to PostRunHook. A failing hook is logged, and only fails the run if
+// FailOnPostRunHookError is set.
+func (d *Drifter) runPostRunHook(ctx context.Context) error {
+	if d.PostRunHook == nil {
+		return nil
+	}
+	if err := d.PostRunHook(ctx, d.Report()); err != nil {
+		d.Logger.Error("Post-run hook failed", zap.Error(err))
+		if d.FailOnPostRunHookError {
+			return fmt.Errorf("post-run hook failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// CommandHook returns a PostRunHook that runs a shell command with the report as JSON on stdin
+func CommandHook(command string) func(ctx context.Context, report DriftReport) error {
+	return func(ctx context.Context, report DriftReport) error {
+		body, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to marshal drift report: %w", err)
+		}
+		var stdout, stderr bytes.Buffer
+		if err := pipe.NewPiped("sh", "-c", command).Execute(ctx, bytes.NewReader(body), &stdout, &stderr); err != nil {
+			return fmt.Errorf("post-run command failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+		}
+		return nil
+	}
+}
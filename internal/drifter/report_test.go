@@ -0,0 +1,146 @@
+package drifter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCommandHook(t *testing.T) {
+	d := &Drifter{Repo: "org/repo", DriftedWorkspaceCount: 1}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default", Err: errors.New("bad plan")})
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true, Cliffnote: "Plan: 1 to add"})
+
+	filename := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, CommandHook("cat > "+filename)(ctx, d.Report()))
+	body, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	var report DriftReport
+	require.NoError(t, json.Unmarshal(body, &report))
+	require.Equal(t, DriftReport{
+		Repo:              "org/repo",
+		WorkspacesDrifted: 1,
+		Phases:            []string{"drift", "workspaces"},
+		Results: []DriftReportResult{
+			{Dir: "a", Workspace: "default", Drift: true, Cliffnote: "Plan: 1 to add"},
+			{Dir: "b", Workspace: "default", Error: "bad plan"},
+		},
+	}, report)
+
+	err = CommandHook("echo nope >&2; exit 3")(ctx, d.Report())
+	require.ErrorContains(t, err, "nope")
+}
+
+func TestDrifter_ReportDriftedOnly(t *testing.T) {
+	d := &Drifter{Repo: "org/repo", ReportDriftedOnly: true}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "a", Workspace: "default", Drift: true, Cliffnote: "Plan: 1 to add"})
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default"})
+	d.reportResult(ctx, DriftResult{Dir: "c", Workspace: "default", Err: errors.New("bad plan")})
+	d.workspaceAudits = []WorkspaceAudit{{Dir: "a"}, {Dir: "b", Extra: []string{"old"}}}
+
+	report := d.Report()
+	require.True(t, report.DriftedOnly)
+	require.Equal(t, []DriftReportResult{
+		{Dir: "a", Workspace: "default", Drift: true, Cliffnote: "Plan: 1 to add"},
+		{Dir: "c", Workspace: "default", Error: "bad plan"},
+	}, report.Results)
+	require.Equal(t, []WorkspaceAudit{{Dir: "b", Extra: []string{"old"}}}, report.WorkspaceAudits)
+
+	d.ReportDriftedOnly = false
+	report = d.Report()
+	require.False(t, report.DriftedOnly)
+	require.Len(t, report.Results, 3)
+	require.Len(t, report.WorkspaceAudits, 2)
+}
+
+func TestDrifter_ReportSource(t *testing.T) {
+	d := &Drifter{Repo: "org/repo"}
+	ctx := context.Background()
+	d.reportResult(ctx, DriftResult{Dir: "b", Workspace: "default", Drift: true, Source: processedcache.SourceFresh})
+	d.recordCachedResult("a", "default", &processedcache.DriftCheckValue{Drift: true, Source: processedcache.SourceDynamoDB})
+	d.addCachedResult("a", "default", "old-branch", &processedcache.DriftCheckValue{Error: "bad plan", Source: processedcache.SourceDynamoDB})
+
+	require.Equal(t, []DriftReportResult{
+		{Dir: "a", Workspace: "default", Drift: true, Source: "dynamodb"},
+		{Dir: "a", Workspace: "default", Ref: "old-branch", Error: "bad plan", Source: "dynamodb"},
+		{Dir: "b", Workspace: "default", Drift: true, Source: "fresh"},
+	}, d.Report().Results)
+	require.Equal(t, int32(1), d.CachedWorkspaceCount)
+}
+
+func TestDrifter_RunPostRunHook(t *testing.T) {
+	hookErr := errors.New("jenkins is down")
+	d := &Drifter{
+		Logger: zaptest.NewLogger(t),
+		PostRunHook: func(_ context.Context, _ DriftReport) error {
+			return hookErr
+		},
+	}
+	require.NoError(t, d.runPostRunHook(context.Background()))
+	d.FailOnPostRunHookError = true
+	require.ErrorIs(t, d.runPostRunHook(context.Background()), hookErr)
+
+	// A failing hook still lets the run send its summaries
+	n := &auditSummaryNotification{recordingNotification: newRecordingNotification(t)}
+	d.Notification = n
+	d.ExtraWorkspaceCount = 2
+	require.ErrorIs(t, d.notifyRunSummaries(context.Background(), time.Now()), hookErr)
+	require.Equal(t, []string{"2/0"}, n.audits)
+	require.Equal(t, []string{"0/0"}, n.summaries)
+}
+
+type auditSummaryNotification struct {
+	*recordingNotification
+	audits []string
+}
+
+func (n *auditSummaryNotification) WorkspaceAuditSummary(_ context.Context, extra int32, missing int32) error {
+	n.audits = append(n.audits, fmt.Sprintf("%d/%d", extra, missing))
+	return nil
+}
+
+type memoryReportCache struct {
+	processedcache.Noop
+	reports []*processedcache.RunReportValue
+}
+
+func (m *memoryReportCache) StoreRunReport(_ context.Context, _ *processedcache.ConsiderRunReport, value *processedcache.RunReportValue) error {
+	m.reports = append(m.reports, value)
+	return nil
+}
+
+func (m *memoryReportCache) ListRecentReports(_ context.Context, _ string, _ int) ([]*processedcache.RunReportValue, error) {
+	return m.reports, nil
+}
+
+func TestDrifter_PersistReport(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := &memoryReportCache{}
+	d := &Drifter{Repo: "org/repo", ResultCache: cache, Clock: NewFakeClock(now)}
+	d.reportResult(context.Background(), DriftResult{Dir: "a", Workspace: "default", Drift: true})
+	require.NoError(t, d.persistReport(context.Background()))
+	d.RunID = "1234"
+	require.NoError(t, d.persistReport(context.Background()))
+	require.Len(t, cache.reports, 2)
+	require.Equal(t, "2024-01-01T00:00:00Z", cache.reports[0].RunID)
+	require.Equal(t, "1234", cache.reports[1].RunID)
+	require.Equal(t, now, cache.reports[1].When)
+	var report DriftReport
+	require.NoError(t, json.Unmarshal([]byte(cache.reports[1].Report), &report))
+	require.Equal(t, "org/repo", report.Repo)
+	require.Len(t, report.Results, 1)
+
+	d.ResultCache = processedcache.Noop{}
+	require.Error(t, d.persistReport(context.Background()))
+}
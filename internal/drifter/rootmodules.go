@@ -0,0 +1,56 @@
+package drifter
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RootModuleRules tunes how terraform root modules are told apart from child modules when generating the atlantis
+// config and looking for unmanaged directories. The zero value only counts directories with an s3, gcs or azurerm
+// backend block.
+type RootModuleRules struct {
+	// Extended also counts directories with a terraform cloud block, a provider block or a *.auto.tfvars file, for
+	// root modules that get their backend from the CLI config
+	Extended bool
+	// ExcludePathSegments skips directories with any of these path segments, like examples or modules, even if they
+	// look like root modules
+	ExcludePathSegments []string
+}
+
+// DefaultRootModuleExcludes are the path segments usually holding child modules rather than root modules
+var DefaultRootModuleExcludes = []string{"examples", "modules"}
+
+var (
+	cloudBlockPattern    = regexp.MustCompile(`(?m)^\s*cloud\s*\{`)
+	providerBlockPattern = regexp.MustCompile(`(?m)^\s*provider\s+"[^"]+"\s*\{`)
+)
+
+// excluded returns true if relativeDir, relative to the repository root, has an excluded path segment
+func (r RootModuleRules) excluded(relativeDir string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(relativeDir), "/") {
+		for _, exclude := range r.ExcludePathSegments {
+			if segment == exclude {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// patterns returns the patterns that make a .tf file mark its directory as a root module, starting with backend
+func (r RootModuleRules) patterns(backend *regexp.Regexp) []*regexp.Regexp {
+	if !r.Extended {
+		return []*regexp.Regexp{backend}
+	}
+	return []*regexp.Regexp{backend, cloudBlockPattern, providerBlockPattern}
+}
+
+// hasAutoTFVars returns true if the rules are extended and dir has a *.auto.tfvars file
+func (r RootModuleRules) hasAutoTFVars(dir string) bool {
+	if !r.Extended {
+		return false
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.auto.tfvars"))
+	return err == nil && len(matches) > 0
+}
@@ -0,0 +1,94 @@
+package drifter
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/terraform"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDrifter_FindTerraformRootModules(t *testing.T) {
+	td := t.TempDir()
+	writeTestFile(t, td, "backend/main.tf", testS3Backend)
+	writeTestFile(t, td, "cloud/main.tf", "terraform {\n  cloud {\n    organization = \"example\"\n  }\n}\n")
+	writeTestFile(t, td, "provider/main.tf", "provider \"aws\" {\n  region = \"us-west-2\"\n}\n")
+	writeTestFile(t, td, "tfvars/main.tf", `resource "null_resource" "x" {}`)
+	writeTestFile(t, td, "tfvars/prod.auto.tfvars", `name = "prod"`)
+	writeTestFile(t, td, "child/main.tf", `resource "null_resource" "x" {}`)
+	writeTestFile(t, td, "modules/vpc/main.tf", testS3Backend)
+	writeTestFile(t, td, "vpc/examples/simple/main.tf", testS3Backend)
+	writeTestFile(t, td, "binary/main.tf", testS3Backend+"\x00\xff\xfe")
+
+	for _, tc := range []struct {
+		name  string
+		rules RootModuleRules
+		want  []string
+	}{
+		{
+			name: "default",
+			want: []string{"backend", "modules/vpc", "vpc/examples/simple"},
+		},
+		{
+			name:  "excludes",
+			rules: RootModuleRules{ExcludePathSegments: DefaultRootModuleExcludes},
+			want:  []string{"backend"},
+		},
+		{
+			name:  "extended",
+			rules: RootModuleRules{Extended: true, ExcludePathSegments: DefaultRootModuleExcludes},
+			want:  []string{"backend", "cloud", "provider", "tfvars"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Drifter{
+				Logger:          zaptest.NewLogger(t),
+				Terraform:       &terraform.Client{Directory: td, Logger: zaptest.NewLogger(t)},
+				RootModuleRules: tc.rules,
+			}
+			directories, err := d.findRootModules(td, backendPattern)
+			require.NoError(t, err)
+			got := make([]string, 0, len(directories))
+			for dir := range directories {
+				rel, err := filepath.Rel(td, dir)
+				require.NoError(t, err)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFileMatchesAny(t *testing.T) {
+	td := t.TempDir()
+	filename := filepath.Join(td, "main.tf")
+	// Pad so the backend block straddles the first chunk boundary
+	padding := strings.Repeat("#", scanChunkSize-4) + "\n"
+	require.NoError(t, os.WriteFile(filename, []byte(padding+`backend "s3" {}`), 0644))
+	matched, err := fileMatchesAny(filename, []*regexp.Regexp{backendPattern})
+	require.NoError(t, err)
+	require.True(t, matched)
+
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Repeat(padding, 4)), 0644))
+	matched, err = fileMatchesAny(filename, []*regexp.Regexp{backendPattern})
+	require.NoError(t, err)
+	require.False(t, matched)
+
+	// A multi-byte rune split by the chunk boundary is still text
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Repeat("#", scanChunkSize-1)+"é\n"+testS3Backend), 0644))
+	matched, err = fileMatchesAny(filename, []*regexp.Regexp{backendPattern})
+	require.NoError(t, err)
+	require.True(t, matched)
+
+	for _, content := range []string{"\xff" + testS3Backend, strings.Repeat("#", scanChunkSize-1) + "\xc3", "\x00"} {
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+		_, err = fileMatchesAny(filename, []*regexp.Regexp{backendPattern})
+		require.ErrorIs(t, err, errNotText)
+	}
+}
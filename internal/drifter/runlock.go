@@ -0,0 +1,80 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// RunLockBehavior controls what a run does when another run of the same repository holds the run lock
+type RunLockBehavior string
+
+const (
+	// RunLockSkip exits the run early without checking anything
+	RunLockSkip RunLockBehavior = "skip"
+	// RunLockWait polls until the lock is released or expires
+	RunLockWait RunLockBehavior = "wait"
+)
+
+func ParseRunLockBehavior(s string) (RunLockBehavior, error) {
+	switch b := RunLockBehavior(s); b {
+	case "":
+		return RunLockSkip, nil
+	case RunLockSkip, RunLockWait:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown run lock behavior: %s", s)
+}
+
+// runLockPollInterval is the average time between attempts to take a held lock. Each wait is jittered by up to half
+// of it, so runs that started together do not retry together.
+const runLockPollInterval = 30 * time.Second
+
+func (d *Drifter) runLockOwner() string {
+	if d.RunID != "" {
+		return d.RunID
+	}
+	host, _ := os.Hostname()
+	return host + "/" + strconv.Itoa(os.Getpid()) + "/" + strconv.FormatInt(d.now().UnixNano(), 10)
+}
+
+// acquireRunLock takes the run lock of the repository. It returns a function releasing the lock, or nil if the lock is
+// held by another run and RunLockBehavior is RunLockSkip.
+func (d *Drifter) acquireRunLock(ctx context.Context) (func(), error) {
+	key := &processedcache.ConsiderRunLock{Repo: d.Repo}
+	owner := d.runLockOwner()
+	for {
+		now := d.now()
+		acquired, err := d.ResultCache.AcquireRunLock(ctx, key, &processedcache.RunLockValue{
+			Owner:   owner,
+			Expires: now.Add(d.RunLockTTL),
+		}, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire run lock: %w", err)
+		}
+		if acquired {
+			return func() {
+				// The run's context may be canceled by now, but the lock should still be released
+				if err := d.ResultCache.ReleaseRunLock(context.WithoutCancel(ctx), key, owner); err != nil {
+					d.Logger.Warn("Failed to release run lock, it expires on its own", zap.Error(err))
+				}
+			}, nil
+		}
+		if d.RunLockBehavior != RunLockWait {
+			return nil, nil
+		}
+		wait := runLockPollInterval/2 + time.Duration(rand.Int63n(int64(runLockPollInterval)))
+		d.Logger.Info("Previous run still in progress, waiting for it to finish", zap.Duration("wait", wait))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting for previous run to finish: %w", ctx.Err())
+		}
+	}
+}
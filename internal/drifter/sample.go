@@ -0,0 +1,67 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+type sampleCandidate struct {
+	dir         string
+	workspace   string
+	lastChecked time.Time
+	rank        uint64
+}
+
+// sampleWorkspaces returns about SamplePercent of ws, preferring the workspaces checked least recently according to
+// ResultCache. Ties, like workspaces never checked, are broken by a hash seeded with seed, so different runs pick
+// different workspaces.
+func (d *Drifter) sampleWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces, seed int64) (atlantis.DirectoriesWithWorkspaces, error) {
+	if d.SamplePercent <= 0 || d.SamplePercent >= 100 {
+		return ws, nil
+	}
+	var candidates []sampleCandidate
+	for _, dir := range ws.SortedKeys() {
+		for _, workspace := range ws[dir] {
+			cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{
+				Repo:      d.Repo,
+				Dir:       dir,
+				Workspace: workspace,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
+			}
+			c := sampleCandidate{
+				dir:       dir,
+				workspace: workspace,
+			}
+			if cacheVal != nil {
+				c.lastChecked = cacheVal.When
+			}
+			h := fnv.New64a()
+			_, _ = fmt.Fprintf(h, "%d/%s/%s", seed, dir, workspace)
+			c.rank = h.Sum64()
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].lastChecked.Equal(candidates[j].lastChecked) {
+			return candidates[i].lastChecked.Before(candidates[j].lastChecked)
+		}
+		return candidates[i].rank < candidates[j].rank
+	})
+	n := int(math.Ceil(float64(len(candidates)) * float64(d.SamplePercent) / 100))
+	ret := make(atlantis.DirectoriesWithWorkspaces)
+	for _, c := range candidates[:n] {
+		ret[c.dir] = append(ret[c.dir], c.workspace)
+	}
+	d.Logger.Info("Sampled workspaces", zap.Int("sampled", n), zap.Int("total", len(candidates)), zap.Float32("percent", d.SamplePercent))
+	return ret, nil
+}
@@ -0,0 +1,74 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/atlantis"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type lastCheckedCache struct {
+	processedcache.Noop
+	checked map[string]time.Time
+}
+
+func (l *lastCheckedCache) GetDriftCheckResult(_ context.Context, key *processedcache.ConsiderDriftChecked) (*processedcache.DriftCheckValue, error) {
+	when, exists := l.checked[key.String()]
+	if !exists {
+		return nil, nil
+	}
+	return &processedcache.DriftCheckValue{When: when}, nil
+}
+
+func TestDrifter_SampleWorkspaces(t *testing.T) {
+	now := time.Now()
+	ws := atlantis.DirectoriesWithWorkspaces{
+		"a": {"default"},
+		"b": {"dev", "prod"},
+		"c": {"default"},
+	}
+	d := &Drifter{
+		Logger:        zaptest.NewLogger(t),
+		SamplePercent: 50,
+		ResultCache: &lastCheckedCache{checked: map[string]time.Time{
+			"a:default": now.Add(-time.Hour),
+			"b:dev":     now.Add(-3 * time.Hour),
+			"b:prod":    now.Add(-2 * time.Hour),
+			"c:default": now,
+		}},
+	}
+	sampled, err := d.sampleWorkspaces(context.Background(), ws, 1)
+	require.NoError(t, err)
+	require.Equal(t, atlantis.DirectoriesWithWorkspaces{"b": {"dev", "prod"}}, sampled)
+
+	d.SamplePercent = 0
+	all, err := d.sampleWorkspaces(context.Background(), ws, 1)
+	require.NoError(t, err)
+	require.Equal(t, ws, all)
+}
+
+func TestDrifter_SampleWorkspacesRotates(t *testing.T) {
+	ws := atlantis.DirectoriesWithWorkspaces{}
+	for _, dir := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		ws[dir] = []string{"default"}
+	}
+	d := &Drifter{
+		Logger:        zaptest.NewLogger(t),
+		SamplePercent: 25,
+		ResultCache:   processedcache.Noop{},
+	}
+	seen := map[string]struct{}{}
+	for seed := int64(0); seed < 20; seed++ {
+		sampled, err := d.sampleWorkspaces(context.Background(), ws, seed)
+		require.NoError(t, err)
+		require.Len(t, sampled, 2)
+		for dir := range sampled {
+			seen[dir] = struct{}{}
+		}
+	}
+	require.Greater(t, len(seen), 2)
+}
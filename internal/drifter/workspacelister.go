@@ -0,0 +1,38 @@
+package drifter
+
+import (
+	"context"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/terraform"
+)
+
+// WorkspaceLister initializes directories and lists the workspaces in their remote state
+type WorkspaceLister interface {
+	Init(ctx context.Context, dir string) error
+	ListWorkspaces(ctx context.Context, dir string) ([]string, error)
+}
+
+// backendConfigLister is implemented by a WorkspaceLister that can check a directory once per partial backend config
+type backendConfigLister interface {
+	BackendConfigs(dir string) ([]string, error)
+	InitBackendConfig(ctx context.Context, dir string, backendConfig string) error
+}
+
+// remoteBackendChecker is implemented by a WorkspaceLister that can tell whether a directory has a remote backend.
+// Listers that do not implement it are assumed to only list directories that do.
+type remoteBackendChecker interface {
+	HasRemoteBackend(dir string) (bool, error)
+}
+
+var (
+	_ WorkspaceLister      = &terraform.Client{}
+	_ backendConfigLister  = &terraform.Client{}
+	_ remoteBackendChecker = &terraform.Client{}
+)
+
+func (d *Drifter) workspaceLister() WorkspaceLister {
+	if d.WorkspaceLister != nil {
+		return d.WorkspaceLister
+	}
+	return d.Terraform
+}
@@ -0,0 +1,40 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// NewWithCABundle returns an HTTP client that trusts the PEM encoded certificates in caPEM in addition to the system
+// root CAs
+func NewWithCABundle(caPEM []byte) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in CA bundle")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// NewWithCAFile is NewWithCABundle reading the bundle from a file. An empty filename returns http.DefaultClient.
+func NewWithCAFile(filename string) (*http.Client, error) {
+	if filename == "" {
+		return http.DefaultClient, nil
+	}
+	caPEM, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %s: %w", filename, err)
+	}
+	return NewWithCABundle(caPEM)
+}
@@ -0,0 +1,312 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/retrybudget"
+)
+
+// AMQPChannel is the part of an *amqp.Channel used to publish events
+type AMQPChannel interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// AMQPNotification publishes every event as JSON to an exchange. The routing key is RoutingKeyPrefix followed by the
+// event kind, like "drift.plan_drift", so consumers can bind to the events they care about.
+type AMQPNotification struct {
+	Exchange         string
+	RoutingKeyPrefix string
+	// Dial opens a new channel. It is used to reconnect after a failed publish.
+	Dial func() (AMQPChannel, error)
+	// MaxRetries is how many times a failed publish is retried on a new channel
+	MaxRetries int
+	// RetryDelay is the delay before the first retry. It doubles for every retry, up to MaxRetryDelay if that is set.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// RetryBudget, if set, is shared with every other retrying caller in the run. Publishes stop being retried once it
+	// is exhausted.
+	RetryBudget *retrybudget.Budget
+	// DeadLetter, if set, keeps events that could not be published, so they can be published again later
+	DeadLetter *DeadLetter
+
+	mu      sync.Mutex
+	channel AMQPChannel
+}
+
+// NewAMQPNotification connects to the AMQP server at url. An empty url returns nil.
+func NewAMQPNotification(url string, exchange string, routingKeyPrefix string) (*AMQPNotification, error) {
+	if url == "" {
+		return nil, nil
+	}
+	ret := &AMQPNotification{
+		Exchange:         exchange,
+		RoutingKeyPrefix: routingKeyPrefix,
+		Dial: func() (AMQPChannel, error) {
+			return dialAMQPChannel(url)
+		},
+		MaxRetries:    3,
+		RetryDelay:    500 * time.Millisecond,
+		MaxRetryDelay: 10 * time.Second,
+	}
+	ch, err := ret.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to amqp server: %w", err)
+	}
+	ret.channel = ch
+	return ret, nil
+}
+
+// connectionChannel closes its connection along with the channel
+type connectionChannel struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func (c *connectionChannel) Close() error {
+	return errors.Join(c.Channel.Close(), c.conn.Close())
+}
+
+func dialAMQPChannel(url string) (AMQPChannel, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to open channel: %w", err), conn.Close())
+	}
+	return &connectionChannel{Channel: ch, conn: conn}, nil
+}
+
+type amqpEvent struct {
+	Kind                string             `json:"kind"`
+	Repo                string             `json:"repo,omitempty"`
+	Dir                 string             `json:"dir,omitempty"`
+	Workspace           string             `json:"workspace,omitempty"`
+	Ref                 string             `json:"ref,omitempty"`
+	StateKey            string             `json:"state_key,omitempty"`
+	ConfigPath          string             `json:"config_path,omitempty"`
+	Project             string             `json:"project,omitempty"`
+	Dirs                []string           `json:"dirs,omitempty"`
+	Phases              []string           `json:"phases,omitempty"`
+	PullRequests        []string           `json:"pull_requests,omitempty"`
+	Cliffnote           string             `json:"cliffnote,omitempty"`
+	Error               string             `json:"error,omitempty"`
+	Reason              string             `json:"reason,omitempty"`
+	Message             string             `json:"message,omitempty"`
+	WorkspacesDrifted   *int32             `json:"workspaces_drifted,omitempty"`
+	WorkspacesUndrifted *int32             `json:"workspaces_undrifted,omitempty"`
+	TotalWorkspaces     *int32             `json:"total_workspaces,omitempty"`
+	ExtraWorkspaces     *int32             `json:"extra_workspaces,omitempty"`
+	MissingWorkspaces   *int32             `json:"missing_workspaces,omitempty"`
+	CachedWorkspaces    *int32             `json:"cached_workspaces,omitempty"`
+	SuppressedDrifts    *int32             `json:"suppressed_drifts,omitempty"`
+	OldestCheck         *time.Time         `json:"oldest_check,omitempty"`
+	DurationSeconds     *float64           `json:"duration_seconds,omitempty"`
+	PhaseSeconds        map[string]float64 `json:"phase_seconds,omitempty"`
+}
+
+func (a *AMQPNotification) publish(ctx context.Context, event amqpEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal amqp event: %w", err)
+	}
+	if err := a.publishBody(ctx, event.Kind, body); err != nil {
+		return errors.Join(err, a.DeadLetter.Add(a.DeadLetterName(), body))
+	}
+	return nil
+}
+
+func (a *AMQPNotification) publishBody(ctx context.Context, kind string, body []byte) error {
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Type:         kind,
+		Body:         body,
+	}
+	var err error
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delay := a.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if a.channel == nil && a.Dial == nil {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("no amqp channel to publish %s", kind)
+		}
+		if a.channel == nil {
+			if a.channel, err = a.Dial(); err != nil {
+				a.channel = nil
+				err = fmt.Errorf("failed to reconnect to amqp server: %w", err)
+			}
+		}
+		if a.channel != nil {
+			err = a.channel.PublishWithContext(ctx, a.Exchange, a.RoutingKeyPrefix+kind, false, false, msg)
+			if err == nil {
+				return nil
+			}
+			_ = a.channel.Close()
+			a.channel = nil
+			err = fmt.Errorf("failed to publish amqp event %s: %w", kind, err)
+		}
+		if attempt >= a.MaxRetries || ctx.Err() != nil || !a.RetryBudget.Take() {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+		delay *= 2
+		if a.MaxRetryDelay > 0 && delay > a.MaxRetryDelay {
+			delay = a.MaxRetryDelay
+		}
+	}
+}
+
+// DeadLetterName implements DeadLetterSender
+func (a *AMQPNotification) DeadLetterName() string {
+	return "amqp"
+}
+
+// SendDeadLetter implements DeadLetterSender, publishing the event again with the routing key of its kind
+func (a *AMQPNotification) SendDeadLetter(ctx context.Context, payload []byte) error {
+	var event amqpEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("failed to parse amqp dead letter: %w", err)
+	}
+	return a.publishBody(ctx, event.Kind, payload)
+}
+
+// Close closes the channel and its connection, if one is open
+func (a *AMQPNotification) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil {
+		return nil
+	}
+	err := a.channel.Close()
+	a.channel = nil
+	return err
+}
+
+func (a *AMQPNotification) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	return a.publish(ctx, amqpEvent{Kind: "extra_workspace", Dir: dir, Workspace: workspace})
+}
+
+func (a *AMQPNotification) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	return a.publish(ctx, amqpEvent{Kind: "missing_workspace", Dir: dir, Workspace: workspace})
+}
+
+func (a *AMQPNotification) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	return a.publish(ctx, amqpEvent{Kind: "plan_drift", Dir: dir, Workspace: workspace, Cliffnote: cliffnote})
+}
+
+func (a *AMQPNotification) DriftResolved(ctx context.Context, dir string, workspace string) error {
+	return a.publish(ctx, amqpEvent{Kind: "drift_resolved", Dir: dir, Workspace: workspace})
+}
+
+func (a *AMQPNotification) RefPlanDrift(ctx context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	return a.publish(ctx, amqpEvent{Kind: "ref_plan_drift", Ref: ref, Dir: dir, Workspace: workspace, Cliffnote: cliffnote})
+}
+
+func (a *AMQPNotification) PlanError(ctx context.Context, dir string, workspace string, planError string) error {
+	return a.publish(ctx, amqpEvent{Kind: "plan_error", Dir: dir, Workspace: workspace, Error: planError})
+}
+
+func (a *AMQPNotification) WorkspaceDriftSummary(ctx context.Context, workspacesDrifted int32, workspacesUndrifted int32, totalWorkspaces int32) error {
+	return a.publish(ctx, amqpEvent{Kind: "drift_summary", WorkspacesDrifted: &workspacesDrifted, WorkspacesUndrifted: &workspacesUndrifted, TotalWorkspaces: &totalWorkspaces})
+}
+
+func (a *AMQPNotification) WorkspaceAuditSummary(ctx context.Context, extraWorkspaces int32, missingWorkspaces int32) error {
+	return a.publish(ctx, amqpEvent{Kind: "audit_summary", ExtraWorkspaces: &extraWorkspaces, MissingWorkspaces: &missingWorkspaces})
+}
+
+func (a *AMQPNotification) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
+	return a.publish(ctx, amqpEvent{Kind: "temporary_error", Dir: dir, Workspace: workspace, Error: err.Error()})
+}
+
+func (a *AMQPNotification) UnmanagedDirectory(ctx context.Context, dir string) error {
+	return a.publish(ctx, amqpEvent{Kind: "unmanaged_directory", Dir: dir})
+}
+
+func (a *AMQPNotification) CachedResultsWarning(ctx context.Context, cachedWorkspaces int32, totalWorkspaces int32, oldestCheck time.Time) error {
+	return a.publish(ctx, amqpEvent{Kind: "cached_results_warning", CachedWorkspaces: &cachedWorkspaces, TotalWorkspaces: &totalWorkspaces, OldestCheck: &oldestCheck})
+}
+
+func (a *AMQPNotification) DriftNotificationsSuppressed(ctx context.Context, reason string, suppressedDrifts int32) error {
+	return a.publish(ctx, amqpEvent{Kind: "drift_suppressed", Reason: reason, SuppressedDrifts: &suppressedDrifts})
+}
+
+func (a *AMQPNotification) PlanLocked(ctx context.Context, dir string, workspace string) error {
+	return a.publish(ctx, amqpEvent{Kind: "plan_locked", Dir: dir, Workspace: workspace})
+}
+
+func (a *AMQPNotification) NeverPlanned(ctx context.Context, dir string, workspace string) error {
+	return a.publish(ctx, amqpEvent{Kind: "never_planned", Dir: dir, Workspace: workspace})
+}
+
+func (a *AMQPNotification) OrphanedState(ctx context.Context, dir string, stateKey string) error {
+	return a.publish(ctx, amqpEvent{Kind: "orphaned_state", Dir: dir, StateKey: stateKey})
+}
+
+func (a *AMQPNotification) PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error {
+	return a.publish(ctx, amqpEvent{Kind: "pending_apply", Dir: dir, Workspace: workspace, PullRequests: pullRequests})
+}
+
+func (a *AMQPNotification) NoProjectsFound(ctx context.Context, repo string, configPath string) error {
+	return a.publish(ctx, amqpEvent{Kind: "no_projects", Repo: repo, ConfigPath: configPath})
+}
+
+func (a *AMQPNotification) DuplicateProject(ctx context.Context, name string, dirs []string) error {
+	return a.publish(ctx, amqpEvent{Kind: "duplicate_project", Project: name, Dirs: dirs})
+}
+
+func (a *AMQPNotification) RunStarted(ctx context.Context, repo string, ref string) error {
+	return a.publish(ctx, amqpEvent{Kind: "run_started", Repo: repo, Ref: ref})
+}
+
+func (a *AMQPNotification) RunFinished(ctx context.Context, summary RunSummary) error {
+	seconds := summary.Duration.Seconds()
+	return a.publish(ctx, amqpEvent{
+		Kind:                "run_finished",
+		Repo:                summary.Repo,
+		Ref:                 summary.Ref,
+		Error:               summary.Error,
+		DurationSeconds:     &seconds,
+		WorkspacesDrifted:   &summary.WorkspacesDrifted,
+		WorkspacesUndrifted: &summary.WorkspacesUndrifted,
+		TotalWorkspaces:     &summary.TotalWorkspaces,
+		Phases:              summary.Phases,
+	})
+}
+
+func (a *AMQPNotification) RunTimings(ctx context.Context, total time.Duration, phases []PhaseTiming) error {
+	seconds := total.Seconds()
+	phaseSeconds := make(map[string]float64, len(phases))
+	for _, p := range phases {
+		phaseSeconds[p.Phase] = p.Duration.Seconds()
+	}
+	return a.publish(ctx, amqpEvent{Kind: "run_timings", DurationSeconds: &seconds, PhaseSeconds: phaseSeconds})
+}
+
+// TestMessage publishes message without keeping it in the dead letter file, so a broken server fails right away
+func (a *AMQPNotification) TestMessage(ctx context.Context, message string) error {
+	body, err := json.Marshal(amqpEvent{Kind: "notification_test", Message: message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal amqp event: %w", err)
+	}
+	return a.publishBody(ctx, "notification_test", body)
+}
+
+var _ Notification = &AMQPNotification{}
+var _ DeadLetterSender = &AMQPNotification{}
@@ -0,0 +1,124 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAMQPChannel struct {
+	fail      bool
+	closed    bool
+	published map[string][]byte
+}
+
+func (f *fakeAMQPChannel) PublishWithContext(_ context.Context, _ string, key string, _ bool, _ bool, msg amqp.Publishing) error {
+	if f.fail {
+		return errors.New("channel closed")
+	}
+	f.published[key] = msg.Body
+	return nil
+}
+
+func (f *fakeAMQPChannel) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestAMQPNotification_ReconnectsOnPublishFailure(t *testing.T) {
+	broken := &fakeAMQPChannel{fail: true}
+	working := &fakeAMQPChannel{published: map[string][]byte{}}
+	dials := 0
+	a := &AMQPNotification{
+		Exchange:         "drift",
+		RoutingKeyPrefix: "drift.",
+		Dial: func() (AMQPChannel, error) {
+			dials++
+			return working, nil
+		},
+		MaxRetries: 1,
+		channel:    broken,
+	}
+	require.NoError(t, a.PlanDrift(context.Background(), "dir", "default", "Plan: 1 to add"))
+	require.True(t, broken.closed)
+	require.Equal(t, 1, dials)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(working.published["drift.plan_drift"], &event))
+	require.Equal(t, map[string]interface{}{"kind": "plan_drift", "dir": "dir", "workspace": "default", "cliffnote": "Plan: 1 to add"}, event)
+}
+
+func TestAMQPNotification_BoundedRetries(t *testing.T) {
+	dials := 0
+	a := &AMQPNotification{
+		Dial: func() (AMQPChannel, error) {
+			dials++
+			return &fakeAMQPChannel{fail: true}, nil
+		},
+		MaxRetries: 2,
+	}
+	require.Error(t, a.UnmanagedDirectory(context.Background(), "dir"))
+	require.Equal(t, 3, dials)
+}
+
+func TestAMQPNotification_RetryDelay(t *testing.T) {
+	a := &AMQPNotification{
+		Dial: func() (AMQPChannel, error) {
+			return nil, errors.New("connection refused")
+		},
+		MaxRetries:    5,
+		RetryDelay:    10 * time.Millisecond,
+		MaxRetryDelay: 20 * time.Millisecond,
+	}
+	start := time.Now()
+	require.Error(t, a.UnmanagedDirectory(context.Background(), "dir"))
+	// 10ms, then 20ms capped for the other four retries
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// A cancelled run does not wait out the delay
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.RetryDelay = time.Hour
+	require.ErrorIs(t, a.UnmanagedDirectory(ctx, "dir"), context.DeadlineExceeded)
+}
+
+func TestAMQPNotification_Close(t *testing.T) {
+	ch := &fakeAMQPChannel{}
+	a := &AMQPNotification{channel: ch}
+	require.NoError(t, a.Close())
+	require.True(t, ch.closed)
+	require.NoError(t, a.Close())
+}
+
+func TestAMQPNotification_Generic(t *testing.T) {
+	ch := &fakeAMQPChannel{published: map[string][]byte{}}
+	genericNotificationTest(t, &AMQPNotification{channel: ch})
+}
+
+func TestAMQPNotification_DeadLetter(t *testing.T) {
+	deadLetter := NewDeadLetter(filepath.Join(t.TempDir(), "dead-letter.jsonl"))
+	channel := &fakeAMQPChannel{fail: true, published: map[string][]byte{}}
+	a := &AMQPNotification{
+		RoutingKeyPrefix: "drift.",
+		channel:          channel,
+		DeadLetter:       deadLetter,
+	}
+	ctx := context.Background()
+	require.Error(t, a.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"))
+	require.Error(t, a.TestMessage(ctx, "hello"))
+
+	slack := &SlackWebhook{}
+	a.channel = channel
+	channel.fail = false
+	require.NoError(t, deadLetter.Replay(ctx, a, slack))
+	require.Len(t, channel.published, 1)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(channel.published["drift.plan_drift"], &event))
+	require.Equal(t, "Plan: 1 to add", event["cliffnote"])
+	require.NoFileExists(t, deadLetter.Path)
+}
@@ -0,0 +1,88 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Capped stops sending the chatty per-item events, PlanDrift, PendingApply, ExtraWorkspaceInRemote,
+// MissingWorkspaceInRemote and OrphanedState, once Max of them have been sent. Everything else is always sent.
+type Capped struct {
+	Notification
+	Max int32
+
+	sent       int32
+	suppressed int32
+
+	mu      sync.Mutex
+	dropped []Location
+}
+
+func (c *Capped) allow() bool {
+	if atomic.AddInt32(&c.sent, 1) <= c.Max {
+		return true
+	}
+	atomic.AddInt32(&c.suppressed, 1)
+	return false
+}
+
+// allowDrift is allow for a drift event, remembering the workspace if it is dropped
+func (c *Capped) allowDrift(dir string, workspace string) bool {
+	if c.allow() {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped = append(c.dropped, Location{Directory: dir, Workspace: workspace})
+	return false
+}
+
+// Suppressed returns how many events were not sent because of the cap
+func (c *Capped) Suppressed() int32 {
+	return atomic.LoadInt32(&c.suppressed)
+}
+
+// Dropped returns the workspaces whose PlanDrift or PendingApply was not sent because of the cap
+func (c *Capped) Dropped() []Location {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Location(nil), c.dropped...)
+}
+
+func (c *Capped) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	if !c.allowDrift(dir, workspace) {
+		return nil
+	}
+	return c.Notification.PlanDrift(ctx, dir, workspace, cliffnote)
+}
+
+func (c *Capped) PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error {
+	if !c.allowDrift(dir, workspace) {
+		return nil
+	}
+	return c.Notification.PendingApply(ctx, dir, workspace, pullRequests)
+}
+
+func (c *Capped) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	if !c.allow() {
+		return nil
+	}
+	return c.Notification.ExtraWorkspaceInRemote(ctx, dir, workspace)
+}
+
+func (c *Capped) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	if !c.allow() {
+		return nil
+	}
+	return c.Notification.MissingWorkspaceInRemote(ctx, dir, workspace)
+}
+
+func (c *Capped) OrphanedState(ctx context.Context, dir string, stateKey string) error {
+	if !c.allow() {
+		return nil
+	}
+	return c.Notification.OrphanedState(ctx, dir, stateKey)
+}
+
+var _ Notification = &Capped{}
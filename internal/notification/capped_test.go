@@ -0,0 +1,33 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCapped_PlanDrift(t *testing.T) {
+	inner := &countingNotification{}
+	c := &Capped{Notification: inner, Max: 2}
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.PlanDrift(ctx, "dir", "default", ""))
+	}
+	require.Equal(t, 2, inner.planDrifts)
+	require.Equal(t, int32(3), c.Suppressed())
+	require.NoError(t, c.ExtraWorkspaceInRemote(ctx, "dir", "old"))
+	require.NoError(t, c.PendingApply(ctx, "other", "prod", nil))
+	require.Equal(t, int32(5), c.Suppressed())
+	require.Equal(t, []Location{
+		{Directory: "dir", Workspace: "default"},
+		{Directory: "dir", Workspace: "default"},
+		{Directory: "dir", Workspace: "default"},
+		{Directory: "other", Workspace: "prod"},
+	}, c.Dropped())
+}
+
+func TestCapped_Generic(t *testing.T) {
+	genericNotificationTest(t, &Capped{Notification: &Zap{Logger: zaptest.NewLogger(t)}, Max: 100})
+}
@@ -0,0 +1,304 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cresta/gogithub"
+)
+
+// DefaultCheckRunName is the name of the check run CheckRun publishes
+const DefaultCheckRunName = "Terraform drift"
+
+const (
+	// checkRunAnnotationBatch is the most annotations GitHub accepts in one request
+	checkRunAnnotationBatch = 50
+	// checkRunMaxText is the most characters GitHub accepts in the summary and annotation details
+	checkRunMaxText = 65535
+)
+
+// CheckRun publishes a GitHub check run when the run finishes, on the commit the planned ref, usually the default
+// branch, pointed to when the run started, so drift is visible in the repository UI. The check fails if anything
+// drifted, a plan failed or the run failed. Each drifted, pending or failing workspace gets an annotation on a
+// terraform file of its directory. Drift is taken from the run summary, so workspaces whose PlanDrift was held back
+// are annotated too. Check runs can only be created with a GitHub App token.
+type CheckRun struct {
+	Noop
+	GitHub     gogithub.GitHub
+	HTTPClient *http.Client
+	// BaseURL is the GitHub API URL. Empty is DefaultGitHubAPIURL.
+	BaseURL string
+	// Name of the check run. Empty is DefaultCheckRunName.
+	Name string
+
+	mu sync.Mutex
+	// repo is the repository of the current run, as named by RunStarted
+	repo string
+	// sha is the commit the run checks, resolved by RunStarted, or shaErr why it could not be
+	sha     string
+	shaErr  error
+	events  []checkRunEvent
+	pending map[Location][]string
+}
+
+// checkRunEvent is a workspace to annotate, before the file of its directory is known
+type checkRunEvent struct {
+	dir       string
+	workspace string
+	level     string
+	title     string
+	details   string
+}
+
+type checkRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+	RawDetails      string `json:"raw_details,omitempty"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Annotations []checkRunAnnotation `json:"annotations,omitempty"`
+}
+
+func (c *CheckRun) name() string {
+	if c.Name == "" {
+		return DefaultCheckRunName
+	}
+	return c.Name
+}
+
+func (c *CheckRun) annotate(dir string, workspace string, level string, title string, details string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, checkRunEvent{dir: dir, workspace: workspace, level: level, title: title, details: details})
+}
+
+// terraformFile returns the file of dir to annotate: main.tf if there is one, or else the first *.tf file. It is
+// empty if dir has no terraform files at sha.
+func (c *CheckRun) terraformFile(ctx context.Context, repo string, sha string, dir string) (string, error) {
+	var entries []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	p := fmt.Sprintf("/repos/%s/contents/%s?ref=%s", repo, (&url.URL{Path: strings.Trim(path.Clean(dir), "/")}).EscapedPath(), url.QueryEscape(sha))
+	if err := githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodGet, p, nil, &entries); err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	ret := ""
+	for _, e := range entries {
+		if e.Type != "file" || !strings.HasSuffix(e.Name, ".tf") {
+			continue
+		}
+		if e.Name == "main.tf" {
+			return e.Path, nil
+		}
+		if ret == "" || e.Path < ret {
+			ret = e.Path
+		}
+	}
+	return ret, nil
+}
+
+// checkRunText cuts s down to the most characters GitHub accepts
+func checkRunText(s string) string {
+	if utf8.RuneCountInString(s) <= checkRunMaxText {
+		return s
+	}
+	return string([]rune(s)[:checkRunMaxText])
+}
+
+// headSHA returns the commit ref points to, or the head of the default branch if ref is empty
+func (c *CheckRun) headSHA(ctx context.Context, repo string, ref string) (string, error) {
+	if ref == "" {
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodGet, "/repos/"+repo, nil, &info); err != nil {
+			return "", fmt.Errorf("failed to find default branch: %w", err)
+		}
+		ref = info.DefaultBranch
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodGet, fmt.Sprintf("/repos/%s/commits/%s", repo, url.PathEscape(ref)), nil, &commit); err != nil {
+		return "", fmt.Errorf("failed to find head of %s: %w", ref, err)
+	}
+	return commit.SHA, nil
+}
+
+func (c *CheckRun) TemporaryError(_ context.Context, dir string, workspace string, err error) error {
+	c.annotate(dir, workspace, "failure", "Drift check failed", err.Error())
+	return nil
+}
+
+// PendingApply remembers the pull requests of a drifted workspace, so its annotation is a notice listing them
+func (c *CheckRun) PendingApply(_ context.Context, dir string, workspace string, pullRequests []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[Location][]string)
+	}
+	c.pending[Location{Directory: dir, Workspace: workspace}] = pullRequests
+	return nil
+}
+
+func (c *CheckRun) PlanError(_ context.Context, dir string, workspace string, planError string) error {
+	c.annotate(dir, workspace, "failure", "Plan failed", planError)
+	return nil
+}
+
+// RunStarted remembers the repository of the run and the commit it checks, and forgets the annotations of the previous
+// run. A commit that cannot be resolved fails RunFinished rather than the run.
+func (c *CheckRun) RunStarted(ctx context.Context, repo string, ref string) error {
+	sha, err := c.headSHA(ctx, repo, ref)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.repo = repo
+	c.sha = sha
+	c.shaErr = err
+	c.events = nil
+	c.pending = nil
+	return nil
+}
+
+// RunFinished publishes the check run. GitHub takes at most 50 annotations per request, so any more are added by
+// updating the check run.
+func (c *CheckRun) RunFinished(ctx context.Context, summary RunSummary) error {
+	c.mu.Lock()
+	events := c.events
+	pending := c.pending
+	sha, shaErr := c.sha, c.shaErr
+	c.events = nil
+	c.pending = nil
+	c.sha, c.shaErr = "", nil
+	c.mu.Unlock()
+	if shaErr != nil {
+		return shaErr
+	}
+	if sha == "" {
+		var err error
+		if sha, err = c.headSHA(ctx, summary.Repo, summary.Ref); err != nil {
+			return err
+		}
+	}
+	for _, d := range summary.Drifted {
+		if prs, exists := pending[Location{Directory: d.Directory, Workspace: d.Workspace}]; exists {
+			events = append(events, checkRunEvent{dir: d.Directory, workspace: d.Workspace, level: "notice", title: "Drift pending apply", details: strings.Join(prs, "\n")})
+			continue
+		}
+		events = append(events, checkRunEvent{dir: d.Directory, workspace: d.Workspace, level: "warning", title: "Terraform drift", details: d.Cliffnote})
+	}
+	files := make(map[string]string)
+	var annotations []checkRunAnnotation
+	var unannotated []string
+	for _, e := range events {
+		file, exists := files[e.dir]
+		if !exists {
+			// A directory that cannot be listed, like one deleted since, is named in the summary instead
+			file, _ = c.terraformFile(ctx, summary.Repo, sha, e.dir)
+			files[e.dir] = file
+		}
+		if file == "" {
+			unannotated = append(unannotated, fmt.Sprintf("%s: %s in workspace %s", e.dir, e.title, e.workspace))
+			continue
+		}
+		annotations = append(annotations, checkRunAnnotation{
+			Path:            file,
+			StartLine:       1,
+			EndLine:         1,
+			AnnotationLevel: e.level,
+			Title:           e.title,
+			Message:         fmt.Sprintf("%s in workspace %s", e.title, e.workspace),
+			RawDetails:      checkRunText(e.details),
+		})
+	}
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Path < annotations[j].Path
+	})
+	conclusion := "success"
+	title := fmt.Sprintf("No drift in %d workspaces", summary.TotalWorkspaces)
+	if summary.WorkspacesDrifted > 0 {
+		conclusion = "failure"
+		title = fmt.Sprintf("%d of %d workspaces drifted", summary.WorkspacesDrifted, summary.TotalWorkspaces)
+	}
+	for _, e := range events {
+		if e.level == "failure" {
+			conclusion = "failure"
+			break
+		}
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "Drifted workspaces: %d\nUndrifted workspaces: %d\nTotal workspaces: %d\n", summary.WorkspacesDrifted, summary.WorkspacesUndrifted, summary.TotalWorkspaces)
+	if summary.Error != "" {
+		conclusion = "failure"
+		title = "Drift detection failed"
+		fmt.Fprintf(&body, "\nThe run failed, so some workspaces may not have been checked: %s\n", summary.Error)
+	}
+	if len(unannotated) > 0 {
+		sort.Strings(unannotated)
+		fmt.Fprintf(&body, "\nWithout a terraform file to annotate:\n%s\n", strings.Join(unannotated, "\n"))
+	}
+	output := checkRunOutput{Title: title, Summary: checkRunText(body.String())}
+	batch := func() []checkRunAnnotation {
+		n := min(len(annotations), checkRunAnnotationBatch)
+		ret := annotations[:n]
+		annotations = annotations[n:]
+		return ret
+	}
+	output.Annotations = batch()
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodPost, fmt.Sprintf("/repos/%s/check-runs", summary.Repo), map[string]interface{}{
+		"name":         c.name(),
+		"head_sha":     sha,
+		"status":       "completed",
+		"conclusion":   conclusion,
+		"completed_at": time.Now().UTC().Format(time.RFC3339),
+		"output":       output,
+	}, &created); err != nil {
+		return fmt.Errorf("failed to create check run: %w", err)
+	}
+	for len(annotations) > 0 {
+		output.Annotations = batch()
+		if err := githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodPatch, fmt.Sprintf("/repos/%s/check-runs/%d", summary.Repo, created.ID), map[string]interface{}{
+			"output": output,
+		}, nil); err != nil {
+			return fmt.Errorf("failed to add annotations to check run %d: %w", created.ID, err)
+		}
+	}
+	return nil
+}
+
+// TestMessage reads the repository, which checks the token without publishing a check run. Before a run names its
+// repository, it can only check a token is available.
+func (c *CheckRun) TestMessage(ctx context.Context, _ string) error {
+	c.mu.Lock()
+	repo := c.repo
+	c.mu.Unlock()
+	if repo == "" {
+		if _, err := c.GitHub.GetAccessToken(ctx); err != nil {
+			return fmt.Errorf("failed to get access token: %w", err)
+		}
+		return nil
+	}
+	return githubRequest(ctx, c.GitHub, c.HTTPClient, c.BaseURL, http.MethodGet, "/repos/"+repo, nil, nil)
+}
+
+var _ Notification = &CheckRun{}
@@ -0,0 +1,145 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCheckRuns is a GitHub API with just enough of the repository and check run endpoints for CheckRun
+type fakeCheckRuns struct {
+	mu          sync.Mutex
+	head        string
+	created     map[string]interface{}
+	annotations []interface{}
+	updates     int
+}
+
+func (f *fakeCheckRuns) setHead(sha string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head = sha
+}
+
+func (f *fakeCheckRuns) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	addAnnotations := func() {
+		if annotations, ok := body["output"].(map[string]interface{})["annotations"].([]interface{}); ok {
+			f.annotations = append(f.annotations, annotations...)
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/org/repo":
+		_, _ = w.Write([]byte(`{"default_branch": "main"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/org/repo/commits/main":
+		head := f.head
+		if head == "" {
+			head = "abc123"
+		}
+		_, _ = fmt.Fprintf(w, `{"sha": %q}`, head)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/org/repo/contents/dir"):
+		// Every dir has a variables.tf and a main.tf, apart from dir99 which only has a README
+		dir := strings.TrimPrefix(r.URL.Path, "/repos/org/repo/contents/")
+		if r.URL.Query().Get("ref") != "abc123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if dir == "dir99" {
+			_, _ = w.Write([]byte(`[{"name": "README.md", "path": "dir99/README.md", "type": "file"}]`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `[{"name": "variables.tf", "path": "%[1]s/variables.tf", "type": "file"}, {"name": "main.tf", "path": "%[1]s/main.tf", "type": "file"}]`, dir)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/org/repo/check-runs":
+		f.created = body
+		addAnnotations()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": 7}`))
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/org/repo/check-runs/7":
+		f.updates++
+		addAnnotations()
+		_, _ = w.Write([]byte(`{"id": 7}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCheckRun(t *testing.T) {
+	ctx := context.Background()
+	t.Run("clean", func(t *testing.T) {
+		fake := &fakeCheckRuns{}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+		c := &CheckRun{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL}
+		require.NoError(t, c.RunStarted(ctx, "org/repo", ""))
+		require.NoError(t, c.TestMessage(ctx, "test"))
+		require.NoError(t, c.RunFinished(ctx, RunSummary{Repo: "org/repo", WorkspacesUndrifted: 3, TotalWorkspaces: 3}))
+		require.Equal(t, "abc123", fake.created["head_sha"])
+		require.Equal(t, "success", fake.created["conclusion"])
+		require.Equal(t, DefaultCheckRunName, fake.created["name"])
+		require.Empty(t, fake.annotations)
+	})
+	t.Run("drift", func(t *testing.T) {
+		fake := &fakeCheckRuns{}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+		c := &CheckRun{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL, Name: "drift"}
+		require.NoError(t, c.RunStarted(ctx, "org/repo", "main"))
+		// The check goes on the commit the run started from, even if the branch moves on during the run
+		fake.setHead("def456")
+		var drifted []DriftedWorkspace
+		for i := 0; i < 60; i++ {
+			drifted = append(drifted, DriftedWorkspace{Directory: fmt.Sprintf("dir%02d", i), Workspace: "default", Cliffnote: "Plan: 1 to add"})
+		}
+		drifted = append(drifted, DriftedWorkspace{Directory: "dir99", Workspace: "default"})
+		require.NoError(t, c.PendingApply(ctx, "dir01", "default", []string{"https://github.com/org/repo/pull/1"}))
+		require.NoError(t, c.RunFinished(ctx, RunSummary{Repo: "org/repo", Ref: "main", WorkspacesDrifted: 61, TotalWorkspaces: 62, Drifted: drifted}))
+		require.Equal(t, "abc123", fake.created["head_sha"])
+		require.Equal(t, "failure", fake.created["conclusion"])
+		require.Equal(t, "drift", fake.created["name"])
+		output := fake.created["output"].(map[string]interface{})
+		require.Equal(t, "61 of 62 workspaces drifted", output["title"])
+		require.Contains(t, output["summary"], "Without a terraform file to annotate:\ndir99: Terraform drift in workspace default")
+		require.Len(t, fake.annotations, 60, "annotations past the first 50 are added by an update")
+		require.Equal(t, 1, fake.updates)
+		first := fake.annotations[0].(map[string]interface{})
+		require.Equal(t, "dir00/main.tf", first["path"])
+		require.Equal(t, "warning", first["annotation_level"])
+		require.Equal(t, "Plan: 1 to add", first["raw_details"])
+		pending := fake.annotations[1].(map[string]interface{})
+		require.Equal(t, "notice", pending["annotation_level"])
+		require.Equal(t, "https://github.com/org/repo/pull/1", pending["raw_details"])
+		fake.setHead("")
+
+		// The next run starts without the annotations of this one
+		require.NoError(t, c.RunStarted(ctx, "org/repo", "main"))
+		require.NoError(t, c.PlanError(ctx, "dir", "default", "Error: boom"))
+		fake.annotations = nil
+		require.NoError(t, c.RunFinished(ctx, RunSummary{Repo: "org/repo", Ref: "main", WorkspacesUndrifted: 1, TotalWorkspaces: 1}))
+		require.Equal(t, "failure", fake.created["conclusion"], "failing plans fail the check")
+		require.Len(t, fake.annotations, 1)
+	})
+	t.Run("unknown ref", func(t *testing.T) {
+		fake := &fakeCheckRuns{}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+		c := &CheckRun{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL}
+		require.Error(t, c.RunFinished(ctx, RunSummary{Repo: "org/repo", Ref: "missing"}))
+		require.Nil(t, fake.created)
+	})
+}
+
+func TestCheckRunText(t *testing.T) {
+	require.Equal(t, "short", checkRunText("short"))
+	long := checkRunText(string(make([]rune, checkRunMaxText+10)))
+	require.Len(t, []rune(long), checkRunMaxText)
+}
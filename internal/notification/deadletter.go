@@ -0,0 +1,119 @@
+package notification
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DeadLetter is a file of messages that notification backends could not send. Each line is JSON naming the backend
+// and the payload it failed to send, so a later run can send them again with Replay.
+type DeadLetter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// DeadLetterSender is a backend that keeps messages it could not send in a DeadLetter
+type DeadLetterSender interface {
+	// DeadLetterName identifies the backend's messages in the dead letter file
+	DeadLetterName() string
+	// SendDeadLetter sends a payload the backend put in the dead letter file, as it was
+	SendDeadLetter(ctx context.Context, payload []byte) error
+}
+
+type deadLetterEntry struct {
+	Backend string          `json:"backend"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// NewDeadLetter returns a DeadLetter writing to path. An empty path returns nil, which drops failed messages.
+func NewDeadLetter(path string) *DeadLetter {
+	if path == "" {
+		return nil
+	}
+	return &DeadLetter{Path: path}
+}
+
+// Add appends a JSON payload that backend could not send
+func (d *DeadLetter) Add(backend string, payload []byte) error {
+	if d == nil {
+		return nil
+	}
+	b, err := json.Marshal(deadLetterEntry{Backend: backend, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	return f.Close()
+}
+
+// Replay sends every message in the file again through the sender it came from. Messages that still fail, and
+// messages from backends not in senders, are kept in the file, and the file is removed once it is empty.
+func (d *DeadLetter) Replay(ctx context.Context, senders ...DeadLetterSender) error {
+	if d == nil {
+		return nil
+	}
+	byName := make(map[string]DeadLetterSender, len(senders))
+	for _, s := range senders {
+		byName[s.DeadLetterName()] = s
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	content, err := os.ReadFile(d.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	var kept bytes.Buffer
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry deadLetterEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse dead letter: %w", err))
+		} else if s, exists := byName[entry.Backend]; !exists {
+			errs = append(errs, fmt.Errorf("no %s backend to replay dead letter", entry.Backend))
+		} else if err := s.SendDeadLetter(ctx, entry.Payload); err != nil {
+			errs = append(errs, err)
+		} else {
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	if kept.Len() == 0 {
+		if err := os.Remove(d.Path); err != nil {
+			return fmt.Errorf("failed to remove dead letter file: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(d.Path, kept.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+	return errors.Join(errs...)
+}
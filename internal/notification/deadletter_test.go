@@ -0,0 +1,21 @@
+package notification
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetter_KeepsUnknownBackends(t *testing.T) {
+	deadLetter := NewDeadLetter(filepath.Join(t.TempDir(), "dead-letter.jsonl"))
+	require.NoError(t, deadLetter.Add("amqp", []byte(`{"kind":"plan_drift"}`)))
+	require.ErrorContains(t, deadLetter.Replay(context.Background(), &SlackWebhook{}), "no amqp backend")
+	content, err := os.ReadFile(deadLetter.Path)
+	require.NoError(t, err)
+	require.Equal(t, `{"backend":"amqp","payload":{"kind":"plan_drift"}}`+"\n", string(content))
+	require.Nil(t, NewDeadLetter(""))
+	require.NoError(t, NewDeadLetter("").Add("amqp", []byte("{}")))
+}
@@ -0,0 +1,187 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email sends drift by email over SMTP, for stakeholders who are not in slack. Each drifted workspace gets its own
+// email, or with Digest a single email lists every workspace the run found drifted when it finishes, including ones
+// whose own notification was held back. Other events are ignored.
+type Email struct {
+	Noop
+	// Addr is the host:port of the SMTP server
+	Addr string
+	// Username and Password, if set, authenticate with PLAIN auth, which needs TLS unless the server is local
+	Username string
+	Password string
+	From     string
+	To       []string
+	// ImplicitTLS connects with TLS from the start, as on port 465, instead of upgrading with STARTTLS when the server
+	// offers it
+	ImplicitTLS bool
+	// RequireTLS fails instead of sending in plain text when the server does not offer STARTTLS. NewEmail sets it.
+	RequireTLS bool
+	// TLSConfig, if set, is used for TLS connections, for example to trust a private CA
+	TLSConfig *tls.Config
+	// Digest sends one email listing the drift of the run when it finishes, instead of one email per drifted workspace
+	Digest bool
+
+	mu   sync.Mutex
+	repo string
+}
+
+// NewEmail returns an Email notification sending through the SMTP server at addr. An empty addr returns nil.
+func NewEmail(addr string, from string, to []string) (*Email, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	if from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("email notification needs a sender and at least one recipient")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid smtp address %s: %w", addr, err)
+	}
+	return &Email{Addr: addr, From: from, To: to, RequireTLS: true}, nil
+}
+
+// sendEmail sends one email with a plain text body to every recipient
+func (e *Email) sendEmail(ctx context.Context, subject string, body string) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address %s: %w", e.Addr, err)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if e.TLSConfig != nil {
+		tlsConfig = e.TLSConfig.Clone()
+	}
+	tlsConfig.ServerName = host
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", e.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if e.ImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to start smtp session: %w", err)
+	}
+	defer c.Close()
+	if !e.ImplicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start tls: %w", err)
+			}
+		} else if e.RequireTLS {
+			return fmt.Errorf("smtp server %s does not offer STARTTLS", e.Addr)
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return fmt.Errorf("failed to authenticate to smtp server: %w", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return fmt.Errorf("failed to set email sender: %w", err)
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to add email recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start email body: %w", err)
+	}
+	if _, err := w.Write(e.message(subject, body)); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return c.Quit()
+}
+
+// message formats an email with CRLF line endings. The subject is MIME encoded, so names in it cannot add headers.
+func (e *Email) message(subject string, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// PlanDrift sends an email about the drifted workspace, unless Digest is set: the digest is built from the run summary
+func (e *Email) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	if e.Digest {
+		return nil
+	}
+	e.mu.Lock()
+	repo := e.repo
+	e.mu.Unlock()
+	subject := fmt.Sprintf("Terraform drift in %s: %s (%s)", repo, dir, workspace)
+	body := fmt.Sprintf("Drift detected\nRepo: %s\nDirectory: %s\nWorkspace: %s\n\n%s", repo, dir, workspace, cliffnote)
+	return e.sendEmail(ctx, subject, body)
+}
+
+// RunStarted remembers the repository of the run for subjects
+func (e *Email) RunStarted(_ context.Context, repo string, _ string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.repo = repo
+	return nil
+}
+
+// RunFinished sends the digest of the drifted workspaces of summary, if Digest is set, anything drifted and the run
+// did not suppress drift notifications
+func (e *Email) RunFinished(ctx context.Context, summary RunSummary) error {
+	drifts := summary.Drifted
+	if !e.Digest || len(drifts) == 0 || summary.DriftSuppressedReason != "" {
+		return nil
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "Drift detected in %d of %d workspaces\nRepo: %s\n", summary.WorkspacesDrifted, summary.TotalWorkspaces, summary.Repo)
+	if summary.Ref != "" {
+		fmt.Fprintf(&body, "Ref: %s\n", summary.Ref)
+	}
+	drifts = append([]DriftedWorkspace(nil), drifts...)
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Directory != drifts[j].Directory {
+			return drifts[i].Directory < drifts[j].Directory
+		}
+		return drifts[i].Workspace < drifts[j].Workspace
+	})
+	if summary.Error != "" {
+		fmt.Fprintf(&body, "The run failed, so some workspaces may not have been checked: %s\n", summary.Error)
+	}
+	for _, d := range drifts {
+		fmt.Fprintf(&body, "\n%s (%s)\n%s\n", d.Directory, d.Workspace, d.Cliffnote)
+	}
+	return e.sendEmail(ctx, fmt.Sprintf("Terraform drift in %s: %d workspaces", summary.Repo, len(drifts)), body.String())
+}
+
+func (e *Email) TestMessage(ctx context.Context, message string) error {
+	return e.sendEmail(ctx, "Drift detection notification test", message)
+}
+
+var _ Notification = &Email{}
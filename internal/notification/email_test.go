@@ -0,0 +1,139 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts mail without TLS or auth and records the data of every email
+type fakeSMTPServer struct {
+	mu     sync.Mutex
+	emails []string
+}
+
+func newFakeSMTPServer(t *testing.T) (*fakeSMTPServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	srv := &fakeSMTPServer{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, l.Addr().String()
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.emails = append(s.emails, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.emails...)
+}
+
+func TestEmail(t *testing.T) {
+	srv, addr := newFakeSMTPServer(t)
+	e, err := NewEmail(addr, "drift@example.com", []string{"a@example.com", "b@example.com"})
+	require.NoError(t, err)
+	require.ErrorContains(t, e.TestMessage(context.Background(), "hello"), "does not offer STARTTLS")
+	require.Empty(t, srv.sent())
+	e.RequireTLS = false
+	genericNotificationTest(t, e)
+	ctx := context.Background()
+	require.NoError(t, e.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, e.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"))
+	emails := srv.sent()
+	require.Len(t, emails, 3, "the test message and one email per drift")
+	last := emails[2]
+	require.Contains(t, last, "To: a@example.com, b@example.com\r\n")
+	require.Contains(t, last, "Subject: Terraform drift in org/repo: dir (default)\r\n")
+	require.Contains(t, last, "Workspace: default\r\n\r\nPlan: 1 to add\r\n")
+}
+
+func TestEmail_Digest(t *testing.T) {
+	srv, addr := newFakeSMTPServer(t)
+	e, err := NewEmail(addr, "drift@example.com", []string{"a@example.com"})
+	require.NoError(t, err)
+	e.RequireTLS = false
+	e.Digest = true
+	ctx := context.Background()
+	require.NoError(t, e.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, e.PlanDrift(ctx, "b", "default", "Plan: 1 to add"))
+	require.Empty(t, srv.sent())
+	// The digest lists every drifted workspace of the summary, even ones whose PlanDrift was held back
+	require.NoError(t, e.RunFinished(ctx, RunSummary{Repo: "org/repo", WorkspacesDrifted: 2, TotalWorkspaces: 5, Drifted: []DriftedWorkspace{
+		{Directory: "b", Workspace: "default", Cliffnote: "Plan: 1 to add"},
+		{Directory: "a", Workspace: "prod", Cliffnote: "Plan: 0 to add, 1 to change"},
+	}}))
+	emails := srv.sent()
+	require.Len(t, emails, 1)
+	require.Contains(t, emails[0], "Subject: Terraform drift in org/repo: 2 workspaces\r\n")
+	require.Contains(t, emails[0], "Drift detected in 2 of 5 workspaces\r\n")
+	require.Less(t, strings.Index(emails[0], "a (prod)"), strings.Index(emails[0], "b (default)"))
+
+	require.NoError(t, e.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, e.RunFinished(ctx, RunSummary{Repo: "org/repo", TotalWorkspaces: 5}))
+	require.Len(t, srv.sent(), 1, "clean runs send no digest")
+	require.NoError(t, e.RunFinished(ctx, RunSummary{Repo: "org/repo", DriftSuppressedReason: "bootstrap mode", Drifted: []DriftedWorkspace{{Directory: "a", Workspace: "prod"}}}))
+	require.Len(t, srv.sent(), 1, "runs that suppress drift send no digest")
+}
+
+func TestNewEmail(t *testing.T) {
+	e, err := NewEmail("", "", nil)
+	require.NoError(t, err)
+	require.Nil(t, e)
+	_, err = NewEmail("smtp.example.com:587", "drift@example.com", nil)
+	require.Error(t, err)
+	_, err = NewEmail("smtp.example.com", "drift@example.com", []string{"a@example.com"})
+	require.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gogithub"
+)
+
+// DefaultGitHubAPIURL is used by the GitHub notifications if they have no BaseURL
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+// githubRequest calls the GitHub REST API with a token from gh, decoding the response into into, if set
+func githubRequest(ctx context.Context, gh gogithub.GitHub, client *http.Client, baseURL string, method string, path string, body interface{}, into interface{}) error {
+	token, err := gh.GetAccessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal github request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	if baseURL == "" {
+		baseURL = DefaultGitHubAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(baseURL, "/")+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call github: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d from github: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if into == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return fmt.Errorf("failed to decode github response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/cresta/gogithub"
+)
+
+// DefaultGitHubIssueLabel marks the issues GitHubIssues manages
+const DefaultGitHubIssueLabel = "terraform-drift"
+
+// githubIssuePageSize is the largest page the GitHub REST API returns
+const githubIssuePageSize = 100
+
+// GitHubIssues keeps one open GitHub issue per drifted directory and workspace, so drift can be tracked and assigned.
+// Drift opens an issue, or updates the plan summary of the open one, and the issue is closed once the workspace is
+// clean again. Wire Drifted and Clean to Drifter.OnResult, so this happens for every result, not only notified ones. Issues are found again on later runs by a marker in their body. Every other event is ignored.
+type GitHubIssues struct {
+	Noop
+	GitHub     gogithub.GitHub
+	HTTPClient *http.Client
+	// BaseURL is the GitHub API URL. Empty is DefaultGitHubAPIURL.
+	BaseURL string
+	// Repo is the repository issues are filed in, in owner/name form. Empty files them in the repository of the run.
+	Repo string
+	// Label marks drift issues. Empty is DefaultGitHubIssueLabel.
+	Label string
+
+	mu sync.Mutex
+	// runRepo is the repository of the current run, as named by RunStarted
+	runRepo string
+	// open are the open drift issues by marker, listed on first use in each run
+	open map[string]githubIssue
+}
+
+type githubIssue struct {
+	Number int    `json:"number"`
+	Body   string `json:"body"`
+}
+
+func (g *GitHubIssues) label() string {
+	if g.Label == "" {
+		return DefaultGitHubIssueLabel
+	}
+	return g.Label
+}
+
+func (g *GitHubIssues) issueRepo() string {
+	if g.Repo != "" {
+		return g.Repo
+	}
+	return g.runRepo
+}
+
+// githubIssueMarker is the hidden comment that ties an issue to a workspace
+func githubIssueMarker(repo string, dir string, workspace string) string {
+	return fmt.Sprintf("<!-- atlantis-drift-detection: %s/%s#%s -->", repo, dir, workspace)
+}
+
+func (g *GitHubIssues) do(ctx context.Context, method string, path string, body interface{}, into interface{}) error {
+	return githubRequest(ctx, g.GitHub, g.HTTPClient, g.BaseURL, method, path, body, into)
+}
+
+// openIssues returns the open drift issues by marker, listing them once per run. Callers hold mu.
+func (g *GitHubIssues) openIssues(ctx context.Context) (map[string]githubIssue, error) {
+	if g.open != nil {
+		return g.open, nil
+	}
+	open := make(map[string]githubIssue)
+	for page := 1; ; page++ {
+		var issues []githubIssue
+		path := fmt.Sprintf("/repos/%s/issues?state=open&labels=%s&per_page=%d&page=%d", g.issueRepo(), url.QueryEscape(g.label()), githubIssuePageSize, page)
+		if err := g.do(ctx, http.MethodGet, path, nil, &issues); err != nil {
+			return nil, fmt.Errorf("failed to list drift issues: %w", err)
+		}
+		for _, issue := range issues {
+			if start := strings.Index(issue.Body, "<!-- atlantis-drift-detection: "); start >= 0 {
+				if end := strings.Index(issue.Body[start:], " -->"); end >= 0 {
+					open[issue.Body[start:start+end+len(" -->")]] = issue
+				}
+			}
+		}
+		if len(issues) < githubIssuePageSize {
+			break
+		}
+	}
+	g.open = open
+	return open, nil
+}
+
+func (g *GitHubIssues) issueBody(marker string, dir string, workspace string, cliffnote string) string {
+	return fmt.Sprintf("%s\nTerraform drift detected\n\nRepo: `%s`\nDirectory: `%s`\nWorkspace: `%s`\n\n```\n%s\n```\n", marker, g.runRepo, dir, workspace, cliffnote)
+}
+
+// updateIssue sets the plan summary of the open issue of the workspace, if it has one and the summary changed. It
+// returns whether the workspace has an open issue. Callers hold mu.
+func (g *GitHubIssues) updateIssue(ctx context.Context, dir string, workspace string, cliffnote string) (bool, error) {
+	open, err := g.openIssues(ctx)
+	if err != nil {
+		return false, err
+	}
+	marker := githubIssueMarker(g.runRepo, dir, workspace)
+	issue, exists := open[marker]
+	if !exists {
+		return false, nil
+	}
+	body := g.issueBody(marker, dir, workspace, cliffnote)
+	if issue.Body == body {
+		return true, nil
+	}
+	if err := g.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", g.issueRepo(), issue.Number), map[string]string{"body": body}, nil); err != nil {
+		return true, fmt.Errorf("failed to update drift issue %d: %w", issue.Number, err)
+	}
+	issue.Body = body
+	open[marker] = issue
+	return true, nil
+}
+
+// PlanDrift opens an issue for the workspace, or updates the plan summary of its open issue
+func (g *GitHubIssues) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if exists, err := g.updateIssue(ctx, dir, workspace, cliffnote); exists || err != nil {
+		return err
+	}
+	marker := githubIssueMarker(g.runRepo, dir, workspace)
+	body := g.issueBody(marker, dir, workspace, cliffnote)
+	var created githubIssue
+	if err := g.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues", g.issueRepo()), map[string]interface{}{
+		"title":  fmt.Sprintf("Terraform drift in %s: %s (%s)", g.runRepo, dir, workspace),
+		"body":   body,
+		"labels": []string{g.label()},
+	}, &created); err != nil {
+		return fmt.Errorf("failed to open drift issue: %w", err)
+	}
+	created.Body = body
+	g.open[marker] = created
+	return nil
+}
+
+// Drifted updates the plan summary of the open issue of a drifted workspace, without opening one. It is called with
+// every drift result, through Drifter.OnResult, so issues stay current while PlanDrift is held back, for example by
+// a reminder interval.
+func (g *GitHubIssues) Drifted(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, err := g.updateIssue(ctx, dir, workspace, cliffnote)
+	return err
+}
+
+// Clean closes the open issue of a workspace found without drift, if it has one. It is called with every clean
+// result, through Drifter.OnResult, so issues close even when no DriftResolved is sent.
+func (g *GitHubIssues) Clean(ctx context.Context, dir string, workspace string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	open, err := g.openIssues(ctx)
+	if err != nil {
+		return err
+	}
+	marker := githubIssueMarker(g.runRepo, dir, workspace)
+	issue, exists := open[marker]
+	if !exists {
+		return nil
+	}
+	path := fmt.Sprintf("/repos/%s/issues/%d", g.issueRepo(), issue.Number)
+	if err := g.do(ctx, http.MethodPost, path+"/comments", map[string]string{"body": "The workspace has no changes any more, closing."}, nil); err != nil {
+		return fmt.Errorf("failed to comment on drift issue %d: %w", issue.Number, err)
+	}
+	if err := g.do(ctx, http.MethodPatch, path, map[string]string{"state": "closed", "state_reason": "completed"}, nil); err != nil {
+		return fmt.Errorf("failed to close drift issue %d: %w", issue.Number, err)
+	}
+	delete(open, marker)
+	return nil
+}
+
+// DriftResolved closes the open issue of the workspace, if it has one
+func (g *GitHubIssues) DriftResolved(ctx context.Context, dir string, workspace string) error {
+	return g.Clean(ctx, dir, workspace)
+}
+
+// RunStarted remembers the repository of the run, and forgets the issues listed for the previous run
+func (g *GitHubIssues) RunStarted(_ context.Context, repo string, _ string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.runRepo = repo
+	g.open = nil
+	return nil
+}
+
+// TestMessage lists the drift issues, which checks the token can read issues without opening one. Without Repo, and
+// before a run names its repository, it can only check a token is available.
+func (g *GitHubIssues) TestMessage(ctx context.Context, _ string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.issueRepo() == "" {
+		if _, err := g.GitHub.GetAccessToken(ctx); err != nil {
+			return fmt.Errorf("failed to get access token: %w", err)
+		}
+		return nil
+	}
+	g.open = nil
+	_, err := g.openIssues(ctx)
+	return err
+}
+
+var _ Notification = &GitHubIssues{}
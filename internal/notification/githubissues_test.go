@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cresta/gogithub"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenGitHub struct {
+	gogithub.GitHub
+}
+
+func (tokenGitHub) GetAccessToken(_ context.Context) (string, error) {
+	return "token", nil
+}
+
+// fakeIssues is a GitHub API with just enough of the issues endpoints for GitHubIssues
+type fakeIssues struct {
+	mu       sync.Mutex
+	issues   map[int]map[string]interface{}
+	comments map[int][]string
+	lists    int
+	patches  int
+}
+
+func (f *fakeIssues) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	var number int
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/org/repo/issues":
+		f.lists++
+		ret := []map[string]interface{}{}
+		for n, issue := range f.issues {
+			if issue["state"] == "open" && r.URL.Query().Get("labels") == "terraform-drift" {
+				ret = append(ret, map[string]interface{}{"number": n, "body": issue["body"]})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(ret)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/org/repo/issues":
+		number = len(f.issues) + 1
+		body["state"] = "open"
+		f.issues[number] = body
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"number": number})
+	case r.Method == http.MethodPatch:
+		f.patches++
+		_, _ = fmt.Sscanf(r.URL.Path, "/repos/org/repo/issues/%d", &number)
+		for k, v := range body {
+			f.issues[number][k] = v
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"number": number})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/comments"):
+		_, _ = fmt.Sscanf(r.URL.Path, "/repos/org/repo/issues/%d/comments", &number)
+		f.comments[number] = append(f.comments[number], body["body"].(string))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGitHubIssues(t *testing.T) {
+	fake := &fakeIssues{issues: map[int]map[string]interface{}{}, comments: map[int][]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	newIssues := func() *GitHubIssues {
+		return &GitHubIssues{GitHub: tokenGitHub{}, HTTPClient: srv.Client(), BaseURL: srv.URL}
+	}
+	ctx := context.Background()
+
+	g := newIssues()
+	require.NoError(t, g.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, g.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"))
+	require.NoError(t, g.PlanDrift(ctx, "other", "default", "Plan: 2 to add"))
+	require.Len(t, fake.issues, 2)
+	require.Equal(t, "Terraform drift in org/repo: dir (default)", fake.issues[1]["title"])
+	require.Equal(t, []interface{}{"terraform-drift"}, fake.issues[1]["labels"])
+	require.Contains(t, fake.issues[1]["body"], "Plan: 1 to add")
+	require.Equal(t, 1, fake.lists, "open issues are listed once per run")
+
+	// A later run finds the open issues again and updates them instead of opening new ones
+	g = newIssues()
+	require.NoError(t, g.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, g.PlanDrift(ctx, "dir", "default", "Plan: 3 to add"))
+	require.Len(t, fake.issues, 2)
+	require.Contains(t, fake.issues[1]["body"], "Plan: 3 to add")
+	require.NoError(t, g.PlanDrift(ctx, "dir", "default", "Plan: 3 to add"))
+	require.Equal(t, 1, fake.patches, "unchanged issues are not updated")
+
+	// Drift whose notification was held back updates the open issue, but doesn't open one
+	require.NoError(t, g.Drifted(ctx, "dir", "default", "Plan: 4 to add"))
+	require.Contains(t, fake.issues[1]["body"], "Plan: 4 to add")
+	require.NoError(t, g.Drifted(ctx, "new", "default", "Plan: 1 to add"))
+	require.Len(t, fake.issues, 2)
+
+	require.NoError(t, g.DriftResolved(ctx, "dir", "default"))
+	require.Equal(t, "closed", fake.issues[1]["state"])
+	require.Len(t, fake.comments[1], 1)
+	require.Equal(t, "open", fake.issues[2]["state"])
+	require.NoError(t, g.DriftResolved(ctx, "dir", "default"), "closed issues are not closed again")
+	require.Len(t, fake.comments[1], 1)
+
+	// Any clean result closes the open issue, even without DriftResolved
+	require.NoError(t, g.Clean(ctx, "other", "default"))
+	require.Equal(t, "closed", fake.issues[2]["state"])
+	require.NoError(t, g.Clean(ctx, "never-drifted", "default"))
+
+	require.NoError(t, g.TestMessage(ctx, "test"))
+}
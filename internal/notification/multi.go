@@ -1,54 +1,190 @@
 package notification
 
-import "context"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
 
+// Multi fans each event out to every configured backend. A failing backend does not prevent later backends from
+// being notified: every backend is attempted and all errors are joined into the returned error.
+//
+// Backends are notified one after the other, in order, unless MaxConcurrentBackends is above one. Then up to that many
+// are notified in parallel so that one slow backend does not delay the others.
 type Multi struct {
-	Notifications []Notification
+	Notifications         []Notification
+	MaxConcurrentBackends int
 }
 
-func (m *Multi) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
-	for _, n := range m.Notifications {
-		if err := n.TemporaryError(ctx, dir, workspace, err); err != nil {
-			return err
-		}
+// Backends returns the backends n fans out to, looking into Multi, or n itself for a single backend
+func Backends(n Notification) []Notification {
+	var children []Notification
+	switch n := n.(type) {
+	case *Multi:
+		children = n.Notifications
+	default:
+		return []Notification{n}
+	}
+	var ret []Notification
+	for _, c := range children {
+		ret = append(ret, Backends(c)...)
 	}
-	return nil
+	return ret
 }
 
-func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	for _, n := range m.Notifications {
-		if err := n.ExtraWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
-		}
+func (m *Multi) each(f func(n Notification) error) error {
+	workers := min(max(m.MaxConcurrentBackends, 1), len(m.Notifications))
+	errs := make([]error, len(m.Notifications))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				errs[idx] = f(m.Notifications[idx])
+			}
+		}()
 	}
-	return nil
+	for idx := range m.Notifications {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (m *Multi) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
+	return m.each(func(n Notification) error {
+		return n.TemporaryError(ctx, dir, workspace, err)
+	})
+}
+
+func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	return m.each(func(n Notification) error {
+		return n.ExtraWorkspaceInRemote(ctx, dir, workspace)
+	})
 }
 
 func (m *Multi) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	for _, n := range m.Notifications {
-		if err := n.MissingWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
-		}
-	}
-	return nil
+	return m.each(func(n Notification) error {
+		return n.MissingWorkspaceInRemote(ctx, dir, workspace)
+	})
 }
 
 func (m *Multi) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
-	for _, n := range m.Notifications {
-		if err := n.PlanDrift(ctx, dir, workspace, cliffnote); err != nil {
-			return err
-		}
-	}
-	return nil
+	return m.each(func(n Notification) error {
+		return n.PlanDrift(ctx, dir, workspace, cliffnote)
+	})
+}
+
+func (m *Multi) RefPlanDrift(ctx context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	return m.each(func(n Notification) error {
+		return n.RefPlanDrift(ctx, ref, dir, workspace, cliffnote)
+	})
+}
+
+func (m *Multi) PlanError(ctx context.Context, dir string, workspace string, planError string) error {
+	return m.each(func(n Notification) error {
+		return n.PlanError(ctx, dir, workspace, planError)
+	})
 }
 
 func (m *Multi) WorkspaceDriftSummary(ctx context.Context, workspacesDrifted int32, workspacesUndrifted int32, totalWorkspaces int32) error {
-	for _, n := range m.Notifications {
-		if err := n.WorkspaceDriftSummary(ctx, workspacesDrifted, workspacesUndrifted, totalWorkspaces); err != nil {
-			return err
-		}
-	}
-	return nil
+	return m.each(func(n Notification) error {
+		return n.WorkspaceDriftSummary(ctx, workspacesDrifted, workspacesUndrifted, totalWorkspaces)
+	})
+}
+
+func (m *Multi) WorkspaceAuditSummary(ctx context.Context, extraWorkspaces int32, missingWorkspaces int32) error {
+	return m.each(func(n Notification) error {
+		return n.WorkspaceAuditSummary(ctx, extraWorkspaces, missingWorkspaces)
+	})
+}
+
+func (m *Multi) CachedResultsWarning(ctx context.Context, cachedWorkspaces int32, totalWorkspaces int32, oldestCheck time.Time) error {
+	return m.each(func(n Notification) error {
+		return n.CachedResultsWarning(ctx, cachedWorkspaces, totalWorkspaces, oldestCheck)
+	})
+}
+
+func (m *Multi) UnmanagedDirectory(ctx context.Context, dir string) error {
+	return m.each(func(n Notification) error {
+		return n.UnmanagedDirectory(ctx, dir)
+	})
+}
+
+func (m *Multi) DriftNotificationsSuppressed(ctx context.Context, reason string, suppressedDrifts int32) error {
+	return m.each(func(n Notification) error {
+		return n.DriftNotificationsSuppressed(ctx, reason, suppressedDrifts)
+	})
+}
+
+func (m *Multi) PlanLocked(ctx context.Context, dir string, workspace string) error {
+	return m.each(func(n Notification) error {
+		return n.PlanLocked(ctx, dir, workspace)
+	})
+}
+
+func (m *Multi) DriftResolved(ctx context.Context, dir string, workspace string) error {
+	return m.each(func(n Notification) error {
+		return n.DriftResolved(ctx, dir, workspace)
+	})
+}
+
+func (m *Multi) NeverPlanned(ctx context.Context, dir string, workspace string) error {
+	return m.each(func(n Notification) error {
+		return n.NeverPlanned(ctx, dir, workspace)
+	})
+}
+
+func (m *Multi) OrphanedState(ctx context.Context, dir string, stateKey string) error {
+	return m.each(func(n Notification) error {
+		return n.OrphanedState(ctx, dir, stateKey)
+	})
+}
+
+func (m *Multi) PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error {
+	return m.each(func(n Notification) error {
+		return n.PendingApply(ctx, dir, workspace, pullRequests)
+	})
+}
+
+func (m *Multi) NoProjectsFound(ctx context.Context, repo string, configPath string) error {
+	return m.each(func(n Notification) error {
+		return n.NoProjectsFound(ctx, repo, configPath)
+	})
+}
+
+func (m *Multi) DuplicateProject(ctx context.Context, name string, dirs []string) error {
+	return m.each(func(n Notification) error {
+		return n.DuplicateProject(ctx, name, dirs)
+	})
+}
+
+func (m *Multi) RunStarted(ctx context.Context, repo string, ref string) error {
+	return m.each(func(n Notification) error {
+		return n.RunStarted(ctx, repo, ref)
+	})
+}
+
+func (m *Multi) RunFinished(ctx context.Context, summary RunSummary) error {
+	return m.each(func(n Notification) error {
+		return n.RunFinished(ctx, summary)
+	})
+}
+
+func (m *Multi) RunTimings(ctx context.Context, total time.Duration, phases []PhaseTiming) error {
+	return m.each(func(n Notification) error {
+		return n.RunTimings(ctx, total, phases)
+	})
+}
+
+func (m *Multi) TestMessage(ctx context.Context, message string) error {
+	return m.each(func(n Notification) error {
+		return n.TestMessage(ctx, message)
+	})
 }
 
 var _ Notification = &Multi{}
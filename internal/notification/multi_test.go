@@ -0,0 +1,162 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type countingNotification struct {
+	Notification
+	err        error
+	planDrifts int
+}
+
+func (c *countingNotification) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	c.planDrifts++
+	return c.err
+}
+
+func TestMulti_PlanDriftAttemptsAllBackends(t *testing.T) {
+	errFirst := errors.New("first failed")
+	errThird := errors.New("third failed")
+	first := &countingNotification{err: errFirst}
+	second := &countingNotification{}
+	third := &countingNotification{err: errThird}
+	m := &Multi{Notifications: []Notification{first, second, third}}
+	err := m.PlanDrift(context.Background(), "dir", "workspace", "cliffnote")
+	require.ErrorIs(t, err, errFirst)
+	require.ErrorIs(t, err, errThird)
+	require.Equal(t, 1, first.planDrifts)
+	require.Equal(t, 1, second.planDrifts)
+	require.Equal(t, 1, third.planDrifts)
+}
+
+func TestBackends(t *testing.T) {
+	first := &countingNotification{}
+	second := &countingNotification{}
+	third := &countingNotification{}
+	n := &Multi{Notifications: []Notification{first, &Multi{Notifications: []Notification{second, third}, MaxConcurrentBackends: 2}}}
+	require.Equal(t, []Notification{first, second, third}, Backends(n))
+	require.Equal(t, []Notification{first}, Backends(first))
+}
+
+type orderedNotification struct {
+	Notification
+	name  string
+	order *[]string
+}
+
+func (o *orderedNotification) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	*o.order = append(*o.order, o.name)
+	return nil
+}
+
+func TestMulti_PlanDriftNotifiesBackendsInOrder(t *testing.T) {
+	var order []string
+	m := &Multi{Notifications: []Notification{
+		&orderedNotification{name: "first", order: &order},
+		&orderedNotification{name: "second", order: &order},
+		&orderedNotification{name: "third", order: &order},
+	}}
+	require.NoError(t, m.PlanDrift(context.Background(), "dir", "workspace", "cliffnote"))
+	require.Equal(t, []string{"first", "second", "third"}, order)
+}
+
+func TestMulti_Generic(t *testing.T) {
+	genericNotificationTest(t, &Multi{Notifications: []Notification{&Zap{Logger: zaptest.NewLogger(t)}}})
+}
+
+type blockingNotification struct {
+	Notification
+	running    *int32
+	maxRunning *int32
+	mu         *sync.Mutex
+	err        error
+}
+
+func (b *blockingNotification) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	now := atomic.AddInt32(b.running, 1)
+	b.mu.Lock()
+	if now > *b.maxRunning {
+		*b.maxRunning = now
+	}
+	b.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(b.running, -1)
+	return b.err
+}
+
+func TestMulti_PlanDriftBoundsConcurrentBackends(t *testing.T) {
+	var running, maxRunning int32
+	var mu sync.Mutex
+	errBackend := errors.New("backend failed")
+	backends := make([]Notification, 0, 6)
+	for i := 0; i < 6; i++ {
+		b := &blockingNotification{running: &running, maxRunning: &maxRunning, mu: &mu}
+		if i == 3 {
+			b.err = errBackend
+		}
+		backends = append(backends, b)
+	}
+	m := &Multi{Notifications: backends, MaxConcurrentBackends: 2}
+	err := m.PlanDrift(context.Background(), "dir", "workspace", "cliffnote")
+	require.ErrorIs(t, err, errBackend)
+	require.LessOrEqual(t, maxRunning, int32(2))
+	require.Greater(t, maxRunning, int32(0))
+}
+
+type stuckNotification struct {
+	Notification
+	release chan struct{}
+}
+
+func (s *stuckNotification) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	<-s.release
+	return nil
+}
+
+type signalingNotification struct {
+	Notification
+	done chan struct{}
+}
+
+func (s *signalingNotification) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	close(s.done)
+	return nil
+}
+
+func TestMulti_SlowBackendDoesNotBlockOthers(t *testing.T) {
+	stuck := &stuckNotification{release: make(chan struct{})}
+	second := &signalingNotification{done: make(chan struct{})}
+	third := &signalingNotification{done: make(chan struct{})}
+	m := &Multi{Notifications: []Notification{stuck, second, third}, MaxConcurrentBackends: 2}
+	finished := make(chan error)
+	go func() {
+		finished <- m.PlanDrift(context.Background(), "dir", "workspace", "cliffnote")
+	}()
+	for _, n := range []*signalingNotification{second, third} {
+		select {
+		case <-n.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("backend was blocked behind a stuck backend")
+		}
+	}
+	select {
+	case <-finished:
+		t.Fatal("returned before the stuck backend finished")
+	default:
+	}
+	close(stuck.release)
+	require.NoError(t, <-finished)
+}
+
+func TestMulti_ConcurrentGeneric(t *testing.T) {
+	genericNotificationTest(t, &Multi{Notifications: []Notification{&Zap{Logger: zaptest.NewLogger(t)}}, MaxConcurrentBackends: 2})
+}
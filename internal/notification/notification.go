@@ -2,6 +2,7 @@ package notification
 
 import (
 	"context"
+	"time"
 )
 
 type State int
@@ -13,6 +14,38 @@ const (
 	StateMissingWorkspaceInRemote
 )
 
+// PhaseTiming is how long one phase of a run took
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// RunSummary describes a finished drift run
+type RunSummary struct {
+	Repo                string
+	Ref                 string
+	Duration            time.Duration
+	WorkspacesDrifted   int32
+	WorkspacesUndrifted int32
+	TotalWorkspaces     int32
+	// Phases are the phases the run did, like drift and workspaces
+	Phases []string
+	// Error is set if the run failed
+	Error string
+	// Drifted are the workspaces the run found drifted at the planned ref, including ones whose PlanDrift was held
+	// back by a cap, a grace period or a re-notify interval
+	Drifted []DriftedWorkspace
+	// DriftSuppressedReason is set if the run suppressed every drift notification, like in a maintenance window
+	DriftSuppressedReason string
+}
+
+// DriftedWorkspace is a workspace a run found drifted
+type DriftedWorkspace struct {
+	Directory string
+	Workspace string
+	Cliffnote string
+}
+
 type Location struct {
 	Directory string
 	Workspace string
@@ -22,7 +55,142 @@ type Notification interface {
 	ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
 	MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
 	PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error
+	// RefPlanDrift is called when planning against one of the comparison refs, rather than the default ref, has changes
+	RefPlanDrift(ctx context.Context, ref string, dir string, workspace string, cliffnote string) error
+	// PendingApply is called instead of PlanDrift when open pull requests change the drifted directory, so the drift
+	// is probably about to be applied. pullRequests are their URLs.
+	PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error
+	// PlanError is called when atlantis returned a plan whose output contains terraform errors
+	PlanError(ctx context.Context, dir string, workspace string, planError string) error
 	WorkspaceDriftSummary(ctx context.Context, workspacesDrifted int32, workspacesUndrifted int32, totalWorkspaces int32) error
+	// WorkspaceAuditSummary is called at the end of a run with the number of extra and missing remote workspaces found
+	WorkspaceAuditSummary(ctx context.Context, extraWorkspaces int32, missingWorkspaces int32) error
 	// TemporaryError is called when an error occurs but we can't really tell what it means
 	TemporaryError(ctx context.Context, dir string, workspace string, err error) error
+	// UnmanagedDirectory is called for a terraform root module on disk that has no atlantis project
+	UnmanagedDirectory(ctx context.Context, dir string) error
+	// CachedResultsWarning is called when a large share of workspaces were served from cache rather than re-checked
+	CachedResultsWarning(ctx context.Context, cachedWorkspaces int32, totalWorkspaces int32, oldestCheck time.Time) error
+	// DriftNotificationsSuppressed is called at the end of a run where drift was found but not notified
+	DriftNotificationsSuppressed(ctx context.Context, reason string, suppressedDrifts int32) error
+	// DriftResolved is called for a clean workspace, unless the result cache shows it was clean at its previous check
+	// too. Without a cache, or once its entry expires, every clean workspace gets it, so backends must ignore
+	// workspaces they know of no drift for.
+	DriftResolved(ctx context.Context, dir string, workspace string) error
+	// PlanLocked is called for a workspace whose plan could not run because the project is locked
+	PlanLocked(ctx context.Context, dir string, workspace string) error
+	// NeverPlanned is called for a workspace atlantis returned no plan for, because the project has never been
+	// planned. Such workspaces are not actually managed.
+	NeverPlanned(ctx context.Context, dir string, workspace string) error
+	// OrphanedState is called for a state file in the remote backend whose directory has no atlantis project
+	OrphanedState(ctx context.Context, dir string, stateKey string) error
+	// NoProjectsFound is called when the atlantis config at configPath has no projects, which usually means it is
+	// misconfigured
+	NoProjectsFound(ctx context.Context, repo string, configPath string) error
+	// DuplicateProject is called when several projects in the atlantis config share a name. dirs are the directories
+	// of those projects.
+	DuplicateProject(ctx context.Context, name string, dirs []string) error
+	// RunStarted is called at the start of every run, before any other notification. ref is the ref planned, which
+	// is only empty if detecting the default branch failed.
+	RunStarted(ctx context.Context, repo string, ref string) error
+	// RunFinished is called at the end of every run, including failed ones
+	RunFinished(ctx context.Context, summary RunSummary) error
+	// RunTimings is called at the end of a run with its total duration and how long each phase took
+	RunTimings(ctx context.Context, total time.Duration, phases []PhaseTiming) error
+	// TestMessage is called by a notification self-test. It sends message, or otherwise checks the backend is
+	// reachable, without side effects beyond the message itself.
+	TestMessage(ctx context.Context, message string) error
+}
+
+// Noop ignores every event. Backends embed it so they only implement the events they act on.
+type Noop struct{}
+
+func (Noop) ExtraWorkspaceInRemote(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) MissingWorkspaceInRemote(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) PlanDrift(_ context.Context, _ string, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) RefPlanDrift(_ context.Context, _ string, _ string, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) PendingApply(_ context.Context, _ string, _ string, _ []string) error {
+	return nil
+}
+
+func (Noop) PlanError(_ context.Context, _ string, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) WorkspaceDriftSummary(_ context.Context, _ int32, _ int32, _ int32) error {
+	return nil
+}
+
+func (Noop) WorkspaceAuditSummary(_ context.Context, _ int32, _ int32) error {
+	return nil
+}
+
+func (Noop) TemporaryError(_ context.Context, _ string, _ string, _ error) error {
+	return nil
 }
+
+func (Noop) UnmanagedDirectory(_ context.Context, _ string) error {
+	return nil
+}
+
+func (Noop) CachedResultsWarning(_ context.Context, _ int32, _ int32, _ time.Time) error {
+	return nil
+}
+
+func (Noop) DriftNotificationsSuppressed(_ context.Context, _ string, _ int32) error {
+	return nil
+}
+
+func (Noop) DriftResolved(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) PlanLocked(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) NeverPlanned(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) OrphanedState(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) NoProjectsFound(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) DuplicateProject(_ context.Context, _ string, _ []string) error {
+	return nil
+}
+
+func (Noop) RunStarted(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (Noop) RunFinished(_ context.Context, _ RunSummary) error {
+	return nil
+}
+
+func (Noop) RunTimings(_ context.Context, _ time.Duration, _ []PhaseTiming) error {
+	return nil
+}
+
+func (Noop) TestMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+var _ Notification = Noop{}
@@ -3,6 +3,7 @@ package notification
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -12,4 +13,18 @@ func genericNotificationTest(t *testing.T, notification Notification) {
 	require.NoError(t, notification.ExtraWorkspaceInRemote(ctx, "genericNotificationTest/ExtraWorkspaceInRemote", "test-workspace"))
 	require.NoError(t, notification.MissingWorkspaceInRemote(ctx, "genericNotificationTest/MissingWorkspaceInRemote", "test-workspace"))
 	require.NoError(t, notification.PlanDrift(ctx, "genericNotificationTest/PlanDrift", "test-workspace", "test-cliffnote"))
+	require.NoError(t, notification.RefPlanDrift(ctx, "test-ref", "genericNotificationTest/RefPlanDrift", "test-workspace", "test-cliffnote"))
+	require.NoError(t, notification.PlanError(ctx, "genericNotificationTest/PlanError", "test-workspace", "Error: test-error"))
+	require.NoError(t, notification.UnmanagedDirectory(ctx, "genericNotificationTest/UnmanagedDirectory"))
+	require.NoError(t, notification.PlanLocked(ctx, "genericNotificationTest/PlanLocked", "default"))
+	require.NoError(t, notification.DriftResolved(ctx, "genericNotificationTest/DriftResolved", "default"))
+	require.NoError(t, notification.NeverPlanned(ctx, "genericNotificationTest/NeverPlanned", "default"))
+	require.NoError(t, notification.OrphanedState(ctx, "genericNotificationTest/OrphanedState", "genericNotificationTest/OrphanedState/terraform.tfstate"))
+	require.NoError(t, notification.PendingApply(ctx, "genericNotificationTest/PendingApply", "default", []string{"https://github.com/owner/repo/pull/1"}))
+	require.NoError(t, notification.NoProjectsFound(ctx, "genericNotificationTest/NoProjectsFound", "atlantis.yaml"))
+	require.NoError(t, notification.DuplicateProject(ctx, "genericNotificationTest-DuplicateProject", []string{"genericNotificationTest/a", "genericNotificationTest/b"}))
+	require.NoError(t, notification.RunStarted(ctx, "genericNotificationTest/RunStarted", "main"))
+	require.NoError(t, notification.RunFinished(ctx, RunSummary{Repo: "genericNotificationTest/RunFinished", Ref: "main", Duration: time.Minute, TotalWorkspaces: 1}))
+	require.NoError(t, notification.RunTimings(ctx, time.Minute, []PhaseTiming{{Phase: "checkout", Duration: time.Second}}))
+	require.NoError(t, notification.TestMessage(ctx, "genericNotificationTest/TestMessage"))
 }
@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Ordered buffers events about a directory and sends them sorted by directory and workspace when flushed, so
+// parallel checks still produce notifications in a reproducible order. Run-level events, like summaries, are sent
+// immediately. It is safe to record events from many goroutines while another flushes: each Flush sends a snapshot
+// of what was buffered when it started, so every event is sent exactly once.
+type Ordered struct {
+	Notification
+
+	mu      sync.Mutex
+	pending []orderedEvent
+}
+
+type orderedEvent struct {
+	dir       string
+	workspace string
+	send      func(ctx context.Context) error
+}
+
+func (o *Ordered) buffer(dir string, workspace string, send func(ctx context.Context) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, orderedEvent{dir: dir, workspace: workspace, send: send})
+	return nil
+}
+
+// Flush sends every buffered event in directory then workspace order. Events for the same workspace keep the order
+// they were buffered in.
+func (o *Ordered) Flush(ctx context.Context) error {
+	o.mu.Lock()
+	pending := o.pending
+	o.pending = nil
+	o.mu.Unlock()
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].dir != pending[j].dir {
+			return pending[i].dir < pending[j].dir
+		}
+		return pending[i].workspace < pending[j].workspace
+	})
+	var errs []error
+	for _, e := range pending {
+		if err := e.send(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o *Ordered) ExtraWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.ExtraWorkspaceInRemote(ctx, dir, workspace)
+	})
+}
+
+func (o *Ordered) MissingWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.MissingWorkspaceInRemote(ctx, dir, workspace)
+	})
+}
+
+func (o *Ordered) PlanDrift(_ context.Context, dir string, workspace string, cliffnote string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.PlanDrift(ctx, dir, workspace, cliffnote)
+	})
+}
+
+func (o *Ordered) DriftResolved(_ context.Context, dir string, workspace string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.DriftResolved(ctx, dir, workspace)
+	})
+}
+
+func (o *Ordered) PendingApply(_ context.Context, dir string, workspace string, pullRequests []string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.PendingApply(ctx, dir, workspace, pullRequests)
+	})
+}
+
+func (o *Ordered) RefPlanDrift(_ context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.RefPlanDrift(ctx, ref, dir, workspace, cliffnote)
+	})
+}
+
+func (o *Ordered) PlanError(_ context.Context, dir string, workspace string, planError string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.PlanError(ctx, dir, workspace, planError)
+	})
+}
+
+func (o *Ordered) TemporaryError(_ context.Context, dir string, workspace string, err error) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.TemporaryError(ctx, dir, workspace, err)
+	})
+}
+
+func (o *Ordered) UnmanagedDirectory(_ context.Context, dir string) error {
+	return o.buffer(dir, "", func(ctx context.Context) error {
+		return o.Notification.UnmanagedDirectory(ctx, dir)
+	})
+}
+
+func (o *Ordered) PlanLocked(_ context.Context, dir string, workspace string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.PlanLocked(ctx, dir, workspace)
+	})
+}
+
+func (o *Ordered) NeverPlanned(_ context.Context, dir string, workspace string) error {
+	return o.buffer(dir, workspace, func(ctx context.Context) error {
+		return o.Notification.NeverPlanned(ctx, dir, workspace)
+	})
+}
+
+var _ Notification = &Ordered{}
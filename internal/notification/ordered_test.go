@@ -0,0 +1,85 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type sequenceNotification struct {
+	Notification
+	events []string
+}
+
+func (s *sequenceNotification) PlanDrift(_ context.Context, dir string, workspace string, _ string) error {
+	s.events = append(s.events, fmt.Sprintf("drift %s/%s", dir, workspace))
+	return nil
+}
+
+func (s *sequenceNotification) PlanError(_ context.Context, dir string, workspace string, _ string) error {
+	s.events = append(s.events, fmt.Sprintf("error %s/%s", dir, workspace))
+	return nil
+}
+
+func TestOrdered_Flush(t *testing.T) {
+	ctx := context.Background()
+	seq := &sequenceNotification{}
+	o := &Ordered{Notification: seq}
+	require.NoError(t, o.PlanDrift(ctx, "b", "default", ""))
+	require.NoError(t, o.PlanError(ctx, "a", "prod", ""))
+	require.NoError(t, o.PlanDrift(ctx, "a", "dev", ""))
+	require.NoError(t, o.PlanDrift(ctx, "a", "prod", ""))
+	require.Empty(t, seq.events)
+	require.NoError(t, o.Flush(ctx))
+	require.Equal(t, []string{"drift a/dev", "error a/prod", "drift a/prod", "drift b/default"}, seq.events)
+	require.NoError(t, o.Flush(ctx))
+	require.Len(t, seq.events, 4)
+}
+
+func TestOrdered_Generic(t *testing.T) {
+	o := &Ordered{Notification: &Zap{Logger: zaptest.NewLogger(t)}}
+	genericNotificationTest(t, o)
+	require.NoError(t, o.Flush(context.Background()))
+}
+
+func TestOrdered_ConcurrentPlanDrift(t *testing.T) {
+	const workers = 16
+	const perWorker = 50
+	ctx := context.Background()
+	seq := &sequenceNotification{}
+	o := &Ordered{Notification: seq}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				require.NoError(t, o.PlanDrift(ctx, fmt.Sprintf("dir%02d", w), fmt.Sprintf("ws%02d", i), ""))
+			}
+		}(w)
+	}
+	// Flush while events are still being buffered: every event must land in exactly one snapshot
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, o.Flush(ctx))
+		}
+	}()
+	wg.Wait()
+	<-flushed
+	require.NoError(t, o.Flush(ctx))
+
+	seen := make(map[string]int)
+	for _, e := range seq.events {
+		seen[e]++
+	}
+	require.Len(t, seen, workers*perWorker)
+	for e, count := range seen {
+		require.Equal(t, 1, count, e)
+	}
+}
@@ -0,0 +1,160 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultPagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const DefaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty sends PagerDuty Events API v2 events so drift pages the on-call team. Drift triggers an alert, and the
+// alert resolves once the workspace is clean again. Alerts are deduplicated by repository, directory and workspace,
+// so a workspace that stays drifted keeps a single alert open. Every other event is ignored, including pending
+// applies, which someone is already handling.
+type PagerDuty struct {
+	Noop
+	RoutingKey string
+	HTTPClient *http.Client
+	// EventsURL defaults to DefaultPagerDutyEventsURL
+	EventsURL string
+	// Severity of triggered alerts, one of critical, error, warning or info. Empty is error.
+	Severity string
+	// Repo is the repository in dedup keys. If empty, the repository RunStarted names is used, and events before any
+	// run has started fail rather than send keys without a repository.
+	Repo string
+
+	mu sync.Mutex
+	// runRepo is the repository of the current run, as named by RunStarted
+	runRepo string
+}
+
+var pagerDutySeverities = map[string]struct{}{"critical": {}, "error": {}, "warning": {}, "info": {}}
+
+// NewPagerDuty returns a PagerDuty notification for routingKey, the integration key of a PagerDuty service. An empty
+// routingKey returns nil.
+func NewPagerDuty(routingKey string, severity string, HTTPClient *http.Client) (*PagerDuty, error) {
+	if routingKey == "" {
+		return nil, nil
+	}
+	if _, exists := pagerDutySeverities[severity]; severity != "" && !exists {
+		return nil, fmt.Errorf("unknown pagerduty severity: %s", severity)
+	}
+	return &PagerDuty{
+		RoutingKey: routingKey,
+		HTTPClient: HTTPClient,
+		Severity:   severity,
+	}, nil
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+func (p *PagerDuty) currentRepo() (string, error) {
+	if p.Repo != "" {
+		return p.Repo, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.runRepo == "" {
+		return "", fmt.Errorf("pagerduty notification has no repository for dedup keys: set Repo or start a run")
+	}
+	return p.runRepo, nil
+}
+
+func pagerDutyDedupKey(repo string, dir string, workspace string) string {
+	return fmt.Sprintf("%s/%s/%s", repo, dir, workspace)
+}
+
+func (p *PagerDuty) send(ctx context.Context, event pagerDutyEvent) error {
+	event.RoutingKey = p.RoutingKey
+	b, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty event: %w", err)
+	}
+	url := p.EventsURL
+	if url == "" {
+		url = DefaultPagerDutyEventsURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create pagerduty request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send pagerduty event: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to send pagerduty event: status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// PlanDrift triggers an alert for the workspace, or adds to the open one
+func (p *PagerDuty) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	severity := p.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	repo, err := p.currentRepo()
+	if err != nil {
+		return err
+	}
+	return p.send(ctx, pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(repo, dir, workspace),
+		Payload: &pagerDutyPayload{
+			Summary:       fmt.Sprintf("Terraform drift in %s: %s (%s)", repo, dir, workspace),
+			Source:        repo,
+			Severity:      severity,
+			Component:     dir,
+			Group:         workspace,
+			CustomDetails: map[string]string{"cliffnote": cliffnote},
+		},
+	})
+}
+
+// DriftResolved resolves the alert of the workspace. Resolving a workspace that has no open alert does nothing.
+func (p *PagerDuty) DriftResolved(ctx context.Context, dir string, workspace string) error {
+	repo, err := p.currentRepo()
+	if err != nil {
+		return err
+	}
+	return p.send(ctx, pagerDutyEvent{EventAction: "resolve", DedupKey: pagerDutyDedupKey(repo, dir, workspace)})
+}
+
+// RunStarted remembers the repository of the run for dedup keys, unless Repo is set
+func (p *PagerDuty) RunStarted(_ context.Context, repo string, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runRepo = repo
+	return nil
+}
+
+// TestMessage resolves an alert that does not exist, which checks the routing key without paging anyone
+func (p *PagerDuty) TestMessage(ctx context.Context, _ string) error {
+	return p.send(ctx, pagerDutyEvent{EventAction: "resolve", DedupKey: "atlantis-drift-detection-notification-test"})
+}
+
+var _ Notification = &PagerDuty{}
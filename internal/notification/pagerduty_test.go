@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagerDuty(t *testing.T) {
+	var mu sync.Mutex
+	var events []pagerDutyEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	pd, err := NewPagerDuty("routing-key", "critical", srv.Client())
+	require.NoError(t, err)
+	pd.EventsURL = srv.URL
+	ctx := context.Background()
+	require.Error(t, pd.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"), "dedup keys need a repository")
+	pd.Repo = "org/other"
+	genericNotificationTest(t, pd)
+	for _, e := range events {
+		require.Regexp(t, `^(org/other/|atlantis-drift-detection-notification-test$)`, e.DedupKey)
+	}
+
+	pd.Repo = ""
+	events = nil
+	require.NoError(t, pd.RunStarted(ctx, "org/repo", ""))
+	require.NoError(t, pd.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"))
+	require.NoError(t, pd.PendingApply(ctx, "dir", "prod", []string{"https://github.com/org/repo/pull/1"}))
+	require.NoError(t, pd.DriftResolved(ctx, "dir", "default"))
+	require.Equal(t, []pagerDutyEvent{
+		{
+			RoutingKey:  "routing-key",
+			EventAction: "trigger",
+			DedupKey:    "org/repo/dir/default",
+			Payload: &pagerDutyPayload{
+				Summary:       "Terraform drift in org/repo: dir (default)",
+				Source:        "org/repo",
+				Severity:      "critical",
+				Component:     "dir",
+				Group:         "default",
+				CustomDetails: map[string]string{"cliffnote": "Plan: 1 to add"},
+			},
+		},
+		{RoutingKey: "routing-key", EventAction: "resolve", DedupKey: "org/repo/dir/default"},
+	}, events)
+}
+
+func TestNewPagerDuty(t *testing.T) {
+	pd, err := NewPagerDuty("", "", http.DefaultClient)
+	require.NoError(t, err)
+	require.Nil(t, pd)
+	_, err = NewPagerDuty("routing-key", "urgent", http.DefaultClient)
+	require.Error(t, err)
+}
+
+func TestPagerDuty_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"status":"invalid event"}`))
+	}))
+	defer srv.Close()
+	pd := &PagerDuty{RoutingKey: "routing-key", HTTPClient: srv.Client(), EventsURL: srv.URL}
+	require.ErrorContains(t, pd.TestMessage(context.Background(), "test"), "invalid event")
+}
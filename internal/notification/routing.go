@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Routing sends events about a directory to the backend its route names, in addition to the embedded default
+// Notification, so teams get alerts for the projects they own while the default backends still see everything.
+// Run-level events, like summaries, only go to the default.
+type Routing struct {
+	Notification
+	// Backends are the notification targets a route can name
+	Backends map[string]Notification
+	// Routes maps a directory to the name of its backend
+	Routes map[string]string
+}
+
+func (r *Routing) route(dir string, f func(n Notification) error) error {
+	err := f(r.Notification)
+	target, ok := r.Routes[dir]
+	if !ok {
+		// Directories checked once per backend config are reported as dir[config]
+		if idx := strings.LastIndex(dir, "["); idx > 0 && strings.HasSuffix(dir, "]") {
+			target, ok = r.Routes[dir[:idx]]
+		}
+	}
+	if !ok {
+		return err
+	}
+	if backend, exists := r.Backends[target]; exists {
+		return errors.Join(err, f(backend))
+	}
+	return err
+}
+
+func (r *Routing) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
+	return r.route(dir, func(n Notification) error {
+		return n.TemporaryError(ctx, dir, workspace, err)
+	})
+}
+
+func (r *Routing) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.ExtraWorkspaceInRemote(ctx, dir, workspace)
+	})
+}
+
+func (r *Routing) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.MissingWorkspaceInRemote(ctx, dir, workspace)
+	})
+}
+
+func (r *Routing) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.PlanDrift(ctx, dir, workspace, cliffnote)
+	})
+}
+
+func (r *Routing) PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.PendingApply(ctx, dir, workspace, pullRequests)
+	})
+}
+
+func (r *Routing) RefPlanDrift(ctx context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.RefPlanDrift(ctx, ref, dir, workspace, cliffnote)
+	})
+}
+
+func (r *Routing) PlanError(ctx context.Context, dir string, workspace string, planError string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.PlanError(ctx, dir, workspace, planError)
+	})
+}
+
+func (r *Routing) PlanLocked(ctx context.Context, dir string, workspace string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.PlanLocked(ctx, dir, workspace)
+	})
+}
+
+func (r *Routing) DriftResolved(ctx context.Context, dir string, workspace string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.DriftResolved(ctx, dir, workspace)
+	})
+}
+
+func (r *Routing) NeverPlanned(ctx context.Context, dir string, workspace string) error {
+	return r.route(dir, func(n Notification) error {
+		return n.NeverPlanned(ctx, dir, workspace)
+	})
+}
+
+var _ Notification = &Routing{}
@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestRouting_PlanDrift(t *testing.T) {
+	def := &countingNotification{}
+	teamA := &countingNotification{}
+	r := &Routing{
+		Notification: def,
+		Backends:     map[string]Notification{"team-a": teamA},
+		Routes:       map[string]string{"a": "team-a", "b": "unknown-team"},
+	}
+	ctx := context.Background()
+	require.NoError(t, r.PlanDrift(ctx, "a", "default", ""))
+	require.NoError(t, r.PlanDrift(ctx, "a[prod.tfbackend]", "default", ""))
+	require.NoError(t, r.PlanDrift(ctx, "b", "default", ""))
+	require.NoError(t, r.PlanDrift(ctx, "c", "default", ""))
+	require.Equal(t, 4, def.planDrifts)
+	require.Equal(t, 2, teamA.planDrifts)
+}
+
+func TestRouting_Generic(t *testing.T) {
+	zapNotification := &Zap{Logger: zaptest.NewLogger(t)}
+	genericNotificationTest(t, &Routing{
+		Notification: zapNotification,
+		Backends:     map[string]Notification{"team": zapNotification},
+		Routes:       map[string]string{"genericNotificationTest/PlanDrift": "team"},
+	})
+}
@@ -0,0 +1,94 @@
+package notification
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// slackBlock is a Block Kit block. Only the fields of the block types used here are included.
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// slackSectionLimit is the longest text Slack accepts in a section block
+const slackSectionLimit = 3000
+
+// slackHeaderLimit is the most characters Slack accepts in a header block
+const slackHeaderLimit = 150
+
+var planCountRe = regexp.MustCompile(`(\d+) to (add|change|destroy)`)
+var planLineRe = regexp.MustCompile(`(?m)^Plan:.*$`)
+
+// planCounts sums the add, change and destroy counts of every "Plan:" line in cliffnote. ok is false if there are
+// none.
+func planCounts(cliffnote string) (add int, change int, destroy int, ok bool) {
+	for _, line := range planLineRe.FindAllString(cliffnote, -1) {
+		for _, m := range planCountRe.FindAllStringSubmatch(line, -1) {
+			n, err := strconv.Atoi(m[1])
+			if err != nil {
+				continue
+			}
+			ok = true
+			switch m[2] {
+			case "add":
+				add += n
+			case "change":
+				change += n
+			case "destroy":
+				destroy += n
+			}
+		}
+	}
+	return add, change, destroy, ok
+}
+
+// driftBlocks lays out a drift message as a header with the plan counts, the location, and the cliffnote in a
+// section of its own, which Slack collapses behind "Show more" when it is long
+func (s *SlackWebhook) driftBlocks(title string, dir string, workspace string, cliffnote string) []slackBlock {
+	header := title
+	if add, change, destroy, ok := planCounts(cliffnote); ok {
+		header = fmt.Sprintf("%s: %d to add, %d to change, %d to destroy", title, add, change, destroy)
+	}
+	fields := []slackText{{Type: "mrkdwn", Text: s.sprintf("{root_module} *Root module:*\n`%s`", dir)}}
+	if workspace != "" {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Workspace:*\n`%s`", workspace)})
+	}
+	plan := "```\n" + cliffnote + "\n```"
+	if len(plan) > slackSectionLimit {
+		kept := strings.ToValidUTF8(cliffnote[:slackSectionLimit-len("```\n\n...\n```")], "")
+		plan = "```\n" + kept + "\n...\n```"
+	}
+	return []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncateHeader(s.sprintf("{drift} %s", header)), Emoji: true}},
+		{Type: "section", Fields: fields},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: plan}},
+	}
+}
+
+// truncateHeader shortens text to slackHeaderLimit characters, ending in "..." if anything was cut, since Slack
+// rejects the whole message when a header is too long
+func truncateHeader(text string) string {
+	runes := []rune(text)
+	if len(runes) <= slackHeaderLimit {
+		return text
+	}
+	return string(runes[:slackHeaderLimit-len("...")]) + "..."
+}
+
+// location is dir, with the workspace appended if there is one
+func location(dir string, workspace string) string {
+	if workspace == "" {
+		return dir
+	}
+	return dir + "#" + workspace
+}
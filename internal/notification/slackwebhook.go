@@ -4,14 +4,48 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/retrybudget"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/secrets"
 )
 
+// SlackWebhook posts events to a slack incoming webhook. Slack only hears about problems, so resolved drift is not
+// sent.
 type SlackWebhook struct {
+	Noop
 	WebhookURL string
 	HTTPClient *http.Client
+	// Retries is how many more times a failed message is sent before giving up on it
+	Retries int
+	// RetryDelay is the delay between retries
+	RetryDelay time.Duration
+	// RetryBudget, if set, is shared with every other retrying caller in the run. Messages stop being retried once it
+	// is exhausted.
+	RetryBudget *retrybudget.Budget
+	// DeadLetter, if set, keeps messages that could not be sent, so they can be sent again later
+	DeadLetter *DeadLetter
+	// RunLifecycle sends a message when each run starts and finishes, as a heartbeat for scheduled runs
+	RunLifecycle bool
+	// Emoji overrides DefaultSlackEmoji, mapping each icon name to an emoji available in the slack workspace. An
+	// empty emoji removes the icon.
+	Emoji map[string]string
+	// UseBlocks sends drift messages as Block Kit blocks, with the plan counts in a header and the cliffnote in a
+	// section Slack collapses, instead of plain text
+	UseBlocks bool
+	// Now returns the time cached results are aged against, so their age can be tested. It defaults to time.Now.
+	Now func() time.Time
+}
+
+func (s *SlackWebhook) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
 }
 
 func (s *SlackWebhook) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
@@ -28,19 +62,91 @@ func NewSlackWebhook(webhookURL string, HTTPClient *http.Client) *SlackWebhook {
 	}
 }
 
+// NewSlackWebhookFromSecret is NewSlackWebhook with the webhook URL resolved from a secret provider, so the raw URL
+// never needs to appear in configuration. An empty reference returns nil.
+func NewSlackWebhookFromSecret(ctx context.Context, provider secrets.Provider, webhookURLRef string, HTTPClient *http.Client) (*SlackWebhook, error) {
+	if webhookURLRef == "" {
+		return nil, nil
+	}
+	webhookURL, err := provider.Resolve(ctx, webhookURLRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve slack webhook url: %w", err)
+	}
+	return NewSlackWebhook(webhookURL, HTTPClient), nil
+}
+
+// DefaultSlackEmoji maps the name of each icon in slack messages to the emoji it is shown as
+var DefaultSlackEmoji = map[string]string{
+	"drift":         "exclamation",
+	"root_module":   "terraform",
+	"result":        "pencil",
+	"plan_error":    "x",
+	"clean":         "checked_animated",
+	"drift_summary": "checkered_flag",
+	"audit":         "mag",
+	"cache_warning": "warning",
+	"suppressed":    "mute",
+	"locked":        "lock",
+	"never_planned": "ghost",
+	"orphaned":      "wastebasket",
+	"no_projects":   "warning",
+	"duplicate":     "twisted_rightwards_arrows",
+	"pending_apply": "hourglass_flowing_sand",
+	"run_started":   "arrow_forward",
+	"run_failed":    "x",
+	"run_finished":  "checkered_flag",
+	"timings":       "stopwatch",
+}
+
+// sprintf is fmt.Sprintf with every {icon} in format replaced by its emoji
+func (s *SlackWebhook) sprintf(format string, args ...interface{}) string {
+	pairs := make([]string, 0, 2*len(DefaultSlackEmoji))
+	for name, emoji := range DefaultSlackEmoji {
+		if override, exists := s.Emoji[name]; exists {
+			emoji = override
+		}
+		if emoji == "" {
+			// Drop the space after a removed icon too, so the message does not start with one
+			pairs = append(pairs, "{"+name+"} ", "", "{"+name+"}", "")
+			continue
+		}
+		pairs = append(pairs, "{"+name+"}", ":"+strings.Trim(emoji, ":")+":")
+	}
+	return fmt.Sprintf(strings.NewReplacer(pairs...).Replace(format), args...)
+}
+
 type SlackWebhookMessage struct {
-	Text string `json:"text"`
+	// Text is the whole message, or with Blocks the fallback shown in notifications
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks,omitempty"`
 }
 
 func (s *SlackWebhook) sendSlackMessage(ctx context.Context, msg string) error {
-	body := SlackWebhookMessage{
-		Text: msg,
-	}
+	return s.sendSlackPayload(ctx, SlackWebhookMessage{Text: msg})
+}
+
+func (s *SlackWebhook) sendSlackPayload(ctx context.Context, body SlackWebhookMessage) error {
 	b, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("failed to marshal slack webhook message: %w", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(b))
+	err = s.send(ctx, b)
+	for attempt := 0; err != nil && attempt < s.Retries && s.RetryBudget.Take(); attempt++ {
+		select {
+		case <-time.After(s.RetryDelay):
+		case <-ctx.Done():
+			return errors.Join(err, s.DeadLetter.Add(s.DeadLetterName(), b))
+		}
+		err = s.send(ctx, b)
+	}
+	if err != nil {
+		return errors.Join(err, s.DeadLetter.Add(s.DeadLetterName(), b))
+	}
+	return nil
+}
+
+func (s *SlackWebhook) send(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(payload))
 	if err != nil {
 		return fmt.Errorf("failed to create slack webhook request: %w", err)
 	}
@@ -49,12 +155,25 @@ func (s *SlackWebhook) sendSlackMessage(ctx context.Context, msg string) error {
 	if err != nil {
 		return fmt.Errorf("failed to send slack webhook request: %w", err)
 	}
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("failed to close slack webhook response: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send slack webhook request: %w", err)
+		return fmt.Errorf("failed to send slack webhook request: status %d", resp.StatusCode)
 	}
 	return nil
 }
 
+// DeadLetterName implements DeadLetterSender
+func (s *SlackWebhook) DeadLetterName() string {
+	return "slack"
+}
+
+// SendDeadLetter implements DeadLetterSender. It sends the payload once, leaving retries to the next replay.
+func (s *SlackWebhook) SendDeadLetter(ctx context.Context, payload []byte) error {
+	return s.send(ctx, payload)
+}
+
 func (s *SlackWebhook) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
 	msg := ""
 	if len(workspace) == 0 {
@@ -76,34 +195,157 @@ func (s *SlackWebhook) MissingWorkspaceInRemote(ctx context.Context, dir string,
 }
 
 func (s *SlackWebhook) PlanDrift(ctx context.Context, dir string, workspace string, cliffnote string) error {
+	if s.UseBlocks {
+		return s.sendSlackPayload(ctx, SlackWebhookMessage{
+			Text:   fmt.Sprintf("Drift detected in %s", location(dir, workspace)),
+			Blocks: s.driftBlocks("Drift detected", dir, workspace, cliffnote),
+		})
+	}
 	msg := ""
 	if len(workspace) == 0 {
 		if len(cliffnote) > 50 {
-			msg = fmt.Sprintf(":exclamation: *Drift detected*\n:terraform: *Root module:* `%s`\n:pencil: *Result:* \n```\n%s\n```", dir, cliffnote)
+			msg = s.sprintf("{drift} *Drift detected*\n{root_module} *Root module:* `%s`\n{result} *Result:* \n```\n%s\n```", dir, cliffnote)
 		} else {
-			msg = fmt.Sprintf(":exclamation: *Drift detected*\n:terraform: *Root module:* `%s`\n:pencil: *Result:* `%s`", dir, cliffnote)
+			msg = s.sprintf("{drift} *Drift detected*\n{root_module} *Root module:* `%s`\n{result} *Result:* `%s`", dir, cliffnote)
 		}
 	} else {
 		if len(cliffnote) > 50 {
-			msg = fmt.Sprintf(":exclamation: *Drift detected*\n:terraform: *Root module:* `%s`\nWorkspace: `%s`\n:pencil: *Result:* \n```\n%s\n```", dir, workspace, cliffnote)
+			msg = s.sprintf("{drift} *Drift detected*\n{root_module} *Root module:* `%s`\nWorkspace: `%s`\n{result} *Result:* \n```\n%s\n```", dir, workspace, cliffnote)
 		} else {
-			msg = fmt.Sprintf(":exclamation: *Drift detected*\n:terraform: *Root module:* `%s`\nWorkspace: `%s`\n:pencil: *Result:* `%s`", dir, workspace, cliffnote)
+			msg = s.sprintf("{drift} *Drift detected*\n{root_module} *Root module:* `%s`\nWorkspace: `%s`\n{result} *Result:* `%s`", dir, workspace, cliffnote)
 		}
 	}
 	return s.sendSlackMessage(ctx, msg)
 }
 
+func (s *SlackWebhook) RefPlanDrift(ctx context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	if s.UseBlocks {
+		return s.sendSlackPayload(ctx, SlackWebhookMessage{
+			Text:   fmt.Sprintf("Drift detected against %s in %s", ref, location(dir, workspace)),
+			Blocks: s.driftBlocks("Drift detected against "+ref, dir, workspace, cliffnote),
+		})
+	}
+	msg := ""
+	if len(workspace) == 0 {
+		msg = s.sprintf("{drift} *Drift detected against ref* `%s`\n{root_module} *Root module:* `%s`\n{result} *Result:* \n```\n%s\n```", ref, dir, cliffnote)
+	} else {
+		msg = s.sprintf("{drift} *Drift detected against ref* `%s`\n{root_module} *Root module:* `%s`\nWorkspace: `%s`\n{result} *Result:* \n```\n%s\n```", ref, dir, workspace, cliffnote)
+	}
+	return s.sendSlackMessage(ctx, msg)
+}
+
+func (s *SlackWebhook) PlanError(ctx context.Context, dir string, workspace string, planError string) error {
+	msg := ""
+	if len(workspace) == 0 {
+		msg = s.sprintf("{plan_error} *Plan failed*\n{root_module} *Root module:* `%s`\n{result} *Error:* \n```\n%s\n```", dir, planError)
+	} else {
+		msg = s.sprintf("{plan_error} *Plan failed*\n{root_module} *Root module:* `%s`\nWorkspace: `%s`\n{result} *Error:* \n```\n%s\n```", dir, workspace, planError)
+	}
+	return s.sendSlackMessage(ctx, msg)
+}
+
 func (s *SlackWebhook) WorkspaceDriftSummary(ctx context.Context, workspacesDrifted int32, workspacesUndrifted int32, totalWorkspaces int32) error {
 	var msgBuilder strings.Builder
 	if workspacesDrifted == 0 {
-		msgBuilder.WriteString(fmt.Sprintf(":checked_animated: *Total Workspaces Drifted:* 0 / %d", totalWorkspaces))
+		msgBuilder.WriteString(s.sprintf("{clean} *Total Workspaces Drifted:* 0 / %d", totalWorkspaces))
 	} else {
 		pct := (float32(workspacesDrifted) / float32(totalWorkspaces) * 100)
-		msgBuilder.WriteString(fmt.Sprintf(":checkered_flag: *Total Workspaces Drifted:* %d / %d (%.1f%%)", workspacesDrifted, totalWorkspaces, pct))
+		msgBuilder.WriteString(s.sprintf("{drift_summary} *Total Workspaces Drifted:* %d / %d (%.1f%%)", workspacesDrifted, totalWorkspaces, pct))
 	}
 	undriftPct := (float32(workspacesUndrifted) / float32(totalWorkspaces) * 100)
-	msgBuilder.WriteString(fmt.Sprintf("\n:checked_animated: *Total Workspaces Undrifted:* %d / %d (%.1f%%)", workspacesUndrifted, totalWorkspaces, undriftPct))
+	msgBuilder.WriteString(s.sprintf("\n{clean} *Total Workspaces Undrifted:* %d / %d (%.1f%%)", workspacesUndrifted, totalWorkspaces, undriftPct))
 	return s.sendSlackMessage(ctx, msgBuilder.String())
 }
 
+func (s *SlackWebhook) WorkspaceAuditSummary(ctx context.Context, extraWorkspaces int32, missingWorkspaces int32) error {
+	if extraWorkspaces == 0 && missingWorkspaces == 0 {
+		return s.sendSlackMessage(ctx, s.sprintf("{clean} *Workspace audit:* no extra or missing workspaces"))
+	}
+	return s.sendSlackMessage(ctx, s.sprintf("{audit} *Workspace audit:* %d extra, %d missing workspaces", extraWorkspaces, missingWorkspaces))
+}
+
+func (s *SlackWebhook) CachedResultsWarning(ctx context.Context, cachedWorkspaces int32, totalWorkspaces int32, oldestCheck time.Time) error {
+	age := s.now().Sub(oldestCheck).Round(time.Minute)
+	return s.sendSlackMessage(ctx, s.sprintf("{cache_warning} *%d / %d workspaces served from cache*, oldest checked %s ago", cachedWorkspaces, totalWorkspaces, age))
+}
+
+func (s *SlackWebhook) UnmanagedDirectory(ctx context.Context, dir string) error {
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Root module has no atlantis project\nRoot module: `%s`", dir))
+}
+
+func (s *SlackWebhook) DriftNotificationsSuppressed(ctx context.Context, reason string, suppressedDrifts int32) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{suppressed} *Drift alerts suppressed:* %d drifted workspaces were not notified (%s)", suppressedDrifts, reason))
+}
+
+func (s *SlackWebhook) PlanLocked(ctx context.Context, dir string, workspace string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{locked} *Plan locked, drift unknown*\nDirectory: `%s`\nWorkspace: `%s`", dir, workspace))
+}
+
+func (s *SlackWebhook) NeverPlanned(ctx context.Context, dir string, workspace string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{never_planned} *Never planned, not managed by atlantis*\nDirectory: `%s`\nWorkspace: `%s`", dir, workspace))
+}
+
+func (s *SlackWebhook) OrphanedState(ctx context.Context, dir string, stateKey string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{orphaned} *Orphaned state, directory has no atlantis project*\nDirectory: `%s`\nState: `%s`", dir, stateKey))
+}
+
+func (s *SlackWebhook) PendingApply(ctx context.Context, dir string, workspace string, pullRequests []string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{pending_apply} *Drift pending apply*\nDirectory: `%s`\nWorkspace: `%s`\nPull requests: %s", dir, workspace, strings.Join(pullRequests, " ")))
+}
+
+func (s *SlackWebhook) NoProjectsFound(ctx context.Context, repo string, configPath string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{no_projects} *Drift run found 0 projects, misconfigured?*\nRepo: `%s`\nConfig: `%s`", repo, configPath))
+}
+
+func (s *SlackWebhook) DuplicateProject(ctx context.Context, name string, dirs []string) error {
+	return s.sendSlackMessage(ctx, s.sprintf("{duplicate} *Several atlantis projects share a name*\nProject: `%s`\nDirectories: `%s`", name, strings.Join(dirs, "`, `")))
+}
+
+func (s *SlackWebhook) RunStarted(ctx context.Context, repo string, ref string) error {
+	if !s.RunLifecycle {
+		return nil
+	}
+	if ref == "" {
+		ref = "default branch"
+	}
+	return s.sendSlackMessage(ctx, s.sprintf("{run_started} *Drift run started*\nRepo: `%s`\nRef: `%s`", repo, ref))
+}
+
+func (s *SlackWebhook) RunFinished(ctx context.Context, summary RunSummary) error {
+	if !s.RunLifecycle {
+		return nil
+	}
+	if summary.Error != "" {
+		return s.sendSlackMessage(ctx, s.sprintf("{run_failed} *Drift run failed* after %s\nRepo: `%s`\nRef: `%s`\nError: %s", summary.Duration.Round(time.Second), summary.Repo, summary.Ref, summary.Error))
+	}
+	msg := s.sprintf("{run_finished} *Drift run finished* in %s\nRepo: `%s`\nRef: `%s`\nDrifted: %d/%d", summary.Duration.Round(time.Second), summary.Repo, summary.Ref, summary.WorkspacesDrifted, summary.TotalWorkspaces)
+	if len(summary.Phases) > 0 {
+		msg += fmt.Sprintf("\nPhases: %s", strings.Join(summary.Phases, ", "))
+	}
+	return s.sendSlackMessage(ctx, msg)
+}
+
+// RunTimings is sent alongside the run lifecycle messages, and only when the run recorded phase timings, so runs
+// without anything to report stay quiet
+func (s *SlackWebhook) RunTimings(ctx context.Context, total time.Duration, phases []PhaseTiming) error {
+	if !s.RunLifecycle || len(phases) == 0 {
+		return nil
+	}
+	parts := make([]string, 0, len(phases))
+	for _, p := range phases {
+		parts = append(parts, fmt.Sprintf("%s %s", p.Phase, p.Duration.Round(time.Second)))
+	}
+	return s.sendSlackMessage(ctx, s.sprintf("{timings} *Run took* %s (%s)", total.Round(time.Second), strings.Join(parts, ", ")))
+}
+
+// TestMessage sends message once, without retries or the dead letter file, so a broken webhook fails right away
+func (s *SlackWebhook) TestMessage(ctx context.Context, message string) error {
+	b, err := json.Marshal(SlackWebhookMessage{Text: message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack webhook message: %w", err)
+	}
+	return s.send(ctx, b)
+}
+
 var _ Notification = &SlackWebhook{}
+var _ DeadLetterSender = &SlackWebhook{}
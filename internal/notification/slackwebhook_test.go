@@ -1,10 +1,20 @@
 package notification
 
 import (
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/testhelper"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSlackWebhook_ExtraWorkspaceInRemote(t *testing.T) {
@@ -12,3 +22,113 @@ func TestSlackWebhook_ExtraWorkspaceInRemote(t *testing.T) {
 	wh := NewSlackWebhook(testhelper.EnvOrSkip(t, "SLACK_WEBHOOK_URL"), http.DefaultClient)
 	genericNotificationTest(t, wh)
 }
+
+func TestSlackWebhook_DeadLetter(t *testing.T) {
+	var up atomic.Bool
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	deadLetter := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	wh.Retries = 2
+	wh.DeadLetter = NewDeadLetter(deadLetter)
+	ctx := context.Background()
+
+	require.Error(t, wh.PlanDrift(ctx, "dir", "default", "Plan: 1 to add"))
+	require.Error(t, wh.PlanLocked(ctx, "dir", "prod"))
+	require.Equal(t, int32(6), requests.Load())
+	content, err := os.ReadFile(deadLetter)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "Plan: 1 to add")
+	require.Contains(t, string(content), "Plan locked")
+
+	require.Error(t, wh.DeadLetter.Replay(ctx, wh))
+	require.FileExists(t, deadLetter)
+
+	up.Store(true)
+	require.NoError(t, wh.DeadLetter.Replay(ctx, wh))
+	require.NoFileExists(t, deadLetter)
+	require.NoError(t, wh.DeadLetter.Replay(ctx, wh))
+}
+
+func TestSlackWebhook_Emoji(t *testing.T) {
+	wh := &SlackWebhook{}
+	require.Equal(t, ":exclamation: *Drift detected* in `a`", wh.sprintf("{drift} *Drift detected* in `%s`", "a"))
+	wh.Emoji = map[string]string{"drift": ":rotating_light:", "root_module": ""}
+	require.Equal(t, ":rotating_light: *Drift detected*\n*Root module:* `a`", wh.sprintf("{drift} *Drift detected*\n{root_module} *Root module:* `%s`", "a"))
+}
+
+func TestSlackWebhook_PlanDriftBlocks(t *testing.T) {
+	var got SlackWebhookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	ctx := context.Background()
+	cliffnote := "Note: Objects have changed outside of Terraform.\nPlan: 1 to add, 2 to change, 0 to destroy.\nPlan: 0 to add, 0 to change, 3 to destroy."
+
+	require.NoError(t, wh.PlanDrift(ctx, "dir", "prod", cliffnote))
+	require.Empty(t, got.Blocks)
+	require.Contains(t, got.Text, cliffnote)
+
+	wh.UseBlocks = true
+	require.NoError(t, wh.PlanDrift(ctx, "dir", "prod", cliffnote))
+	require.Equal(t, "Drift detected in dir#prod", got.Text)
+	require.Len(t, got.Blocks, 3)
+	require.Equal(t, "header", got.Blocks[0].Type)
+	require.Equal(t, ":exclamation: Drift detected: 1 to add, 2 to change, 3 to destroy", got.Blocks[0].Text.Text)
+	require.Len(t, got.Blocks[1].Fields, 2)
+	require.Contains(t, got.Blocks[1].Fields[1].Text, "`prod`")
+	require.Equal(t, "```\n"+cliffnote+"\n```", got.Blocks[2].Text.Text)
+
+	require.NoError(t, wh.PlanDrift(ctx, "dir", "", strings.Repeat("long line\n", 1000)))
+	require.Equal(t, ":exclamation: Drift detected", got.Blocks[0].Text.Text)
+	require.Len(t, got.Blocks[1].Fields, 1)
+	require.LessOrEqual(t, len(got.Blocks[2].Text.Text), slackSectionLimit)
+	require.True(t, strings.HasSuffix(got.Blocks[2].Text.Text, "\n...\n```"))
+
+	require.NoError(t, wh.RefPlanDrift(ctx, strings.Repeat("ü", 200), "dir", "prod", cliffnote))
+	require.Len(t, []rune(got.Blocks[0].Text.Text), slackHeaderLimit)
+	require.True(t, strings.HasSuffix(got.Blocks[0].Text.Text, "..."))
+}
+
+func TestSlackWebhook_CachedResultsWarning(t *testing.T) {
+	var got SlackWebhookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	wh.Now = func() time.Time { return now }
+	require.NoError(t, wh.CachedResultsWarning(context.Background(), 8, 10, now.Add(-90*time.Minute)))
+	require.Contains(t, got.Text, "*8 / 10 workspaces served from cache*, oldest checked 1h30m0s ago")
+}
+
+func TestSlackWebhook_RunTimings(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+	wh := NewSlackWebhook(srv.URL, srv.Client())
+	ctx := context.Background()
+	phases := []PhaseTiming{{Phase: "checkout", Duration: time.Second}}
+
+	require.NoError(t, wh.RunTimings(ctx, time.Minute, phases))
+	require.Equal(t, int32(0), requests.Load())
+
+	wh.RunLifecycle = true
+	require.NoError(t, wh.RunTimings(ctx, time.Minute, nil))
+	require.Equal(t, int32(0), requests.Load())
+	require.NoError(t, wh.RunTimings(ctx, time.Minute, phases))
+	require.Equal(t, int32(1), requests.Load())
+}
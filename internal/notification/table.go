@@ -0,0 +1,147 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultTableNoteWidth is how much of a cliffnote or error Table shows when NoteWidth is not set
+const defaultTableNoteWidth = 60
+
+// Table collects per-workspace events and writes them to Output as an aligned table when the run finishes, for
+// running the tool by hand. Clean workspaces send no event, so they are added with Clean.
+type Table struct {
+	Noop
+	Output io.Writer
+	// NoteWidth truncates the note column to this many characters. Zero uses defaultTableNoteWidth.
+	NoteWidth int
+
+	mu   sync.Mutex
+	rows []tableRow
+}
+
+type tableRow struct {
+	dir       string
+	workspace string
+	status    string
+	note      string
+}
+
+func (t *Table) add(dir string, workspace string, status string, note string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows = append(t.rows, tableRow{dir: dir, workspace: workspace, status: status, note: note})
+	return nil
+}
+
+// Clean adds a row for a workspace checked and found without drift
+func (t *Table) Clean(dir string, workspace string) {
+	_ = t.add(dir, workspace, "clean", "")
+}
+
+func (t *Table) truncate(note string) string {
+	width := t.NoteWidth
+	if width <= 0 {
+		width = defaultTableNoteWidth
+	}
+	note = strings.Join(strings.Fields(note), " ")
+	if r := []rune(note); len(r) > width {
+		return string(r[:width-3]) + "..."
+	}
+	return note
+}
+
+func (t *Table) TemporaryError(_ context.Context, dir string, workspace string, err error) error {
+	return t.add(dir, workspace, "error", err.Error())
+}
+
+func (t *Table) ExtraWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
+	return t.add(dir, workspace, "extra", "")
+}
+
+func (t *Table) MissingWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
+	return t.add(dir, workspace, "missing", "")
+}
+
+func (t *Table) PlanDrift(_ context.Context, dir string, workspace string, cliffnote string) error {
+	return t.add(dir, workspace, "drift", cliffnote)
+}
+
+func (t *Table) RefPlanDrift(_ context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	return t.add(dir, workspace, "drift@"+ref, cliffnote)
+}
+
+func (t *Table) PlanError(_ context.Context, dir string, workspace string, planError string) error {
+	return t.add(dir, workspace, "error", planError)
+}
+
+func (t *Table) UnmanagedDirectory(_ context.Context, dir string) error {
+	return t.add(dir, "", "unmanaged", "")
+}
+
+func (t *Table) PlanLocked(_ context.Context, dir string, workspace string) error {
+	return t.add(dir, workspace, "locked", "")
+}
+
+func (t *Table) NeverPlanned(_ context.Context, dir string, workspace string) error {
+	return t.add(dir, workspace, "never-planned", "")
+}
+
+func (t *Table) OrphanedState(_ context.Context, dir string, stateKey string) error {
+	return t.add(dir, "", "orphaned", stateKey)
+}
+
+func (t *Table) PendingApply(_ context.Context, dir string, workspace string, pullRequests []string) error {
+	return t.add(dir, workspace, "pending-apply", strings.Join(pullRequests, " "))
+}
+
+func (t *Table) NoProjectsFound(_ context.Context, _ string, configPath string) error {
+	return t.add(configPath, "", "no-projects", "")
+}
+
+func (t *Table) DuplicateProject(_ context.Context, name string, dirs []string) error {
+	return t.add(strings.Join(dirs, " "), "", "duplicate-project", name)
+}
+
+// RunFinished writes the table, sorted by directory and workspace, followed by the run summary. The summary names the
+// repository, since the table of each repository of a manifest is written in turn.
+func (t *Table) RunFinished(_ context.Context, summary RunSummary) error {
+	t.mu.Lock()
+	rows := t.rows
+	t.rows = nil
+	t.mu.Unlock()
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].dir != rows[j].dir {
+			return rows[i].dir < rows[j].dir
+		}
+		return rows[i].workspace < rows[j].workspace
+	})
+	w := tabwriter.NewWriter(t.Output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DIRECTORY\tWORKSPACE\tSTATUS\tNOTE")
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.dir, r.workspace, r.status, t.truncate(r.note))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write results table: %w", err)
+	}
+	counts := fmt.Sprintf("%d drifted, %d clean, %d checked in %s", summary.WorkspacesDrifted, summary.WorkspacesUndrifted, summary.TotalWorkspaces, summary.Duration.Round(time.Second))
+	if summary.Repo != "" {
+		counts = summary.Repo + ": " + counts
+	}
+	line := "\n" + counts + "\n"
+	if summary.Error != "" {
+		line += "Run failed: " + summary.Error + "\n"
+	}
+	if _, err := io.WriteString(t.Output, line); err != nil {
+		return fmt.Errorf("failed to write results table: %w", err)
+	}
+	return nil
+}
+
+var _ Notification = &Table{}
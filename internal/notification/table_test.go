@@ -0,0 +1,47 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable_RunFinished(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	table := &Table{Output: &out, NoteWidth: 20}
+	require.NoError(t, table.PlanDrift(ctx, "b", "prod", "Plan: 1 to add, 0 to change,\n0 to destroy."))
+	table.Clean("a", "default")
+	require.NoError(t, table.ExtraWorkspaceInRemote(ctx, "b", "old"))
+	require.NoError(t, table.TemporaryError(ctx, "c", "default", errors.New("timeout")))
+	require.NoError(t, table.RunFinished(ctx, RunSummary{WorkspacesDrifted: 1, WorkspacesUndrifted: 1, TotalWorkspaces: 3, Duration: 90 * time.Second}))
+	require.Equal(t, strings.Join([]string{
+		"DIRECTORY  WORKSPACE  STATUS  NOTE",
+		"a          default    clean   ",
+		"b          old        extra   ",
+		"b          prod       drift   Plan: 1 to add, 0...",
+		"c          default    error   timeout",
+		"",
+		"1 drifted, 1 clean, 3 checked in 1m30s",
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	require.NoError(t, table.RunFinished(ctx, RunSummary{Error: "checkout failed"}))
+	require.Contains(t, out.String(), "Run failed: checkout failed")
+	require.NotContains(t, out.String(), "prod")
+
+	out.Reset()
+	table.Clean("a", "default")
+	require.NoError(t, table.RunFinished(ctx, RunSummary{Repo: "org/infra", WorkspacesUndrifted: 1, TotalWorkspaces: 1}))
+	require.Contains(t, out.String(), "\norg/infra: 0 drifted, 1 clean, 1 checked in 0s\n")
+}
+
+func TestTable_Generic(t *testing.T) {
+	genericNotificationTest(t, &Table{Output: &bytes.Buffer{}})
+}
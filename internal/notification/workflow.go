@@ -2,6 +2,7 @@ package notification
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/cresta/gogithub"
@@ -21,6 +22,7 @@ func NewWorkflow(ghClient gogithub.GitHub, owner string, repo string, id string,
 }
 
 type Workflow struct {
+	Noop
 	GhClient      gogithub.GitHub
 	WorkflowOwner string
 	WorkflowRepo  string
@@ -36,14 +38,6 @@ func (w *Workflow) TemporaryError(_ context.Context, _ string, _ string, _ error
 	return nil
 }
 
-func (w *Workflow) ExtraWorkspaceInRemote(_ context.Context, _ string, _ string) error {
-	return nil
-}
-
-func (w *Workflow) MissingWorkspaceInRemote(_ context.Context, _ string, _ string) error {
-	return nil
-}
-
 func (w *Workflow) PlanDrift(ctx context.Context, dir string, _ string, cliffnote string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -59,7 +53,17 @@ func (w *Workflow) PlanDrift(ctx context.Context, dir string, _ string, cliffnot
 	})
 }
 
-func (w *Workflow) WorkspaceDriftSummary(_ context.Context, _ int32, _ int32, _ int32) error {
+func (w *Workflow) RefPlanDrift(_ context.Context, _ string, _ string, _ string, _ string) error {
+	// Only drift against the default ref can be resolved by the workflow
+	return nil
+}
+
+// TestMessage does not dispatch the workflow, which would start a real run. It only checks the workflow repository
+// can be read with the configured token.
+func (w *Workflow) TestMessage(ctx context.Context, _ string) error {
+	if _, err := w.GhClient.RepositoryInfo(ctx, w.WorkflowOwner, w.WorkflowRepo); err != nil {
+		return fmt.Errorf("failed to read workflow repository %s/%s: %w", w.WorkflowOwner, w.WorkflowRepo, err)
+	}
 	return nil
 }
 
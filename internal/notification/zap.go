@@ -2,11 +2,13 @@ package notification
 
 import (
 	"context"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 type Zap struct {
+	Noop
 	Logger *zap.Logger
 }
 
@@ -20,6 +22,16 @@ func (I *Zap) PlanDrift(_ context.Context, dir string, workspace string, cliffno
 	return nil
 }
 
+func (I *Zap) RefPlanDrift(_ context.Context, ref string, dir string, workspace string, cliffnote string) error {
+	I.Logger.Info("Plan has drifted against ref", zap.String("ref", ref), zap.String("dir", dir), zap.String("workspace", workspace), zap.String("cliffnote", cliffnote))
+	return nil
+}
+
+func (I *Zap) PlanError(_ context.Context, dir string, workspace string, planError string) error {
+	I.Logger.Error("Plan has errors", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("error", planError))
+	return nil
+}
+
 func (I *Zap) ExtraWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
 	I.Logger.Info("Extra workspace in remote", zap.String("dir", dir), zap.String("workspace", workspace))
 	return nil
@@ -30,7 +42,82 @@ func (I *Zap) MissingWorkspaceInRemote(_ context.Context, dir string, workspace
 	return nil
 }
 
-func (i *Zap) WorkspaceDriftSummary(_ context.Context, _ int32, _ int32, _ int32) error {
+func (I *Zap) WorkspaceAuditSummary(_ context.Context, extraWorkspaces int32, missingWorkspaces int32) error {
+	I.Logger.Info("Workspace audit summary", zap.Int32("extra", extraWorkspaces), zap.Int32("missing", missingWorkspaces))
+	return nil
+}
+
+func (I *Zap) CachedResultsWarning(_ context.Context, cachedWorkspaces int32, totalWorkspaces int32, oldestCheck time.Time) error {
+	I.Logger.Warn("Workspaces served from cache", zap.Int32("cached", cachedWorkspaces), zap.Int32("total", totalWorkspaces), zap.Time("oldest-check", oldestCheck))
+	return nil
+}
+
+func (I *Zap) UnmanagedDirectory(_ context.Context, dir string) error {
+	I.Logger.Info("Root module has no atlantis project", zap.String("dir", dir))
+	return nil
+}
+
+func (I *Zap) DriftNotificationsSuppressed(_ context.Context, reason string, suppressedDrifts int32) error {
+	I.Logger.Info("Drift notifications suppressed", zap.String("reason", reason), zap.Int32("suppressed", suppressedDrifts))
+	return nil
+}
+
+func (I *Zap) NeverPlanned(_ context.Context, dir string, workspace string) error {
+	I.Logger.Info("Workspace was never planned", zap.String("dir", dir), zap.String("workspace", workspace))
+	return nil
+}
+
+func (I *Zap) OrphanedState(_ context.Context, dir string, stateKey string) error {
+	I.Logger.Info("Orphaned state in remote", zap.String("dir", dir), zap.String("state-key", stateKey))
+	return nil
+}
+
+func (I *Zap) PendingApply(_ context.Context, dir string, workspace string, pullRequests []string) error {
+	I.Logger.Info("Drift pending apply", zap.String("dir", dir), zap.String("workspace", workspace), zap.Strings("pull-requests", pullRequests))
+	return nil
+}
+
+func (I *Zap) NoProjectsFound(_ context.Context, repo string, configPath string) error {
+	I.Logger.Warn("Drift run found no projects", zap.String("repo", repo), zap.String("config", configPath))
+	return nil
+}
+
+func (I *Zap) DuplicateProject(_ context.Context, name string, dirs []string) error {
+	I.Logger.Warn("Several atlantis projects share a name", zap.String("project", name), zap.Strings("dirs", dirs))
+	return nil
+}
+
+func (I *Zap) PlanLocked(_ context.Context, dir string, workspace string) error {
+	I.Logger.Info("Plan is locked", zap.String("dir", dir), zap.String("workspace", workspace))
+	return nil
+}
+
+func (I *Zap) DriftResolved(_ context.Context, dir string, workspace string) error {
+	I.Logger.Info("Drift resolved", zap.String("dir", dir), zap.String("workspace", workspace))
+	return nil
+}
+
+func (I *Zap) RunStarted(_ context.Context, repo string, ref string) error {
+	I.Logger.Info("Drift run started", zap.String("repo", repo), zap.String("ref", ref))
+	return nil
+}
+
+func (I *Zap) RunFinished(_ context.Context, summary RunSummary) error {
+	I.Logger.Info("Drift run finished", zap.String("repo", summary.Repo), zap.String("ref", summary.Ref), zap.Duration("duration", summary.Duration), zap.Int32("drifted", summary.WorkspacesDrifted), zap.Int32("undrifted", summary.WorkspacesUndrifted), zap.Int32("total", summary.TotalWorkspaces), zap.Strings("phases", summary.Phases), zap.String("error", summary.Error))
+	return nil
+}
+
+func (I *Zap) RunTimings(_ context.Context, total time.Duration, phases []PhaseTiming) error {
+	fields := []zap.Field{zap.Duration("total", total)}
+	for _, p := range phases {
+		fields = append(fields, zap.Duration(p.Phase, p.Duration))
+	}
+	I.Logger.Info("Run timings", fields...)
+	return nil
+}
+
+func (I *Zap) TestMessage(_ context.Context, message string) error {
+	I.Logger.Info("Notification test", zap.String("message", message))
 	return nil
 }
 
@@ -0,0 +1,59 @@
+package planstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Store keeps full plan outputs somewhere notifications can link to
+type Store interface {
+	// Put stores body under key and returns a URL it can be read from
+	Put(ctx context.Context, key string, body []byte) (string, error)
+}
+
+// HTTPPut stores plans by PUTting them under BaseURL, which works for most object stores and artifact servers
+type HTTPPut struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Header is added to every request, for example for authentication
+	Header http.Header
+}
+
+func (h *HTTPPut) Put(ctx context.Context, key string, body []byte) (string, error) {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	destination := strings.TrimSuffix(h.BaseURL, "/") + "/" + strings.Join(parts, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, destination, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request to %s: %w", destination, err)
+	}
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to put plan to %s: %w", destination, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status code putting plan to %s: %d", destination, resp.StatusCode)
+	}
+	return destination, nil
+}
+
+var _ Store = &HTTPPut{}
@@ -0,0 +1,40 @@
+package planstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPPut_Put(t *testing.T) {
+	var gotPath, gotBody, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		gotPath = r.URL.EscapedPath()
+		gotBody = string(body)
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	h := &HTTPPut{
+		BaseURL: srv.URL + "/plans/",
+		Header:  http.Header{"Authorization": []string{"Bearer token"}},
+	}
+	link, err := h.Put(context.Background(), "run/dir with space/default.txt", []byte("plan output"))
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/plans/run/dir%20with%20space/default.txt", link)
+	require.Equal(t, "/plans/run/dir%20with%20space/default.txt", gotPath)
+	require.Equal(t, "plan output", gotBody)
+	require.Equal(t, "Bearer token", gotAuth)
+
+	h.BaseURL = srv.URL + "/missing"
+	srv.Config.Handler = http.NotFoundHandler()
+	_, err = h.Put(context.Background(), "key", []byte("x"))
+	require.Error(t, err)
+}
@@ -2,21 +2,68 @@ package processedcache
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"time"
 )
 
+// Source identifies the cache backend a value was read from
+type Source string
+
+const (
+	SourceDynamoDB Source = "dynamodb"
+	// SourceFresh marks a result that comes from a new check rather than a cache
+	SourceFresh Source = "fresh"
+)
+
+// Key namespaces used by CacheKey. Bump the version when the meaning of a stored value changes so old entries are
+// no longer found.
+const (
+	driftKeyPrefix         = "drift/v1/"
+	workspacesKeyPrefix    = "workspaces/v1/"
+	defaultBranchKeyPrefix = "default-branch/v1/"
+	runLockKeyPrefix       = "run-lock/v1/"
+	runReportKeyPrefix     = "run-report/v1/"
+)
+
+// hashKey returns prefix followed by the hex sha256 of parts. Each part is length prefixed, so no two different
+// lists of parts hash the same input no matter which characters they contain.
+func hashKey(prefix string, parts ...string) string {
+	h := sha256.New()
+	var l [8]byte
+	for _, p := range parts {
+		binary.BigEndian.PutUint64(l[:], uint64(len(p)))
+		h.Write(l[:])
+		h.Write([]byte(p))
+	}
+	return prefix + hex.EncodeToString(h.Sum(nil))
+}
+
 type ConsiderDriftChecked struct {
+	// Repository in owner/name form
+	Repo string
 	// The directory checked
 	Dir string
 	// The workspace checked
 	Workspace string
+	// The ref planned against, if not the default ref
+	Ref string `dynamodbav:",omitempty"`
 }
 
 func (d *ConsiderDriftChecked) String() string {
+	if d.Ref != "" {
+		return fmt.Sprintf("%s:%s@%s", d.Dir, d.Workspace, d.Ref)
+	}
 	return fmt.Sprintf("%s:%s", d.Dir, d.Workspace)
 }
 
+// CacheKey returns a stable, namespaced key for backends that store values by an opaque string
+func (d *ConsiderDriftChecked) CacheKey() string {
+	return hashKey(driftKeyPrefix, d.Repo, d.Dir, d.Workspace, d.Ref)
+}
+
 type DriftCheckValue struct {
 	// If non-empty, indicates an error in the checking
 	Error string
@@ -24,17 +71,82 @@ type DriftCheckValue struct {
 	Drift bool `json:"drift"`
 	// Only if we have an empty error: when we did this check
 	When time.Time
+	// When the current uninterrupted run of drift was first seen. Zero if not drifting.
+	FirstDriftSeen time.Time
+	// Whether the workspace has ever been checked and found clean
+	EverClean bool `dynamodbav:",omitempty"`
+	// The run that did this check, if known
+	RunID string `dynamodbav:",omitempty"`
+	// Sorted addresses of the resources that drifted, if the check found drift
+	DriftedResources []string `dynamodbav:",omitempty"`
+	// Whether the check failed with a temporary error, so whether the workspace drifted is not known
+	Unknown bool `dynamodbav:",omitempty"`
+	// When the current uninterrupted run of drift was last notified. Zero if it never was or the workspace is not
+	// drifting.
+	LastNotified time.Time `dynamodbav:",omitempty"`
+	// The cache backend this value was read from. Not stored.
+	Source Source `dynamodbav:"-" json:"-"`
+}
+
+// NextDriftCheckValue returns the value to store for a new check result given the previous value, which may be nil,
+// carrying forward when drift was first seen, when it was last notified and whether the workspace was ever clean.
+func NextDriftCheckValue(prev *DriftCheckValue, drift bool, now time.Time) *DriftCheckValue {
+	ret := &DriftCheckValue{
+		Drift: drift,
+		When:  now,
+	}
+	if prev != nil {
+		ret.EverClean = prev.EverClean || (prev.Error == "" && !prev.Unknown && !prev.Drift)
+		ret.FirstDriftSeen = prev.FirstDriftSeen
+		ret.LastNotified = prev.LastNotified
+	}
+	if !drift {
+		ret.EverClean = true
+		ret.FirstDriftSeen = time.Time{}
+		ret.LastNotified = time.Time{}
+	} else if ret.FirstDriftSeen.IsZero() {
+		ret.FirstDriftSeen = now
+	}
+	return ret
+}
+
+// UnknownDriftCheckValue returns the value to store for a check that failed with a temporary error, given the previous
+// value, which may be nil. What the previous value knew about drift is carried forward for the next real check.
+func UnknownDriftCheckValue(prev *DriftCheckValue, now time.Time) *DriftCheckValue {
+	ret := &DriftCheckValue{
+		Unknown: true,
+		When:    now,
+	}
+	if prev != nil {
+		ret.EverClean = prev.EverClean || (prev.Error == "" && !prev.Unknown && !prev.Drift)
+		ret.FirstDriftSeen = prev.FirstDriftSeen
+		ret.DriftedResources = prev.DriftedResources
+		ret.LastNotified = prev.LastNotified
+	}
+	return ret
 }
 
 type ConsiderWorkspacesChecked struct {
+	// Repository in owner/name form
+	Repo string
 	// Directory checked
 	Dir string
+	// The partial backend config file the directory was initialized with, if any
+	BackendConfig string `dynamodbav:",omitempty"`
 }
 
 func (d *ConsiderWorkspacesChecked) String() string {
+	if d.BackendConfig != "" {
+		return fmt.Sprintf("%s[%s]", d.Dir, d.BackendConfig)
+	}
 	return d.Dir
 }
 
+// CacheKey returns a stable, namespaced key for backends that store values by an opaque string
+func (d *ConsiderWorkspacesChecked) CacheKey() string {
+	return hashKey(workspacesKeyPrefix, d.Repo, d.Dir, d.BackendConfig)
+}
+
 type WorkspacesCheckedValue struct {
 	// If non-empty, indicates an error in the checking
 	Error string
@@ -42,6 +154,89 @@ type WorkspacesCheckedValue struct {
 	Workspaces []string
 	// Only if we have an empty error: when we did this check
 	When time.Time
+	// The cache backend this value was read from. Not stored.
+	Source Source `dynamodbav:"-" json:"-"`
+}
+
+type ConsiderDefaultBranch struct {
+	// Repository in owner/name form
+	Repo string
+}
+
+func (d *ConsiderDefaultBranch) String() string {
+	return d.Repo
+}
+
+// CacheKey returns a stable, namespaced key for backends that store values by an opaque string
+func (d *ConsiderDefaultBranch) CacheKey() string {
+	return hashKey(defaultBranchKeyPrefix, d.Repo)
+}
+
+type DefaultBranchValue struct {
+	// The default branch of the repository
+	Branch string
+	// When we looked up the default branch
+	When time.Time
+	// The cache backend this value was read from. Not stored.
+	Source Source `dynamodbav:"-" json:"-"`
+}
+
+type ConsiderRunLock struct {
+	// Repository in owner/name form
+	Repo string
+}
+
+func (d *ConsiderRunLock) String() string {
+	return d.Repo
+}
+
+// CacheKey returns a stable, namespaced key for backends that store values by an opaque string
+func (d *ConsiderRunLock) CacheKey() string {
+	return hashKey(runLockKeyPrefix, d.Repo)
+}
+
+type RunLockValue struct {
+	// Identifies the run holding the lock
+	Owner string
+	// When the lock lapses if it is not released
+	Expires time.Time `dynamodbav:",unixtime"`
+	// The cache backend this value was read from. Not stored.
+	Source Source `dynamodbav:"-" json:"-"`
+}
+
+type ConsiderRunReport struct {
+	// Repository in owner/name form
+	Repo string
+	// The run the report is for
+	RunID string
+}
+
+func (d *ConsiderRunReport) String() string {
+	return fmt.Sprintf("%s#%s", d.Repo, d.RunID)
+}
+
+// CacheKey returns a stable, namespaced key for backends that store values by an opaque string
+func (d *ConsiderRunReport) CacheKey() string {
+	return hashKey(runReportKeyPrefix, d.Repo, d.RunID)
+}
+
+type RunReportValue struct {
+	// The run the report is for
+	RunID string
+	// When the run finished
+	When time.Time
+	// The report of the run, as JSON
+	Report string
+	// The cache backend this value was read from. Not stored.
+	Source Source `dynamodbav:"-" json:"-"`
+}
+
+// ReportCache is implemented by caches that can keep the report of every run and list them again, so drift can be
+// followed over time
+type ReportCache interface {
+	StoreRunReport(ctx context.Context, key *ConsiderRunReport, value *RunReportValue) error
+	// ListRecentReports returns up to n reports of repo, newest first
+	ListRecentReports(ctx context.Context, repo string, n int) ([]*RunReportValue, error)
 }
 
 type ProcessedCache interface {
@@ -51,6 +246,13 @@ type ProcessedCache interface {
 	GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error)
 	StoreRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error
 	DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error
+	GetDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch) (*DefaultBranchValue, error)
+	StoreDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch, value *DefaultBranchValue) error
+	// AcquireRunLock stores value under key unless a lock with a different owner that has not expired by now is stored.
+	// It returns whether the lock was acquired.
+	AcquireRunLock(ctx context.Context, key *ConsiderRunLock, value *RunLockValue, now time.Time) (bool, error)
+	// ReleaseRunLock removes the lock under key if owner still holds it
+	ReleaseRunLock(ctx context.Context, key *ConsiderRunLock, owner string) error
 }
 
 type Noop struct{}
@@ -79,4 +281,20 @@ func (n Noop) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspace
 	return nil
 }
 
+func (n Noop) GetDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch) (*DefaultBranchValue, error) {
+	return nil, nil
+}
+
+func (n Noop) StoreDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch, value *DefaultBranchValue) error {
+	return nil
+}
+
+func (n Noop) AcquireRunLock(ctx context.Context, key *ConsiderRunLock, value *RunLockValue, now time.Time) (bool, error) {
+	return true, nil
+}
+
+func (n Noop) ReleaseRunLock(ctx context.Context, key *ConsiderRunLock, owner string) error {
+	return nil
+}
+
 var _ ProcessedCache = &Noop{}
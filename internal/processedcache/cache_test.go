@@ -3,6 +3,7 @@ package processedcache
 import (
 	"context"
 	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 	"time"
 )
@@ -27,6 +28,8 @@ func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 	item, err = cache.GetDriftCheckResult(ctx, testKey)
 	require.NoError(t, err)
 	require.NotNil(t, item)
+	require.NotEmpty(t, item.Source)
+	item.Source = ""
 	require.Equal(t, testValue, item)
 	err = cache.DeleteDriftCheckResult(ctx, testKey)
 	require.NoError(t, err)
@@ -34,3 +37,111 @@ func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 	require.NoError(t, err)
 	require.Nil(t, item)
 }
+
+func GenericReportCacheTest(t *testing.T, cache ReportCache) {
+	ctx := context.Background()
+	repo := "test" + time.Now().String()
+	start := time.Now().Round(time.Millisecond)
+	for i, runID := range []string{"first", "second", "third"} {
+		require.NoError(t, cache.StoreRunReport(ctx, &ConsiderRunReport{Repo: repo, RunID: runID}, &RunReportValue{
+			RunID:  runID,
+			When:   start.Add(time.Duration(i) * time.Minute),
+			Report: `{"run":"` + runID + `"}`,
+		}))
+	}
+	require.NoError(t, cache.StoreRunReport(ctx, &ConsiderRunReport{Repo: repo + "-other", RunID: "other"}, &RunReportValue{RunID: "other", When: start}))
+	reports, err := cache.ListRecentReports(ctx, repo, 2)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, "third", reports[0].RunID)
+	require.Equal(t, `{"run":"third"}`, reports[0].Report)
+	require.Equal(t, "second", reports[1].RunID)
+}
+
+func GenericRunLockTest(t *testing.T, cache ProcessedCache) {
+	ctx := context.Background()
+	now := time.Now()
+	key := &ConsiderRunLock{Repo: "test" + now.String()}
+	first := &RunLockValue{Owner: "first", Expires: now.Add(time.Minute)}
+	acquired, err := cache.AcquireRunLock(ctx, key, first, now)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = cache.AcquireRunLock(ctx, key, &RunLockValue{Owner: "second", Expires: now.Add(time.Minute)}, now)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	// Releasing a lock held by someone else does nothing
+	require.NoError(t, cache.ReleaseRunLock(ctx, key, "second"))
+	acquired, err = cache.AcquireRunLock(ctx, key, &RunLockValue{Owner: "second", Expires: now.Add(time.Minute)}, now)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.NoError(t, cache.ReleaseRunLock(ctx, key, "first"))
+	acquired, err = cache.AcquireRunLock(ctx, key, &RunLockValue{Owner: "second", Expires: now.Add(-time.Minute)}, now)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	// An expired lock can be taken over
+	acquired, err = cache.AcquireRunLock(ctx, key, &RunLockValue{Owner: "third", Expires: now.Add(time.Minute)}, now)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	require.NoError(t, cache.ReleaseRunLock(ctx, key, "third"))
+}
+
+func TestConsiderDriftChecked_String(t *testing.T) {
+	require.Equal(t, "dir:ws", (&ConsiderDriftChecked{Dir: "dir", Workspace: "ws"}).String())
+	require.Equal(t, "dir:ws@release", (&ConsiderDriftChecked{Dir: "dir", Workspace: "ws", Ref: "release"}).String())
+}
+
+func TestCacheKey(t *testing.T) {
+	k := (&ConsiderDriftChecked{Dir: "dir", Workspace: "ws"}).CacheKey()
+	require.True(t, strings.HasPrefix(k, "drift/v1/"))
+	require.Len(t, k, len("drift/v1/")+64)
+	require.Equal(t, k, (&ConsiderDriftChecked{Dir: "dir", Workspace: "ws"}).CacheKey())
+	// These all share a String() form or a naive concatenation, but must not share a key
+	require.NotEqual(t, (&ConsiderDriftChecked{Dir: "a:b", Workspace: "c"}).CacheKey(), (&ConsiderDriftChecked{Dir: "a", Workspace: "b:c"}).CacheKey())
+	require.NotEqual(t, (&ConsiderDriftChecked{Dir: "a|b", Workspace: "c"}).CacheKey(), (&ConsiderDriftChecked{Dir: "a", Workspace: "b|c"}).CacheKey())
+	require.NotEqual(t, k, (&ConsiderDriftChecked{Dir: "dir", Workspace: "ws", Ref: "release"}).CacheKey())
+	require.NotEqual(t, (&ConsiderDriftChecked{Repo: "org/a", Dir: "dir", Workspace: "ws"}).CacheKey(), (&ConsiderDriftChecked{Repo: "org/b", Dir: "dir", Workspace: "ws"}).CacheKey())
+
+	w := (&ConsiderWorkspacesChecked{Dir: "dir"}).CacheKey()
+	require.True(t, strings.HasPrefix(w, "workspaces/v1/"))
+	require.NotEqual(t, w, (&ConsiderWorkspacesChecked{Dir: "dir", BackendConfig: "prod.tfbackend"}).CacheKey())
+	require.NotEqual(t, w, (&ConsiderWorkspacesChecked{Repo: "org/a", Dir: "dir"}).CacheKey())
+	require.True(t, strings.HasPrefix((&ConsiderDefaultBranch{Repo: "owner/repo"}).CacheKey(), "default-branch/v1/"))
+}
+
+func TestNextDriftCheckValue(t *testing.T) {
+	start := time.Now()
+	first := NextDriftCheckValue(nil, true, start)
+	require.Equal(t, start, first.FirstDriftSeen)
+	require.False(t, first.EverClean)
+
+	first.LastNotified = start
+	second := NextDriftCheckValue(first, true, start.Add(time.Hour))
+	require.Equal(t, start, second.FirstDriftSeen)
+	require.Equal(t, start, second.LastNotified)
+	require.False(t, second.EverClean)
+
+	clean := NextDriftCheckValue(second, false, start.Add(2*time.Hour))
+	require.True(t, clean.FirstDriftSeen.IsZero())
+	require.True(t, clean.LastNotified.IsZero())
+	require.True(t, clean.EverClean)
+
+	again := NextDriftCheckValue(clean, true, start.Add(3*time.Hour))
+	require.Equal(t, start.Add(3*time.Hour), again.FirstDriftSeen)
+	require.True(t, again.EverClean)
+}
+
+func TestUnknownDriftCheckValue(t *testing.T) {
+	start := time.Now()
+	drifted := NextDriftCheckValue(nil, true, start)
+	drifted.DriftedResources = []string{"aws_s3_bucket.logs"}
+	unknown := UnknownDriftCheckValue(drifted, start.Add(time.Hour))
+	require.True(t, unknown.Unknown)
+	require.False(t, unknown.Drift)
+	require.Equal(t, start, unknown.FirstDriftSeen)
+	require.Equal(t, []string{"aws_s3_bucket.logs"}, unknown.DriftedResources)
+	require.False(t, unknown.EverClean)
+
+	// An unknown result is not a clean one
+	require.False(t, NextDriftCheckValue(UnknownDriftCheckValue(nil, start), true, start.Add(time.Hour)).EverClean)
+	require.True(t, UnknownDriftCheckValue(NextDriftCheckValue(nil, false, start), start.Add(time.Hour)).EverClean)
+}
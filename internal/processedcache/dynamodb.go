@@ -2,16 +2,25 @@ package processedcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"sort"
+	"strconv"
+	"time"
 )
 
 type DynamoDB struct {
 	Client *dynamodb.Client
 	Table  string
+	// ReportIndex is an optional global secondary index of Table with partition key ReportRepo (string) and sort key
+	// ReportWhen (number) that projects every attribute. With it, ListRecentReports queries a repo's newest reports
+	// instead of scanning the table.
+	ReportIndex string
 }
 
 func NewDynamoDB(ctx context.Context, table string) (*DynamoDB, error) {
@@ -30,15 +39,52 @@ func NewDynamoDB(ctx context.Context, table string) (*DynamoDB, error) {
 	return &c, nil
 }
 
-func dynamoKeyForDriftCheckResultKey(keyType string, k fmt.Stringer) map[string]types.AttributeValue {
+// cacheKeyer is a key type with a CacheKey, which is used as the item key K
+type cacheKeyer interface {
+	CacheKey() string
+}
+
+func dynamoKeyForDriftCheckResultKey(k cacheKeyer) map[string]types.AttributeValue {
+	return dynamoKey(k.CacheKey())
+}
+
+func dynamoKey(k string) map[string]types.AttributeValue {
 	return map[string]types.AttributeValue{
-		"K": &types.AttributeValueMemberS{Value: fmt.Sprintf("%s:%s", keyType, k.String())},
+		"K": &types.AttributeValueMemberS{Value: k},
+	}
+}
+
+// legacyKeyer is a key type that releases before CacheKey stored under another item key. An empty legacy key means
+// no such item was ever written.
+type legacyKeyer interface {
+	legacyCacheKey() string
+}
+
+func (d *ConsiderDriftChecked) legacyCacheKey() string {
+	if d.Ref != "" {
+		return ""
+	}
+	return fmt.Sprintf("ConsiderDriftChecked:%s:%s", d.Dir, d.Workspace)
+}
+
+func (d *ConsiderWorkspacesChecked) legacyCacheKey() string {
+	if d.BackendConfig != "" {
+		return ""
+	}
+	return "ConsiderWorkspacesChecked:" + d.Dir
+}
+
+// legacyKey returns the legacy item key of key, or "" if it has none
+func legacyKey(key cacheKeyer) string {
+	if l, ok := key.(legacyKeyer); ok {
+		return l.legacyCacheKey()
 	}
+	return ""
 }
 
-func dynamoKeyForDriftCheckResultValue(keyType string, key fmt.Stringer, value any) (map[string]types.AttributeValue, error) {
+func dynamoKeyForDriftCheckResultValue(key cacheKeyer, value any) (map[string]types.AttributeValue, error) {
 	var allItems []map[string]types.AttributeValue
-	allItems = append(allItems, dynamoKeyForDriftCheckResultKey(keyType, key))
+	allItems = append(allItems, dynamoKeyForDriftCheckResultKey(key))
 	if i, err := attributevalue.MarshalMap(key); err != nil {
 		return nil, fmt.Errorf("failed to marshal key: %w", err)
 	} else {
@@ -58,38 +104,60 @@ func dynamoKeyForDriftCheckResultValue(keyType string, key fmt.Stringer, value a
 	return ret, nil
 }
 
-func (d *DynamoDB) genericGet(ctx context.Context, keyType string, key fmt.Stringer, into any) (bool, error) {
-	input := &dynamodb.GetItemInput{
-		TableName: &d.Table,
-		Key:       dynamoKeyForDriftCheckResultKey(keyType, key),
-	}
-	output, err := d.Client.GetItem(ctx, input)
+// genericGet reads the item of key into into. If there is none, the item a release before CacheKey stored is read
+// instead, so upgrading does not drop every cached result at once.
+func (d *DynamoDB) genericGet(ctx context.Context, key cacheKeyer, into any) (bool, error) {
+	item, err := d.getItem(ctx, key.CacheKey())
 	if err != nil {
-		return false, fmt.Errorf("failed to get drift check result: %w", err)
+		return false, err
+	}
+	if legacy := legacyKey(key); item == nil && legacy != "" {
+		if item, err = d.getItem(ctx, legacy); err != nil {
+			return false, err
+		}
 	}
-	if output.Item == nil {
+	if item == nil {
 		return false, nil
 	}
-	if err := attributevalue.UnmarshalMap(output.Item, into); err != nil {
+	if err := attributevalue.UnmarshalMap(item, into); err != nil {
 		return false, fmt.Errorf("failed to unmarshal drift check result: %w", err)
 	}
 	return true, nil
 }
 
-func (d *DynamoDB) genericDelete(ctx context.Context, keyType string, key fmt.Stringer) error {
-	input := &dynamodb.DeleteItemInput{
+func (d *DynamoDB) getItem(ctx context.Context, k string) (map[string]types.AttributeValue, error) {
+	input := &dynamodb.GetItemInput{
 		TableName: &d.Table,
-		Key:       dynamoKeyForDriftCheckResultKey(keyType, key),
+		Key:       dynamoKey(k),
 	}
-	_, err := d.Client.DeleteItem(ctx, input)
+	output, err := d.Client.GetItem(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to delete drift check result: %w", err)
+		return nil, fmt.Errorf("failed to get drift check result: %w", err)
+	}
+	return output.Item, nil
+}
+
+// genericDelete deletes the item of key, and its legacy item if it has one, so a deleted result is not read back from
+// the legacy item
+func (d *DynamoDB) genericDelete(ctx context.Context, key cacheKeyer) error {
+	keys := []string{key.CacheKey()}
+	if legacy := legacyKey(key); legacy != "" {
+		keys = append(keys, legacy)
+	}
+	for _, k := range keys {
+		input := &dynamodb.DeleteItemInput{
+			TableName: &d.Table,
+			Key:       dynamoKey(k),
+		}
+		if _, err := d.Client.DeleteItem(ctx, input); err != nil {
+			return fmt.Errorf("failed to delete drift check result: %w", err)
+		}
 	}
 	return nil
 }
 
-func (d *DynamoDB) genericStore(ctx context.Context, keyType string, key fmt.Stringer, value any) error {
-	item, err := dynamoKeyForDriftCheckResultValue(keyType, key, value)
+func (d *DynamoDB) genericStore(ctx context.Context, key cacheKeyer, value any) error {
+	item, err := dynamoKeyForDriftCheckResultValue(key, value)
 	if err != nil {
 		return fmt.Errorf("failed to marshal drift check result: %w", err)
 	}
@@ -106,38 +174,198 @@ func (d *DynamoDB) genericStore(ctx context.Context, keyType string, key fmt.Str
 
 func (d *DynamoDB) GetDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error) {
 	var ret DriftCheckValue
-	if exists, err := d.genericGet(ctx, "ConsiderDriftChecked", key, &ret); err != nil {
+	if exists, err := d.genericGet(ctx, key, &ret); err != nil {
 		return nil, err
 	} else if !exists {
 		return nil, nil
 	}
+	ret.Source = SourceDynamoDB
 	return &ret, nil
 }
 
 func (d *DynamoDB) DeleteDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) error {
-	return d.genericDelete(ctx, "ConsiderDriftChecked", key)
+	return d.genericDelete(ctx, key)
 }
 
 func (d *DynamoDB) StoreDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked, value *DriftCheckValue) error {
-	return d.genericStore(ctx, "ConsiderDriftChecked", key, value)
+	return d.genericStore(ctx, key, value)
 }
 
 func (d *DynamoDB) GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error) {
 	var ret WorkspacesCheckedValue
-	if exists, err := d.genericGet(ctx, "ConsiderWorkspacesChecked", key, &ret); err != nil {
+	if exists, err := d.genericGet(ctx, key, &ret); err != nil {
 		return nil, err
 	} else if !exists {
 		return nil, nil
 	}
+	ret.Source = SourceDynamoDB
 	return &ret, nil
 }
 
 func (d *DynamoDB) StoreRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error {
-	return d.genericStore(ctx, "ConsiderWorkspacesChecked", key, value)
+	return d.genericStore(ctx, key, value)
 }
 
 func (d *DynamoDB) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error {
-	return d.genericDelete(ctx, "ConsiderWorkspacesChecked", key)
+	return d.genericDelete(ctx, key)
+}
+
+func (d *DynamoDB) GetDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch) (*DefaultBranchValue, error) {
+	var ret DefaultBranchValue
+	if exists, err := d.genericGet(ctx, key, &ret); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	ret.Source = SourceDynamoDB
+	return &ret, nil
+}
+
+func (d *DynamoDB) StoreDefaultBranch(ctx context.Context, key *ConsiderDefaultBranch, value *DefaultBranchValue) error {
+	return d.genericStore(ctx, key, value)
+}
+
+func (d *DynamoDB) AcquireRunLock(ctx context.Context, key *ConsiderRunLock, value *RunLockValue, now time.Time) (bool, error) {
+	item, err := dynamoKeyForDriftCheckResultValue(key, value)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal run lock: %w", err)
+	}
+	input := &dynamodb.PutItemInput{
+		TableName:           &d.Table,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(K) OR Expires < :now OR #owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":owner": &types.AttributeValueMemberS{Value: value.Owner},
+		},
+	}
+	if _, err := d.Client.PutItem(ctx, input); err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire run lock: %w", err)
+	}
+	return true, nil
+}
+
+func (d *DynamoDB) ReleaseRunLock(ctx context.Context, key *ConsiderRunLock, owner string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName:           &d.Table,
+		Key:                 dynamoKeyForDriftCheckResultKey(key),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	}
+	if _, err := d.Client.DeleteItem(ctx, input); err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// The lock expired and another run took it
+			return nil
+		}
+		return fmt.Errorf("failed to release run lock: %w", err)
+	}
+	return nil
+}
+
+func (d *DynamoDB) StoreRunReport(ctx context.Context, key *ConsiderRunReport, value *RunReportValue) error {
+	item, err := dynamoKeyForDriftCheckResultValue(key, value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run report: %w", err)
+	}
+	// Only reports have these attributes, so ReportIndex holds nothing else
+	item["ReportRepo"] = &types.AttributeValueMemberS{Value: key.Repo}
+	item["ReportWhen"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(value.When.UnixMilli(), 10)}
+	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &d.Table,
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store run report: %w", err)
+	}
+	return nil
+}
+
+// ListRecentReports returns the newest n reports of repo. With ReportIndex it queries the index, otherwise it scans
+// the whole table, since the table has no sort key, and every report is read before the newest n are returned.
+func (d *DynamoDB) ListRecentReports(ctx context.Context, repo string, n int) ([]*RunReportValue, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("number of reports must be at least 1, got %d", n)
+	}
+	if d.ReportIndex != "" {
+		return d.queryRecentReports(ctx, repo, n)
+	}
+	paginator := dynamodb.NewScanPaginator(d.Client, &dynamodb.ScanInput{
+		TableName:        &d.Table,
+		FilterExpression: aws.String("ReportRepo = :repo"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":repo": &types.AttributeValueMemberS{Value: repo},
+		},
+	})
+	var ret []*RunReportValue
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run reports: %w", err)
+		}
+		for _, item := range page.Items {
+			var v RunReportValue
+			if err := attributevalue.UnmarshalMap(item, &v); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal run report: %w", err)
+			}
+			v.Source = SourceDynamoDB
+			ret = append(ret, &v)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].When.After(ret[j].When)
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+func (d *DynamoDB) queryRecentReports(ctx context.Context, repo string, n int) ([]*RunReportValue, error) {
+	paginator := dynamodb.NewQueryPaginator(d.Client, &dynamodb.QueryInput{
+		TableName:              &d.Table,
+		IndexName:              &d.ReportIndex,
+		KeyConditionExpression: aws.String("ReportRepo = :repo"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":repo": &types.AttributeValueMemberS{Value: repo},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(min(n, 1000))),
+	})
+	var ret []*RunReportValue
+	for paginator.HasMorePages() && len(ret) < n {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query run reports: %w", err)
+		}
+		for _, item := range page.Items {
+			if len(ret) == n {
+				break
+			}
+			var v RunReportValue
+			if err := attributevalue.UnmarshalMap(item, &v); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal run report: %w", err)
+			}
+			v.Source = SourceDynamoDB
+			ret = append(ret, &v)
+		}
+	}
+	return ret, nil
 }
 
-var _ ProcessedCache = &DynamoDB{}
+var (
+	_ ProcessedCache = &DynamoDB{}
+	_ ReportCache    = &DynamoDB{}
+)
@@ -2,6 +2,7 @@ package processedcache
 
 import (
 	"context"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/testhelper"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -17,3 +18,41 @@ func makeTestClient(t *testing.T) *DynamoDB {
 func TestDynamoDB(t *testing.T) {
 	GenericCacheWorkflowTest(t, makeTestClient(t))
 }
+
+func TestDynamoDB_RunLock(t *testing.T) {
+	GenericRunLockTest(t, makeTestClient(t))
+}
+
+func TestDynamoDB_RunReports(t *testing.T) {
+	GenericReportCacheTest(t, makeTestClient(t))
+}
+
+func TestDynamoDB_RunReportsIndex(t *testing.T) {
+	client := makeTestClient(t)
+	client.ReportIndex = testhelper.EnvOrSkip(t, "DYNAMODB_REPORT_INDEX")
+	GenericReportCacheTest(t, client)
+}
+
+func TestDynamoDB_ListRecentReportsCount(t *testing.T) {
+	_, err := (&DynamoDB{}).ListRecentReports(context.Background(), "owner/repo", 0)
+	require.ErrorContains(t, err, "at least 1")
+	_, err = (&DynamoDB{ReportIndex: "reports"}).ListRecentReports(context.Background(), "owner/repo", -1)
+	require.ErrorContains(t, err, "at least 1")
+}
+
+func TestDynamoDB_ItemKey(t *testing.T) {
+	key := &ConsiderDriftChecked{Dir: "a:b", Workspace: "c"}
+	require.Equal(t, &types.AttributeValueMemberS{Value: key.CacheKey()}, dynamoKeyForDriftCheckResultKey(key)["K"])
+	item, err := dynamoKeyForDriftCheckResultValue(key, &DriftCheckValue{Drift: true})
+	require.NoError(t, err)
+	require.Equal(t, &types.AttributeValueMemberS{Value: key.CacheKey()}, item["K"])
+	require.Equal(t, &types.AttributeValueMemberS{Value: "a:b"}, item["Dir"])
+}
+
+func TestDynamoDB_LegacyKey(t *testing.T) {
+	require.Equal(t, "ConsiderDriftChecked:dir:ws", legacyKey(&ConsiderDriftChecked{Repo: "org/a", Dir: "dir", Workspace: "ws"}))
+	require.Equal(t, "", legacyKey(&ConsiderDriftChecked{Dir: "dir", Workspace: "ws", Ref: "release"}))
+	require.Equal(t, "ConsiderWorkspacesChecked:dir", legacyKey(&ConsiderWorkspacesChecked{Repo: "org/a", Dir: "dir"}))
+	require.Equal(t, "", legacyKey(&ConsiderWorkspacesChecked{Dir: "dir", BackendConfig: "prod.tfbackend"}))
+	require.Equal(t, "", legacyKey(&ConsiderDefaultBranch{Repo: "org/a"}))
+}
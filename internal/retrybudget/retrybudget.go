@@ -0,0 +1,48 @@
+// Package retrybudget limits retries across a whole run, so a widespread outage makes callers fail fast instead of
+// each retrying on its own and multiplying the load on whatever is down.
+package retrybudget
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Budget is a run-wide allowance of retries shared by every caller that retries. A nil *Budget allows every retry.
+type Budget struct {
+	remaining int64
+	denied    int64
+}
+
+// New returns a Budget allowing retries retries in total. Zero allows none.
+func New(retries int) *Budget {
+	return &Budget{remaining: int64(retries)}
+}
+
+// Take uses up one retry, returning false if the budget is exhausted and the caller should give up instead
+func (b *Budget) Take() bool {
+	if b == nil {
+		return true
+	}
+	if atomic.AddInt64(&b.remaining, -1) >= 0 {
+		return true
+	}
+	atomic.AddInt64(&b.remaining, 1)
+	atomic.AddInt64(&b.denied, 1)
+	return false
+}
+
+// Remaining returns how many retries are left. A nil *Budget is unlimited, so it has math.MaxInt left.
+func (b *Budget) Remaining() int {
+	if b == nil {
+		return math.MaxInt
+	}
+	return int(atomic.LoadInt64(&b.remaining))
+}
+
+// Denied returns how many retries were refused because the budget was exhausted
+func (b *Budget) Denied() int {
+	if b == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&b.denied))
+}
@@ -0,0 +1,39 @@
+package retrybudget
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	b := New(50)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if b.Take() {
+					mu.Lock()
+					granted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 50, granted)
+	require.Equal(t, 0, b.Remaining())
+	require.Equal(t, 110, b.Denied())
+
+	require.False(t, New(0).Take())
+	var unlimited *Budget
+	require.True(t, unlimited.Take())
+	require.Equal(t, math.MaxInt, unlimited.Remaining())
+	require.Equal(t, 0, unlimited.Denied())
+}
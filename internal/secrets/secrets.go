@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider resolves a secret reference to its value at runtime
+type Provider interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Env resolves a reference as the name of an environment variable
+type Env struct{}
+
+func (e Env) Resolve(_ context.Context, ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return val, nil
+}
+
+// File resolves a reference as a path to a file holding the secret. Surrounding whitespace is trimmed.
+type File struct{}
+
+func (f File) Resolve(_ context.Context, ref string) (string, error) {
+	body, err := os.ReadFile(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %w", ref, err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Schemes resolves references of the form "<scheme>:<ref>" with the provider registered for scheme
+type Schemes map[string]Provider
+
+func DefaultSchemes() Schemes {
+	return Schemes{
+		"env":  Env{},
+		"file": File{},
+	}
+}
+
+func (s Schemes) Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", fmt.Errorf("secret reference must look like <scheme>:<ref>")
+	}
+	p, exists := s[scheme]
+	if !exists {
+		return "", fmt.Errorf("unknown secret scheme %s", scheme)
+	}
+	return p.Resolve(ctx, rest)
+}
+
+var _ Provider = Env{}
+var _ Provider = File{}
+var _ Provider = Schemes{}
@@ -0,0 +1,32 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemes_Resolve(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("SECRETS_TEST_VALUE", "from-env")
+	fp := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(fp, []byte("from-file\n"), 0600))
+
+	s := DefaultSchemes()
+	val, err := s.Resolve(ctx, "env:SECRETS_TEST_VALUE")
+	require.NoError(t, err)
+	require.Equal(t, "from-env", val)
+	val, err = s.Resolve(ctx, "file:"+fp)
+	require.NoError(t, err)
+	require.Equal(t, "from-file", val)
+
+	_, err = s.Resolve(ctx, "env:SECRETS_TEST_MISSING")
+	require.Error(t, err)
+	_, err = s.Resolve(ctx, "vault:secret/slack")
+	require.Error(t, err)
+	_, err = s.Resolve(ctx, "no-scheme")
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package statelister
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cresta/pipe"
+)
+
+// Lister lists the keys of the terraform state files in a remote backend
+type Lister interface {
+	ListStateKeys(ctx context.Context) ([]string, error)
+}
+
+// S3 lists the *.tfstate objects under Prefix in an S3 bucket
+type S3 struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+// NewS3 returns an S3 lister using the default AWS credential chain and region. Buckets with a dot in their name are
+// addressed path style, since their virtual hosted name does not match the S3 certificate.
+func NewS3(ctx context.Context, bucket string, prefix string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return &S3{
+		Client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = strings.Contains(bucket, ".")
+		}),
+		Bucket: bucket,
+		Prefix: prefix,
+	}, nil
+}
+
+func (s *S3) ListStateKeys(ctx context.Context) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+	}
+	if s.Prefix != "" {
+		input.Prefix = aws.String(s.Prefix)
+	}
+	var ret []string
+	paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list bucket %s: %w", s.Bucket, err)
+		}
+		for _, c := range page.Contents {
+			if key := aws.ToString(c.Key); strings.HasSuffix(key, ".tfstate") {
+				ret = append(ret, key)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Command lists state keys by running a shell command that prints one key per line, for backends without a native
+// lister. For example `gsutil ls gs://bucket/** | sed 's|gs://bucket/||'`.
+type Command struct {
+	Command string
+}
+
+func (c *Command) ListStateKeys(ctx context.Context) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := pipe.NewPiped("sh", "-c", c.Command).Execute(ctx, nil, &stdout, &stderr); err != nil {
+		return nil, fmt.Errorf("state listing command failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	var ret []string
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret, scanner.Err()
+}
+
+var (
+	_ Lister = &S3{}
+	_ Lister = &Command{}
+)
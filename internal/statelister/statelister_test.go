@@ -0,0 +1,65 @@
+package statelister
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3_ListStateKeys(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "/state-bucket", r.URL.Path)
+		require.Equal(t, "2", r.URL.Query().Get("list-type"))
+		require.Equal(t, "terraform/", r.URL.Query().Get("prefix"))
+		require.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		if r.URL.Query().Get("continuation-token") == "" {
+			_, _ = w.Write([]byte(`<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>
+<Contents><Key>terraform/app/terraform.tfstate</Key></Contents>
+<Contents><Key>terraform/app/.terraform.lock.hcl</Key></Contents>
+</ListBucketResult>`))
+			return
+		}
+		require.Equal(t, "page2", r.URL.Query().Get("continuation-token"))
+		_, _ = w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated>
+<Contents><Key>terraform/old/terraform.tfstate</Key></Contents>
+</ListBucketResult>`))
+	}))
+	defer srv.Close()
+	s := &S3{
+		Client: s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+			BaseEndpoint: aws.String(srv.URL),
+			UsePathStyle: true,
+			HTTPClient:   srv.Client(),
+		}),
+		Bucket: "state-bucket",
+		Prefix: "terraform/",
+	}
+	keys, err := s.ListStateKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"terraform/app/terraform.tfstate", "terraform/old/terraform.tfstate"}, keys)
+	require.Equal(t, 2, requests)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	_, err = s.ListStateKeys(context.Background())
+	require.Error(t, err)
+}
+
+func TestCommand_ListStateKeys(t *testing.T) {
+	keys, err := (&Command{Command: "printf 'a/terraform.tfstate\\n\\n  b/terraform.tfstate\\n'"}).ListStateKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/terraform.tfstate", "b/terraform.tfstate"}, keys)
+	_, err = (&Command{Command: "exit 3"}).ListStateKeys(context.Background())
+	require.Error(t, err)
+}
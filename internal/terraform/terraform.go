@@ -3,16 +3,100 @@ package terraform
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cresta/pipe"
+	"github.com/revdotcom/gha-atlantis-drift-detection/internal/retrybudget"
 	"go.uber.org/zap"
+	"io"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Client struct {
 	Directory string
 	Logger    *zap.Logger
+	// PreInitHook, if set, is run before every terraform init with the absolute path of the directory being initialized
+	PreInitHook func(ctx context.Context, dir string) error
+	// MaxConcurrentInits bounds how many Init calls run at once across every caller. Zero means unbounded.
+	MaxConcurrentInits int
+	// SkipInitIfInitialized skips Init for directories that this client already initialized, as long as neither their
+	// dependency lock file nor their terraform files, which hold the backend config, changed since
+	SkipInitIfInitialized bool
+	// InitRetries is how many times Init retries terraform init after a transient, network related, failure
+	InitRetries int
+	// InitBackoff is the delay before the first retry. It doubles for every retry.
+	InitBackoff time.Duration
+	// RetryBudget, if set, is shared with every other retrying caller in the run. Init stops retrying once it is
+	// exhausted.
+	RetryBudget *retrybudget.Budget
+	// IsolatedHome runs terraform with a temporary HOME and a TF_DATA_DIR per directory, so it neither reads the
+	// runner's CLI config and credentials nor shares state between directories. Call Cleanup when done.
+	IsolatedHome bool
+	// RequiredVersion, if set, is a version constraint, like ">= 1.5, < 2.0", the terraform binary must satisfy. It is
+	// checked by VerifyVersion.
+	RequiredVersion string
+
+	initSemOnce sync.Once
+	initSem     chan struct{}
+	homeOnce    sync.Once
+	home        string
+	homeErr     error
+}
+
+// env returns the environment terraform runs with in subDir, or nil to inherit the current environment
+func (c *Client) env(subDir string) ([]string, error) {
+	if !c.IsolatedHome {
+		return nil, nil
+	}
+	c.homeOnce.Do(func() {
+		c.home, c.homeErr = os.MkdirTemp("", "terraform-home")
+	})
+	if c.homeErr != nil {
+		return nil, fmt.Errorf("failed to create isolated terraform home: %w", c.homeErr)
+	}
+	return append(os.Environ(), "HOME="+c.home, "TF_DATA_DIR="+c.dataDir(subDir)), nil
+}
+
+// dataDir is the terraform data directory of subDir
+func (c *Client) dataDir(subDir string) string {
+	if !c.IsolatedHome {
+		return filepath.Join(c.Directory, subDir, ".terraform")
+	}
+	// Anchoring at / before cleaning keeps ../ in subDir from escaping the isolated home
+	return filepath.Join(c.home, "data", filepath.Clean("/"+subDir))
+}
+
+// Cleanup removes the isolated HOME, if one was created
+func (c *Client) Cleanup() error {
+	if c.home == "" {
+		return nil
+	}
+	return os.RemoveAll(c.home)
+}
+
+// ShellHook returns a PreInitHook that runs command with `sh -c` inside the directory being initialized
+func ShellHook(command string) func(ctx context.Context, dir string) error {
+	return func(ctx context.Context, dir string) error {
+		var stdout, stderr bytes.Buffer
+		result := pipe.NewPiped("sh", "-c", command).WithDir(dir).Execute(ctx, nil, &stdout, &stderr)
+		if result != nil {
+			return &execErr{
+				stdout: stdout,
+				stderr: stderr,
+				root:   result,
+			}
+		}
+		return nil
+	}
 }
 
 type execErr struct {
@@ -29,10 +113,156 @@ func (e *execErr) Error() string {
 	return fmt.Sprintf("%s:%s:%s", e.stdout.String(), e.stderr.String(), e.root.Error())
 }
 
+func (c *Client) acquireInit(ctx context.Context) (func(), error) {
+	if c.MaxConcurrentInits <= 0 {
+		return func() {}, nil
+	}
+	c.initSemOnce.Do(func() {
+		c.initSem = make(chan struct{}, c.MaxConcurrentInits)
+	})
+	select {
+	case c.initSem <- struct{}{}:
+		return func() { <-c.initSem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// initStampFile is written to the data directory after a successful init, with the initFingerprint it was done with
+const initStampFile = "drift-detection-init"
+
+// initFingerprint hashes the dependency lock file and terraform files of dir. Terraform keeps a backend config in the
+// data directory that goes stale when the backend block changes, and providers that go stale when the lock file
+// changes, so either change needs a new init.
+func initFingerprint(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, f := range append([]string{filepath.Join(dir, ".terraform.lock.hcl")}, files...) {
+		content, err := os.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s %d\n", filepath.Base(f), len(content))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isInitialized reports whether dataDir has a backend config written by an init with fingerprint
+func isInitialized(dataDir string, fingerprint string) bool {
+	if _, err := os.Stat(filepath.Join(dataDir, "terraform.tfstate")); err != nil {
+		return false
+	}
+	stamp, err := os.ReadFile(filepath.Join(dataDir, initStampFile))
+	return err == nil && string(stamp) == fingerprint
+}
+
+// HasRemoteBackend reports whether subDir, after Init, is configured with a backend other than local state. Terraform
+// only records a backend in the data directory when the configuration declares one.
+func (c *Client) HasRemoteBackend(subDir string) (bool, error) {
+	content, err := os.ReadFile(filepath.Join(c.dataDir(subDir), "terraform.tfstate"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read backend config of %s: %w", subDir, err)
+	}
+	var state struct {
+		Backend *struct {
+			Type string `json:"type"`
+		} `json:"backend"`
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return false, fmt.Errorf("failed to parse backend config of %s: %w", subDir, err)
+	}
+	return state.Backend != nil && state.Backend.Type != "" && state.Backend.Type != "local", nil
+}
+
 func (c *Client) Init(ctx context.Context, subDir string) error {
-	c.Logger.Info("Initializing terraform", zap.String("dir", subDir))
+	return c.init(ctx, subDir, c.SkipInitIfInitialized)
+}
+
+// BackendConfigs returns the partial backend configuration files, *.tfbackend, in subDir. A directory with these
+// holds one state per file, selected at init time.
+func (c *Client) BackendConfigs(subDir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(c.Directory, subDir, "*.tfbackend"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find backend configs in %s: %w", subDir, err)
+	}
+	ret := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ret = append(ret, filepath.Base(m))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// InitBackendConfig initializes subDir with the partial backend configuration file backendConfig, relative to subDir.
+// The backend is always reconfigured, since the same directory is initialized once per backend config.
+func (c *Client) InitBackendConfig(ctx context.Context, subDir string, backendConfig string) error {
+	return c.init(ctx, subDir, false, "-reconfigure", "-backend-config="+backendConfig)
+}
+
+func (c *Client) init(ctx context.Context, subDir string, skipIfInitialized bool, args ...string) error {
+	env, err := c.env(subDir)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.Directory, subDir)
+	var fingerprint string
+	if skipIfInitialized {
+		if fingerprint, err = initFingerprint(dir); err != nil {
+			return fmt.Errorf("failed to read terraform files of %s: %w", subDir, err)
+		}
+		if isInitialized(c.dataDir(subDir), fingerprint) {
+			c.Logger.Info("Terraform already initialized, skipping init", zap.String("dir", subDir))
+			return nil
+		}
+	}
+	release, err := c.acquireInit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed waiting to init %s: %w", subDir, err)
+	}
+	defer release()
+	if c.PreInitHook != nil {
+		c.Logger.Info("Running pre-init hook", zap.String("dir", subDir))
+		if err := c.PreInitHook(ctx, dir); err != nil {
+			return fmt.Errorf("pre-init hook failed in %s: %w", subDir, err)
+		}
+	}
+	backoff := c.InitBackoff
+	for attempt := 0; ; attempt++ {
+		c.Logger.Info("Initializing terraform", zap.String("dir", subDir), zap.Int("attempt", attempt+1))
+		err := runInit(ctx, dir, env, args...)
+		if err == nil && fingerprint != "" {
+			if err := os.WriteFile(filepath.Join(c.dataDir(subDir), initStampFile), []byte(fingerprint), 0644); err != nil {
+				c.Logger.Warn("Unable to record init, the next run will init again", zap.String("dir", subDir), zap.Error(err))
+			}
+		}
+		if err == nil || attempt >= c.InitRetries || !isTransientInitError(err) {
+			return err
+		}
+		if !c.RetryBudget.Take() {
+			c.Logger.Warn("Retry budget exhausted, not retrying terraform init", zap.String("dir", subDir), zap.Error(err))
+			return err
+		}
+		c.Logger.Warn("Transient terraform init failure, retrying", zap.String("dir", subDir), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func runInit(ctx context.Context, dir string, env []string, args ...string) error {
 	var stdout, stderr bytes.Buffer
-	result := pipe.NewPiped("terraform", "init", "-no-color").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
+	result := pipe.NewPiped("terraform", append([]string{"init", "-no-color"}, args...)...).WithDir(dir).WithEnv(env).Execute(ctx, nil, &stdout, &stderr)
 	if result != nil {
 		return &execErr{
 			stdout: stdout,
@@ -43,26 +273,69 @@ func (c *Client) Init(ctx context.Context, subDir string) error {
 	return nil
 }
 
+// transientInitErrorRe matches terraform init output caused by network or registry trouble, rather than by the
+// configuration itself
+var transientInitErrorRe = regexp.MustCompile(`(?i)429 Too Many Requests|rate limit|i/o timeout|TLS handshake timeout|connection reset by peer|connection refused|no such host|timeout awaiting response headers|Client\.Timeout exceeded|unexpected EOF|50[234] |Bad Gateway|Service Unavailable|Gateway Timeout`)
+
+func isTransientInitError(err error) bool {
+	var e *execErr
+	if !errors.As(err, &e) {
+		return false
+	}
+	return transientInitErrorRe.MatchString(e.stderr.String()) || transientInitErrorRe.MatchString(e.stdout.String())
+}
+
+// workspaceLineWriter parses `terraform workspace list` output as it is written, so workspaces seen before the
+// command is interrupted are kept
+type workspaceLineWriter struct {
+	partial    []byte
+	workspaces []string
+}
+
+func (w *workspaceLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		w.addLine(string(w.partial[:idx]))
+		w.partial = w.partial[idx+1:]
+	}
+}
+
+func (w *workspaceLineWriter) addLine(line string) {
+	line = strings.TrimPrefix(line, "* ")
+	line = strings.TrimSpace(line)
+	if line != "" {
+		w.workspaces = append(w.workspaces, line)
+	}
+}
+
+// ListWorkspaces lists the workspaces of subDir, parsing them as terraform prints them. If ctx is canceled first, the
+// workspaces listed so far are returned with an error wrapping the context's error.
 func (c *Client) ListWorkspaces(ctx context.Context, subDir string) ([]string, error) {
 	c.Logger.Info("Listing workspaces", zap.String("dir", subDir))
-	var stdout, stderr bytes.Buffer
-	result := pipe.NewPiped("terraform", "workspace", "list").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
+	env, err := c.env(subDir)
+	if err != nil {
+		return nil, err
+	}
+	var stdout workspaceLineWriter
+	var stdoutCopy, stderr bytes.Buffer
+	result := pipe.NewPiped("terraform", "workspace", "list").WithDir(filepath.Join(c.Directory, subDir)).WithEnv(env).Execute(ctx, nil, io.MultiWriter(&stdout, &stdoutCopy), &stderr)
 	if result != nil {
+		if ctx.Err() != nil {
+			return stdout.workspaces, fmt.Errorf("listing workspaces of %s interrupted after %d workspaces: %w", subDir, len(stdout.workspaces), ctx.Err())
+		}
 		return nil, &execErr{
-			stdout: stdout,
+			stdout: stdoutCopy,
 			stderr: stderr,
 			root:   result,
 		}
 	}
-	lines := strings.Split(stdout.String(), "\n")
-	workspaces := make([]string, 0, len(lines))
-	for _, line := range lines {
-		line = strings.TrimPrefix(line, "* ")
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		workspaces = append(workspaces, line)
+	stdout.addLine(string(stdout.partial))
+	if stdout.workspaces == nil {
+		return []string{}, nil
 	}
-	return workspaces, nil
+	return stdout.workspaces, nil
 }
@@ -2,12 +2,20 @@ package terraform
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/cresta/pipe"
 	"github.com/revdotcom/gha-atlantis-drift-detection/internal/testhelper"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestClient_Init(t *testing.T) {
@@ -46,3 +54,233 @@ func TestClient_ListWorkspaces(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []string{"default", "testing"}, workspaces)
 }
+
+// fakeTerraform puts a terraform executable running script first on PATH
+func fakeTerraform(t *testing.T, script string) {
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "terraform"), []byte("#!/bin/sh\n"+script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestClient_ListWorkspacesCanceled(t *testing.T) {
+	// exec keeps sleep in the killed process, so its end of stdout closes on cancel
+	fakeTerraform(t, "printf '  default\\n* prod\\n  partial'\nexec sleep 30\n")
+	c := Client{
+		Directory: t.TempDir(),
+		Logger:    zaptest.NewLogger(t),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	workspaces, err := c.ListWorkspaces(ctx, "")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 10*time.Second)
+	require.Equal(t, []string{"default", "prod"}, workspaces)
+}
+
+func TestClient_ListWorkspacesFake(t *testing.T) {
+	fakeTerraform(t, "printf '  default\\n* prod\\n  staging'\n")
+	c := Client{
+		Directory: t.TempDir(),
+		Logger:    zaptest.NewLogger(t),
+	}
+	workspaces, err := c.ListWorkspaces(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"default", "prod", "staging"}, workspaces)
+}
+
+func TestClient_InitPreInitHookError(t *testing.T) {
+	td := t.TempDir()
+	var hookDir string
+	c := Client{
+		Directory: td,
+		Logger:    zaptest.NewLogger(t),
+		PreInitHook: func(_ context.Context, dir string) error {
+			hookDir = dir
+			return errors.New("hook failed")
+		},
+	}
+	require.Error(t, c.Init(context.Background(), "sub"))
+	require.Equal(t, filepath.Join(td, "sub"), hookDir)
+}
+
+func TestShellHook(t *testing.T) {
+	td := t.TempDir()
+	require.NoError(t, ShellHook("touch generated.tfvars")(context.Background(), td))
+	require.FileExists(t, filepath.Join(td, "generated.tfvars"))
+}
+
+func TestClient_MaxConcurrentInits(t *testing.T) {
+	var running, maxRunning int32
+	c := Client{
+		Directory:          t.TempDir(),
+		Logger:             zaptest.NewLogger(t),
+		MaxConcurrentInits: 2,
+		PreInitHook: func(_ context.Context, _ string) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return errors.New("stop before terraform init")
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Error(t, c.Init(context.Background(), ""))
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, maxRunning, int32(2))
+}
+
+func TestClient_InitSkipIfInitialized(t *testing.T) {
+	td := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(td, ".terraform"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(td, ".terraform", "terraform.tfstate"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "main.tf"), []byte(`terraform {
+  backend "s3" {}
+}
+`), 0644))
+	hookErr := errors.New("init ran")
+	c := Client{
+		Directory:             td,
+		Logger:                zaptest.NewLogger(t),
+		SkipInitIfInitialized: true,
+		PreInitHook: func(_ context.Context, _ string) error {
+			return hookErr
+		},
+	}
+	// A backend config this client did not record is not trusted
+	require.ErrorIs(t, c.Init(context.Background(), ""), hookErr)
+
+	fingerprint, err := initFingerprint(td)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(td, ".terraform", initStampFile), []byte(fingerprint), 0644))
+	require.NoError(t, c.Init(context.Background(), ""))
+
+	// A changed lock file or backend block needs a new init
+	require.NoError(t, os.WriteFile(filepath.Join(td, ".terraform.lock.hcl"), []byte(`provider "registry.terraform.io/hashicorp/aws" {}`), 0644))
+	require.ErrorIs(t, c.Init(context.Background(), ""), hookErr)
+	require.NoError(t, os.Remove(filepath.Join(td, ".terraform.lock.hcl")))
+	require.NoError(t, c.Init(context.Background(), ""))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "main.tf"), []byte(`terraform {
+  backend "gcs" {}
+}
+`), 0644))
+	require.ErrorIs(t, c.Init(context.Background(), ""), hookErr)
+}
+
+func TestClient_HasRemoteBackend(t *testing.T) {
+	td := t.TempDir()
+	c := Client{
+		Directory: td,
+		Logger:    zaptest.NewLogger(t),
+	}
+	writeState := func(dir string, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(td, dir, ".terraform"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(td, dir, ".terraform", "terraform.tfstate"), []byte(content), 0644))
+	}
+	writeState("s3", `{"version":3,"backend":{"type":"s3","config":{"bucket":"b"}}}`)
+	writeState("local", `{"version":3,"backend":{"type":"local"}}`)
+	writeState("broken", `{`)
+	has, err := c.HasRemoteBackend("s3")
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = c.HasRemoteBackend("local")
+	require.NoError(t, err)
+	require.False(t, has)
+	has, err = c.HasRemoteBackend("uninitialized")
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = c.HasRemoteBackend("broken")
+	require.Error(t, err)
+}
+
+func TestClient_BackendConfigs(t *testing.T) {
+	td := t.TempDir()
+	c := Client{
+		Directory: td,
+		Logger:    zaptest.NewLogger(t),
+	}
+	configs, err := c.BackendConfigs("")
+	require.NoError(t, err)
+	require.Empty(t, configs)
+	for _, name := range []string{"prod.tfbackend", "dev.tfbackend", "main.tf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(td, name), []byte(""), 0644))
+	}
+	configs, err = c.BackendConfigs("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dev.tfbackend", "prod.tfbackend"}, configs)
+}
+
+func TestIsTransientInitError(t *testing.T) {
+	newErr := func(stderr string) error {
+		e := &execErr{root: errors.New("exit status 1")}
+		e.stderr.WriteString(stderr)
+		return e
+	}
+	require.True(t, isTransientInitError(newErr("Error: Failed to query available provider packages\n\nCould not retrieve the list of available versions: 429 Too Many Requests")))
+	require.True(t, isTransientInitError(fmt.Errorf("wrapped: %w", newErr("dial tcp: lookup registry.terraform.io: no such host"))))
+	require.False(t, isTransientInitError(newErr("Error: Unsupported block type")))
+	require.False(t, isTransientInitError(errors.New("429 Too Many Requests")))
+}
+
+func TestClient_IsolatedHome(t *testing.T) {
+	c := Client{
+		Directory:    t.TempDir(),
+		Logger:       zaptest.NewLogger(t),
+		IsolatedHome: true,
+	}
+	env, err := c.env("envs/prod")
+	require.NoError(t, err)
+	require.Contains(t, env, "HOME="+c.home)
+	require.Contains(t, env, "TF_DATA_DIR="+filepath.Join(c.home, "data", "envs", "prod"))
+	require.True(t, strings.HasPrefix(c.dataDir("../../escape"), c.home))
+	require.DirExists(t, c.home)
+	require.NoError(t, c.Cleanup())
+	require.NoDirExists(t, c.home)
+
+	env, err = (&Client{}).env("envs/prod")
+	require.NoError(t, err)
+	require.Nil(t, env)
+}
+
+func TestClient_VerifyVersion(t *testing.T) {
+	fakeTerraform(t, "echo '{\"terraform_version\": \"1.6.2\", \"platform\": \"linux_amd64\"}'\n")
+	c := Client{Logger: zaptest.NewLogger(t)}
+	v, err := c.Version(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "1.6.2", v)
+	require.NoError(t, c.VerifyVersion(context.Background()))
+	c.RequiredVersion = "~> 1.6"
+	require.NoError(t, c.VerifyVersion(context.Background()))
+	c.RequiredVersion = ">= 1.7"
+	require.ErrorContains(t, c.VerifyVersion(context.Background()), "does not satisfy")
+
+	fakeTerraform(t, "echo 'boom' >&2\nexit 1\n")
+	_, err = c.Version(context.Background())
+	require.Error(t, err)
+	require.ErrorContains(t, c.VerifyVersion(context.Background()), "unable to find terraform version")
+	c.RequiredVersion = ""
+	require.NoError(t, c.VerifyVersion(context.Background()), "without a required version a missing binary only warns")
+
+	fakeTerraform(t, "echo 'Terraform v1.6.2'\n")
+	_, err = c.Version(context.Background())
+	require.ErrorContains(t, err, "unable to parse terraform version output")
+}
+
+func TestCheckVersion(t *testing.T) {
+	require.NoError(t, CheckVersion("1.6.2", ">= 1.5, < 2.0"))
+	require.NoError(t, CheckVersion("1.6.2", "~> 1.6"))
+	require.ErrorContains(t, CheckVersion("1.4.0", ">= 1.5"), "does not satisfy")
+	require.Error(t, CheckVersion("1.6.2", "nope"))
+	require.Error(t, CheckVersion("", ">= 1.5"))
+}
@@ -0,0 +1,68 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cresta/pipe"
+	"github.com/hashicorp/go-version"
+	"go.uber.org/zap"
+)
+
+// Version returns the version of the terraform binary, as reported by `terraform version`. OpenTofu reports its own
+// version the same way.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var stdout, stderr bytes.Buffer
+	result := pipe.NewPiped("terraform", "version", "-json").Execute(ctx, nil, &stdout, &stderr)
+	if result != nil {
+		return "", &execErr{
+			stdout: stdout,
+			stderr: stderr,
+			root:   result,
+		}
+	}
+	var out struct {
+		TerraformVersion string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return "", fmt.Errorf("unable to parse terraform version output %q: %w", stdout.String(), err)
+	}
+	return out.TerraformVersion, nil
+}
+
+// CheckVersion returns an error if v does not satisfy constraint, which is written like a terraform required_version,
+// such as ">= 1.5, < 2.0" or "~> 1.6"
+func CheckVersion(v string, constraint string) error {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return fmt.Errorf("invalid terraform version constraint %q: %w", constraint, err)
+	}
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return fmt.Errorf("invalid terraform version %q: %w", v, err)
+	}
+	if !constraints.Check(parsed) {
+		return fmt.Errorf("terraform %s does not satisfy required version %s", v, constraint)
+	}
+	return nil
+}
+
+// VerifyVersion logs the version of the terraform binary and, if RequiredVersion is set, fails unless it satisfies
+// it, so a wrong binary fails the run up front instead of as init errors in every directory
+func (c *Client) VerifyVersion(ctx context.Context) error {
+	v, err := c.Version(ctx)
+	if err != nil {
+		if c.RequiredVersion == "" {
+			c.Logger.Warn("Unable to find terraform version", zap.Error(err))
+			return nil
+		}
+		return fmt.Errorf("unable to find terraform version: %w", err)
+	}
+	c.Logger.Info("Found terraform", zap.String("version", v))
+	if c.RequiredVersion == "" {
+		return nil
+	}
+	return CheckVersion(v, c.RequiredVersion)
+}